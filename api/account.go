@@ -0,0 +1,239 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/mailer"
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"gorm.io/gorm"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = time.Hour
+)
+
+type AccountService interface {
+	SendVerification(username string) error
+	VerifyEmail(*models.VerifyEmailRequest) error
+	RequestPasswordReset(*models.PasswordResetRequest) error
+	ResetPassword(*models.ConfirmPasswordResetRequest) error
+}
+
+type accountService struct {
+	store.UsersStore
+	store.UserTokenStore
+	passwords *Passwords
+	mailer    mailer.Mailer
+	baseURL   string
+}
+
+func NewAccountService(usersStore store.UsersStore, userTokenStore store.UserTokenStore, passwords *Passwords, m mailer.Mailer, baseURL string) AccountService {
+	return &accountService{
+		UsersStore:     usersStore,
+		UserTokenStore: userTokenStore,
+		passwords:      passwords,
+		mailer:         m,
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// newToken returns a random token for the link sent to the user, and the hash of it which is the only thing stored.
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueToken replaces the unused tokens of the user for the purpose with a new one and returns it.
+func (as *accountService) issueToken(username, purpose string, ttl time.Duration) (string, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	db := as.UserTokenStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		userTokenStore := store.NewUserTokenStore(tx)
+
+		if err := userTokenStore.Delete(map[string]interface{}{
+			"username": username,
+			"purpose":  purpose,
+			"used_at":  nil,
+		}); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return userTokenStore.Create(models.UserTokenDBModel{
+			Username:  username,
+			Purpose:   purpose,
+			TokenHash: tokenHash,
+			ExpiresAt: now.Add(ttl),
+			CreatedAt: now,
+		})
+	}); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// useToken marks the token as used and returns it, unless it doesn't exist, was already used or expired. It is meant
+// to run inside the transaction that acts on the token.
+func useToken(tx *gorm.DB, token, purpose string) (*models.UserTokenDBModel, error) {
+	userTokenStore := store.NewUserTokenStore(tx)
+
+	userToken, err := userTokenStore.GetOneForUpdate(map[string]interface{}{
+		"token_hash": hashToken(token),
+		"purpose":    purpose,
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrInvalidToken
+		}
+
+		return nil, err
+	}
+
+	now := time.Now()
+	if userToken.UsedAt != nil || now.After(userToken.ExpiresAt) {
+		return nil, models.ErrInvalidToken
+	}
+
+	if err := userTokenStore.Update(map[string]interface{}{"used_at": now}, map[string]interface{}{"id": userToken.ID}); err != nil {
+		return nil, err
+	}
+
+	return userToken, nil
+}
+
+func (as *accountService) link(path, token string) string {
+	return as.baseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+func (as *accountService) SendVerification(username string) error {
+	user, err := as.UsersStore.GetOne(map[string]interface{}{"username": username})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return models.ErrUserNotFound
+		}
+
+		return err
+	}
+
+	if user.EmailVerifiedAt != nil {
+		return models.ErrEmailAlreadyVerified
+	}
+
+	token, err := as.issueToken(username, models.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return as.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: "Hello " + username + ",\n\n" +
+			"Open this link within 24 hours to verify your email:\n" +
+			as.link("/verify-email", token),
+	})
+}
+
+func (as *accountService) VerifyEmail(req *models.VerifyEmailRequest) error {
+	db := as.UserTokenStore.DB()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		userToken, err := useToken(tx, req.Token, models.TokenPurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+
+		return store.NewUsersStore(tx).Update(map[string]interface{}{
+			"email_verified_at": time.Now(),
+		}, map[string]interface{}{"username": userToken.Username})
+	})
+}
+
+// RequestPasswordReset mails a reset link to the owner of the email. Nothing tells the caller whether the email
+// belongs to anyone, so the endpoint can't be used to find out who has an account.
+func (as *accountService) RequestPasswordReset(req *models.PasswordResetRequest) error {
+	user, err := as.UsersStore.GetOne(map[string]interface{}{"email": strings.ToLower(strings.TrimSpace(req.Email))})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	token, err := as.issueToken(user.Username, models.TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return as.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hello " + user.Username + ",\n\n" +
+			"Open this link within an hour to choose a new password:\n" +
+			as.link("/reset-password", token) + "\n\n" +
+			"If you didn't ask for it, you can ignore this email.",
+	})
+}
+
+// ResetPassword sets the new password of the owner of the token and signs the user out everywhere, so whoever knew
+// the old password loses the sessions and refresh tokens made with it. The password policy of signup applies, its
+// violations are returned as a models.ValidationError without using the token.
+func (as *accountService) ResetPassword(req *models.ConfirmPasswordResetRequest) error {
+	db := as.UserTokenStore.DB()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		usersStore := store.NewUsersStore(tx)
+
+		userToken, err := useToken(tx, req.Token, models.TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		if msg := validatePassword(req.Password, userToken.Username); msg != "" {
+			return models.ValidationError{"password": msg}
+		}
+
+		hash, err := as.passwords.Hash(req.Password)
+		if err != nil {
+			return err
+		}
+
+		// the link was received by email, which is as good as verifying it
+		if err := usersStore.Update(map[string]interface{}{
+			"password_hash":     hash,
+			"email_verified_at": gorm.Expr("COALESCE(email_verified_at, ?)", time.Now()),
+		}, map[string]interface{}{"username": userToken.Username}); err != nil {
+			return err
+		}
+
+		if err := store.NewSessionStore(tx).Delete(map[string]interface{}{"username": userToken.Username}); err != nil {
+			return err
+		}
+
+		return store.NewRefreshTokenStore(tx).Delete(map[string]interface{}{"username": userToken.Username})
+	})
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"gorm.io/gorm"
+)
+
+type AdminService interface {
+	List() ([]models.AdminDBModel, error)
+	Grant(*models.GrantAdminRequest) error
+	Revoke(*models.RevokeAdminRequest) error
+	GetChanges() ([]models.AdminChangeDBModel, error)
+}
+
+type adminService struct {
+	store.AdminsStore
+	store.AdminChangeStore
+}
+
+func NewAdminService(adminsStore store.AdminsStore, adminChangeStore store.AdminChangeStore) AdminService {
+	return &adminService{
+		AdminsStore:      adminsStore,
+		AdminChangeStore: adminChangeStore,
+	}
+}
+
+func (as *adminService) List() ([]models.AdminDBModel, error) {
+	admins, err := as.AdminsStore.GetMany(nil)
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	return admins, nil
+}
+
+// Grant makes the user an admin and records the change in the same transaction.
+func (as *adminService) Grant(req *models.GrantAdminRequest) error {
+	db := as.AdminsStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		usersStore := store.NewUsersStore(tx)
+		adminsStore := store.NewAdminsStore(tx)
+		adminChangeStore := store.NewAdminChangeStore(tx)
+
+		exists, err := usersStore.IsExists(map[string]interface{}{"username": req.Username})
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return models.ErrUserNotFound
+		}
+
+		isAdmin, err := adminsStore.IsExists(map[string]interface{}{"username": req.Username})
+		if err != nil {
+			return err
+		}
+
+		if isAdmin {
+			return models.ErrAlreadyAdmin
+		}
+
+		if err := adminsStore.Create(models.AdminDBModel{Username: req.Username}); err != nil {
+			return err
+		}
+
+		return adminChangeStore.Create(models.AdminChangeDBModel{
+			Username:  req.Username,
+			Action:    models.AdminChangeGrant,
+			Role:      models.RoleSuperAdmin,
+			ChangedBy: req.GrantedBy,
+			ChangedAt: time.Now(),
+		})
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("admin granted to %s by %s", req.Username, req.GrantedBy)
+	return nil
+}
+
+// Revoke removes the user from the admins, unless it is the last one. Every admin row is locked first so two admins
+// demoting each other at the same time can't leave the market without any.
+func (as *adminService) Revoke(req *models.RevokeAdminRequest) error {
+	db := as.AdminsStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		adminsStore := store.NewAdminsStore(tx)
+		adminChangeStore := store.NewAdminChangeStore(tx)
+
+		admins, err := adminsStore.GetManyForUpdate(nil)
+		if err != nil && err != models.ErrMatchingRecordNotFound {
+			return err
+		}
+
+		found := false
+		for _, admin := range admins {
+			if admin.Username == req.Username {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return models.ErrNotAdmin
+		}
+
+		if len(admins) == 1 {
+			return models.ErrLastAdmin
+		}
+
+		if err := adminsStore.Delete(map[string]interface{}{"username": req.Username}); err != nil {
+			return err
+		}
+
+		return adminChangeStore.Create(models.AdminChangeDBModel{
+			Username:  req.Username,
+			Action:    models.AdminChangeRevoke,
+			Role:      models.RoleSuperAdmin,
+			ChangedBy: req.RevokedBy,
+			ChangedAt: time.Now(),
+		})
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("admin revoked from %s by %s", req.Username, req.RevokedBy)
+	return nil
+}
+
+func (as *adminService) GetChanges() ([]models.AdminChangeDBModel, error) {
+	changes, err := as.AdminChangeStore.GetMany(nil)
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	return changes, nil
+}
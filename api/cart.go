@@ -0,0 +1,175 @@
+package api
+
+import (
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"gorm.io/gorm"
+)
+
+type CartService interface {
+	AddItem(*models.AddCartItemRequest) error
+	UpdateItem(*models.UpdateCartItemRequest) error
+	RemoveItem(*models.RemoveCartItemRequest) error
+	Get(username string) (*models.CartResponse, error)
+	Checkout(username string) (*models.CreateOrderResponse, error)
+}
+
+type cartService struct {
+	store.CartStore
+	store.ProductStore
+	store.InventoryStore
+}
+
+func NewCartService(cartStore store.CartStore, productStore store.ProductStore, inventoryStore store.InventoryStore) CartService {
+	return &cartService{
+		CartStore:      cartStore,
+		ProductStore:   productStore,
+		InventoryStore: inventoryStore,
+	}
+}
+
+func (cs *cartService) AddItem(req *models.AddCartItemRequest) error {
+	if req.Quantity <= 0 {
+		return models.ErrInvalidQuantity
+	}
+
+	exists, err := cs.ProductStore.IsExists(map[string]interface{}{"product_name": req.ProductName})
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrProductNotFound
+	}
+
+	return cs.CartStore.Add(models.CartItemDBModel{
+		Username:    req.Username,
+		ProductName: req.ProductName,
+		Quantity:    req.Quantity,
+		AddedAt:     time.Now(),
+	})
+}
+
+func (cs *cartService) UpdateItem(req *models.UpdateCartItemRequest) error {
+	if req.Quantity < 0 {
+		return models.ErrInvalidQuantity
+	}
+
+	whereMap := map[string]interface{}{"username": req.Username, "product_name": req.ProductName}
+
+	exists, err := cs.CartStore.IsExists(whereMap)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrCartItemNotFound
+	}
+
+	// setting the quantity to zero is the same as removing the item
+	if req.Quantity == 0 {
+		return cs.CartStore.Delete(whereMap)
+	}
+
+	return cs.CartStore.Update(map[string]interface{}{"quantity": req.Quantity}, whereMap)
+}
+
+func (cs *cartService) RemoveItem(req *models.RemoveCartItemRequest) error {
+	whereMap := map[string]interface{}{"username": req.Username, "product_name": req.ProductName}
+
+	exists, err := cs.CartStore.IsExists(whereMap)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrCartItemNotFound
+	}
+
+	return cs.CartStore.Delete(whereMap)
+}
+
+func (cs *cartService) Get(username string) (*models.CartResponse, error) {
+	resp := &models.CartResponse{
+		Items: []models.CartItemResponse{},
+	}
+
+	items, err := cs.CartStore.GetMany(map[string]interface{}{"username": username})
+	if err != nil {
+		if err == models.ErrMatchingRecordNotFound {
+			return resp, nil
+		}
+
+		return nil, err
+	}
+
+	for _, item := range items {
+		product, err := cs.ProductStore.GetOne(map[string]interface{}{"product_name": item.ProductName})
+		if err != nil {
+			return nil, err
+		}
+
+		inventory, err := cs.InventoryStore.GetOne(map[string]interface{}{"product_name": item.ProductName})
+		if err != nil {
+			return nil, err
+		}
+
+		resp.TotalCost += product.Price * item.Quantity
+		resp.Items = append(resp.Items, models.CartItemResponse{
+			ProductName:     item.ProductName,
+			Quantity:        item.Quantity,
+			UnitPrice:       product.Price,
+			LineTotal:       product.Price * item.Quantity,
+			QuantityInStock: inventory.QuantityInStock,
+			Available:       inventory.QuantityInStock >= item.Quantity,
+		})
+	}
+
+	return resp, nil
+}
+
+// Checkout places an order for everything in the cart of the user and empties the cart, both in the same transaction
+// so a failed order leaves the cart untouched.
+func (cs *cartService) Checkout(username string) (*models.CreateOrderResponse, error) {
+	var resp *models.CreateOrderResponse
+	db := cs.CartStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		cartStore := store.NewCartStore(tx)
+		orderService := NewOrderService(store.NewOrdersStore(tx), store.NewOrderPerProductStore(tx), store.NewProductsStore(tx), store.NewInventoryStore(tx), store.NewOrderCancellationStore(tx))
+
+		items, err := cartStore.GetMany(map[string]interface{}{"username": username})
+		if err != nil {
+			if err == models.ErrMatchingRecordNotFound {
+				return models.ErrCartEmpty
+			}
+
+			return err
+		}
+
+		req := &models.CreateOrderRequest{
+			Username: username,
+			Lines:    []models.OrderLineRequest{},
+		}
+
+		for _, item := range items {
+			req.Lines = append(req.Lines, models.OrderLineRequest{
+				ProductName: item.ProductName,
+				Quantity:    item.Quantity,
+			})
+		}
+
+		resp, err = orderService.Create(req)
+		if err != nil {
+			return err
+		}
+
+		return cartStore.Delete(map[string]interface{}{"username": username})
+	}); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
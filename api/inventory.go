@@ -1,62 +1,219 @@
 package api
 
 import (
+	"time"
+
 	"github.com/NikhilSharmaWe/market/models"
 	"github.com/NikhilSharmaWe/market/store"
+	"gorm.io/gorm"
 )
 
+// setInventoryAttempts bounds the retries of Set while orders keep changing the stock
+const setInventoryAttempts = 5
+
 type InventoryService interface {
-	Update(*models.UpdateInventoryRequest) error
+	Get(*models.GetInventoryRequest) (*models.InventoryResponse, error)
+	Update(*models.UpdateInventoryRequest) (*models.InventoryResponse, error)
+	GetLedger(*models.GetInventoryLedgerRequest) (*models.InventoryLedgerResponse, error)
+	Set(*models.SetInventoryRequest) (*models.SetInventoryResponse, error)
 }
 
 type inventoryService struct {
 	store.ProductStore
 	store.InventoryStore
+	store.InventoryMovementStore
 }
 
-func NewInventoryService(productStore store.ProductStore, inventoryStore store.InventoryStore) InventoryService {
+func NewInventoryService(productStore store.ProductStore, inventoryStore store.InventoryStore, inventoryMovementStore store.InventoryMovementStore) InventoryService {
 	return &inventoryService{
-		ProductStore:   productStore,
-		InventoryStore: inventoryStore,
+		ProductStore:           productStore,
+		InventoryStore:         inventoryStore,
+		InventoryMovementStore: inventoryMovementStore,
+	}
+}
+
+func (is *inventoryService) Get(req *models.GetInventoryRequest) (*models.InventoryResponse, error) {
+	exists, err := is.ProductStore.IsExists(map[string]interface{}{"product_name": req.ProductName})
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, models.ErrProductNotFound
+	}
+
+	inventory, err := is.InventoryStore.GetOne(map[string]interface{}{"product_name": req.ProductName})
+	if err != nil {
+		return nil, err
 	}
+
+	return &models.InventoryResponse{
+		ProductName:     inventory.ProductName,
+		QuantityInStock: inventory.QuantityInStock,
+		Version:         inventory.Version,
+	}, nil
 }
 
-func (is *inventoryService) Update(req *models.UpdateInventoryRequest) error {
+// Update applies the adjustment with a single conditional update and returns the resulting stock. When the request
+// carries an expected version, the adjustment is refused with ErrVersionConflict if the stock changed since then.
+func (is *inventoryService) Update(req *models.UpdateInventoryRequest) (*models.InventoryResponse, error) {
 	if req.Operation != "add" && req.Operation != "remove" {
-		return models.ErrInvalidOperaton
+		return nil, models.ErrInvalidOperaton
+	}
+
+	// an empty adjustment would still bump the version, conflicting with the other admins for nothing
+	if req.Quantity <= 0 {
+		return nil, models.ErrInvalidQuantity
 	}
 
 	exists, err := is.ProductStore.IsExists(map[string]interface{}{"product_name": req.ProductName})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if !exists {
-		return models.ErrProductNotFound
+		return nil, models.ErrProductNotFound
 	}
 
-	inventory, err := is.InventoryStore.GetOne(map[string]interface{}{"product_name": req.ProductName})
-	if err != nil {
-		return err
+	delta := req.Quantity
+	if req.Operation == "remove" {
+		delta = -req.Quantity
 	}
 
-	var uq int
+	var resp *models.InventoryResponse
+	db := is.InventoryStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		inventoryStore := store.NewInventoryStore(tx)
+		inventoryMovementStore := store.NewInventoryMovementStore(tx)
+
+		updated, err := inventoryStore.Adjust(req.ProductName, delta, req.ExpectedVersion)
+		if err != nil {
+			return err
+		}
+
+		inventory, err := inventoryStore.GetOne(map[string]interface{}{"product_name": req.ProductName})
+		if err != nil {
+			return err
+		}
+
+		if !updated {
+			if req.ExpectedVersion != nil && inventory.Version != *req.ExpectedVersion {
+				return models.ErrVersionConflict
+			}
 
-	if req.Operation == "add" {
-		uq = inventory.QuantityInStock + req.Quantity
-	} else {
-		uq = inventory.QuantityInStock - req.Quantity
-		if uq < 0 {
 			return models.ErrInvalidQuantity
 		}
-	}
 
-	inventory.QuantityInStock = uq
-	if err := is.InventoryStore.Update(map[string]interface{}{
-		"quantity_in_stock": uq,
-	}, map[string]interface{}{"product_name": req.ProductName}); err != nil {
+		reason := models.MovementAdminAdd
+		if req.Operation == "remove" {
+			reason = models.MovementAdminRemove
+		}
+
+		if err := inventoryMovementStore.Create(models.InventoryMovementDBModel{
+			ProductName: req.ProductName,
+			Delta:       delta,
+			Reason:      reason,
+			Actor:       req.UpdatedBy,
+			CreatedAt:   time.Now(),
+		}); err != nil {
+			return err
+		}
+
+		resp = &models.InventoryResponse{
+			ProductName:     inventory.ProductName,
+			QuantityInStock: inventory.QuantityInStock,
+			Version:         inventory.Version,
+		}
+
+		_, err = repriceProducts(tx, []string{req.ProductName}, false)
 		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Set sets the stock of the product to an absolute quantity. The difference with the current stock goes through Update
+// so it is recorded in the ledger, and it is retried if an order changed the stock meanwhile.
+func (is *inventoryService) Set(req *models.SetInventoryRequest) (*models.SetInventoryResponse, error) {
+	if req.Quantity < 0 {
+		return nil, models.ErrInvalidQuantity
+	}
+
+	for attempt := 0; attempt < setInventoryAttempts; attempt++ {
+		inventory, err := is.Get(&models.GetInventoryRequest{ProductName: req.ProductName})
+		if err != nil {
+			return nil, err
+		}
+
+		if inventory.QuantityInStock == req.Quantity {
+			return &models.SetInventoryResponse{InventoryResponse: *inventory}, nil
+		}
+
+		update := &models.UpdateInventoryRequest{
+			ProductName:     req.ProductName,
+			Operation:       "add",
+			Quantity:        req.Quantity - inventory.QuantityInStock,
+			ExpectedVersion: &inventory.Version,
+			UpdatedBy:       req.UpdatedBy,
+		}
+
+		if update.Quantity < 0 {
+			update.Operation = "remove"
+			update.Quantity = -update.Quantity
+		}
+
+		resp, err := is.Update(update)
+		if err == models.ErrVersionConflict {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &models.SetInventoryResponse{InventoryResponse: *resp, Changed: true}, nil
 	}
 
-	return nil
+	return nil, models.ErrVersionConflict
+}
+
+// GetLedger lists every recorded movement of the product and reconciles their sum against the current stock.
+func (is *inventoryService) GetLedger(req *models.GetInventoryLedgerRequest) (*models.InventoryLedgerResponse, error) {
+	product, err := is.ProductStore.GetOne(map[string]interface{}{"product_name": req.ProductName})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrProductNotFound
+		}
+
+		return nil, err
+	}
+
+	// the movements of a deleted product with the same name are kept but aren't part of this ledger
+	movements, err := is.InventoryMovementStore.GetSince(req.ProductName, product.CreatedAt)
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	inventory, err := is.InventoryStore.GetOne(map[string]interface{}{"product_name": req.ProductName})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &models.InventoryLedgerResponse{
+		ProductName:     req.ProductName,
+		Movements:       movements,
+		QuantityInStock: inventory.QuantityInStock,
+	}
+
+	for _, movement := range movements {
+		resp.LedgerTotal += movement.Delta
+	}
+
+	resp.Discrepancy = resp.QuantityInStock - resp.LedgerTotal
+	resp.Reconciled = resp.Discrepancy == 0
+
+	return resp, nil
 }
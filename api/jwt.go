@@ -0,0 +1,264 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"github.com/golang-jwt/jwt/v5"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// jwtAudience is the aud claim of the access tokens, the market only accepts its own
+	jwtAudience = "market"
+
+	defaultJWTAccessTTL  = 15 * time.Minute
+	defaultJWTRefreshTTL = 30 * 24 * time.Hour
+)
+
+// AuthTokenService issues the jwt access tokens of the api clients that sign in with a password, with refresh tokens
+// to get new ones.
+type AuthTokenService interface {
+	Issue(*models.AuthTokenRequest) (*models.AuthTokenResponse, error)
+	Revoke(*models.RevokeRefreshTokenRequest) error
+	Verify(accessToken string) (string, error)
+	JWKS() models.JWKSResponse
+}
+
+type authTokenService struct {
+	store.RefreshTokenStore
+	userService      UserService
+	twoFactorService TwoFactorService
+	// keys sign the access tokens with the first one, the next ones still verify the tokens signed before a rotation
+	keys       []*ecdsa.PrivateKey
+	issuer     string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewAuthTokenService(refreshTokenStore store.RefreshTokenStore, userService UserService, twoFactorService TwoFactorService,
+	keys []*ecdsa.PrivateKey, issuer string, accessTTL, refreshTTL time.Duration) AuthTokenService {
+	return &authTokenService{
+		RefreshTokenStore: refreshTokenStore,
+		userService:       userService,
+		twoFactorService:  twoFactorService,
+		keys:              keys,
+		issuer:            issuer,
+		accessTTL:         accessTTL,
+		refreshTTL:        refreshTTL,
+	}
+}
+
+// jwk returns the public key in the json web key format, its kid is the thumbprint of RFC 7638.
+func jwk(key *ecdsa.PrivateKey) models.JWK {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	x := base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+	y := base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+
+	thumbprint := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, x, y)))
+
+	return models.JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   x,
+		Y:   y,
+		Kid: base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+		Use: "sig",
+		Alg: jwt.SigningMethodES256.Alg(),
+	}
+}
+
+func (ats *authTokenService) JWKS() models.JWKSResponse {
+	resp := models.JWKSResponse{Keys: []models.JWK{}}
+	for _, key := range ats.keys {
+		resp.Keys = append(resp.Keys, jwk(key))
+	}
+
+	return resp
+}
+
+// Issue answers the password grant, checked like the sign in so the same lockouts and second factor apply, and the
+// refresh_token grant, which replaces the refresh token with a new one. A refresh token used twice was stolen by one
+// of the two clients, the whole chain of tokens it belongs to is revoked and models.ErrRefreshTokenReused returned.
+func (ats *authTokenService) Issue(req *models.AuthTokenRequest) (*models.AuthTokenResponse, error) {
+	switch req.GrantType {
+	case models.GrantTypePassword:
+		user, err := ats.userService.Authenticate(&models.SignInRequest{
+			Username: req.Username,
+			Password: req.Password,
+			IP:       req.IP,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if user.TOTPEnabledAt != nil {
+			if req.TOTPCode == "" {
+				return nil, models.ErrTOTPCodeRequired
+			}
+
+			if err := ats.twoFactorService.Verify(&models.VerifyTOTPRequest{
+				Username: user.Username,
+				Code:     req.TOTPCode,
+				IP:       req.IP,
+			}); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := ats.RefreshTokenStore.DeleteExpired(user.Username, time.Now()); err != nil {
+			return nil, err
+		}
+
+		return ats.issue(ats.RefreshTokenStore, user.Username, uuid.NewV4().String())
+	case models.GrantTypeRefreshToken:
+		return ats.refresh(req.RefreshToken)
+	}
+
+	return nil, models.ErrUnsupportedGrantType
+}
+
+func (ats *authTokenService) refresh(refreshToken string) (*models.AuthTokenResponse, error) {
+	var (
+		resp   *models.AuthTokenResponse
+		reused bool
+	)
+
+	db := ats.RefreshTokenStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		refreshTokenStore := store.NewRefreshTokenStore(tx)
+
+		token, err := refreshTokenStore.GetOneForUpdate(map[string]interface{}{"token_hash": hashToken(refreshToken)})
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return models.ErrInvalidRefreshToken
+			}
+
+			return err
+		}
+
+		// the family is deleted within the transaction, which must commit for it to happen
+		if token.UsedAt != nil {
+			reused = true
+			return refreshTokenStore.Delete(map[string]interface{}{"family": token.Family})
+		}
+
+		now := time.Now()
+		if !now.Before(token.ExpiresAt) {
+			return models.ErrInvalidRefreshToken
+		}
+
+		if err := refreshTokenStore.Update(map[string]interface{}{"used_at": now}, map[string]interface{}{"id": token.ID}); err != nil {
+			return err
+		}
+
+		resp, err = ats.issue(refreshTokenStore, token.Username, token.Family)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	if reused {
+		return nil, models.ErrRefreshTokenReused
+	}
+
+	return resp, nil
+}
+
+// issue signs an access token for the user and stores a new refresh token of the family.
+func (ats *authTokenService) issue(refreshTokenStore store.RefreshTokenStore, username, family string) (*models.AuthTokenResponse, error) {
+	now := time.Now()
+
+	jti, _, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    ats.issuer,
+		Subject:   username,
+		Audience:  jwt.ClaimStrings{jwtAudience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(ats.accessTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        jti,
+	})
+	token.Header["kid"] = jwk(ats.keys[0]).Kid
+
+	accessToken, err := token.SignedString(ats.keys[0])
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshTokenHash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := refreshTokenStore.Create(models.RefreshTokenDBModel{
+		Username:  username,
+		Family:    family,
+		TokenHash: refreshTokenHash,
+		ExpiresAt: now.Add(ats.refreshTTL),
+		CreatedAt: now,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &models.AuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(ats.accessTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// Revoke signs out the client holding the refresh token, its access tokens stay valid until they expire. Unknown
+// tokens are ignored, there is nothing left to revoke.
+func (ats *authTokenService) Revoke(req *models.RevokeRefreshTokenRequest) error {
+	token, err := ats.RefreshTokenStore.GetOne(map[string]interface{}{"token_hash": hashToken(req.RefreshToken)})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	return ats.RefreshTokenStore.Delete(map[string]interface{}{"family": token.Family})
+}
+
+// Verify returns the user the access token was issued to, or models.ErrInvalidAccessToken.
+func (ats *authTokenService) Verify(accessToken string) (string, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range ats.keys {
+			if jwk(key).Kid == kid {
+				return &key.PublicKey, nil
+			}
+		}
+
+		return nil, models.ErrInvalidAccessToken
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}),
+		jwt.WithIssuer(ats.issuer),
+		jwt.WithAudience(jwtAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return "", models.ErrInvalidAccessToken
+	}
+
+	claims := token.Claims.(*jwt.RegisteredClaims)
+	if claims.Subject == "" {
+		return "", models.ErrInvalidAccessToken
+	}
+
+	return claims.Subject, nil
+}
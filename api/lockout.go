@@ -0,0 +1,190 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"gorm.io/gorm"
+)
+
+const (
+	// failed sign ins allowed before the first lockout, an address is shared by more people than an account
+	accountFreeAttempts = 5
+	ipFreeAttempts      = 20
+
+	// the first lockout lasts lockoutBase, every failure after it doubles it up to lockoutMax
+	lockoutBase = time.Minute
+	lockoutMax  = time.Hour
+
+	// failures are forgotten once none happened for failureWindow
+	failureWindow = 24 * time.Hour
+
+	decoyPassword = "decoy password 0"
+)
+
+// lockoutDuration returns how long the failures lock the account or address out, 0 while they are still allowed.
+func lockoutDuration(failures, freeAttempts int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+
+	d := lockoutBase
+	for i := freeAttempts; i < failures && d < lockoutMax; i++ {
+		d *= 2
+	}
+
+	if d > lockoutMax {
+		d = lockoutMax
+	}
+
+	return d
+}
+
+// decoy returns a hash of the configured algorithm to verify the passwords of unknown users against, so the response
+// time doesn't tell whether a username exists.
+func (us *userService) decoy() []byte {
+	us.decoyOnce.Do(func() {
+		hash, err := us.passwords.Hash(decoyPassword)
+		if err != nil {
+			log.Printf("hashing the decoy password: %v", err)
+		}
+
+		us.decoyHash = hash
+	})
+
+	return us.decoyHash
+}
+
+// loginSubject is an account or address the failed sign ins are counted for.
+type loginSubject struct {
+	kind         string
+	subject      string
+	freeAttempts int
+}
+
+// loginSubjects returns what the failures of the request are counted for. Requests without an address, like the ones
+// of the cli, are only counted for the account.
+func loginSubjects(req *models.SignInRequest) []loginSubject {
+	subjects := []loginSubject{{models.LoginFailureAccount, req.Username, accountFreeAttempts}}
+
+	if req.IP != "" {
+		subjects = append(subjects, loginSubject{models.LoginFailureIP, req.IP, ipFreeAttempts})
+	}
+
+	return subjects
+}
+
+func (us *userService) isLockedOut(req *models.SignInRequest) (bool, error) {
+	return lockedOut(us.LoginFailureStore, loginSubjects(req))
+}
+
+// recordFailure counts the failed sign in and returns models.ErrInvalidCredentials, unless counting it failed.
+func (us *userService) recordFailure(req *models.SignInRequest) error {
+	if err := countFailure(us.LoginFailureStore, loginSubjects(req)); err != nil {
+		return err
+	}
+
+	return models.ErrInvalidCredentials
+}
+
+// lockedOut reports whether one of the subjects is locked out.
+func lockedOut(loginFailureStore store.LoginFailureStore, subjects []loginSubject) (bool, error) {
+	now := time.Now()
+
+	for _, s := range subjects {
+		failure, err := loginFailureStore.GetOne(map[string]interface{}{"kind": s.kind, "subject": s.subject})
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				continue
+			}
+
+			return false, err
+		}
+
+		if failure.LockedUntil != nil && now.Before(*failure.LockedUntil) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// countFailure counts a failure for every subject, locking out the ones that had too many.
+func countFailure(loginFailureStore store.LoginFailureStore, subjects []loginSubject) error {
+	db := loginFailureStore.DB()
+
+	for _, s := range subjects {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			loginFailureStore := store.NewLoginFailureStore(tx)
+			whereMap := map[string]interface{}{"kind": s.kind, "subject": s.subject}
+			now := time.Now()
+
+			if err := loginFailureStore.CreateIfNotExists(models.LoginFailureDBModel{
+				Kind:         s.kind,
+				Subject:      s.subject,
+				LastFailedAt: now,
+			}); err != nil {
+				return err
+			}
+
+			failure, err := loginFailureStore.GetOneForUpdate(whereMap)
+			if err != nil {
+				return err
+			}
+
+			failures := failure.Failures + 1
+			if now.Sub(failure.LastFailedAt) > failureWindow {
+				failures = 1
+			}
+
+			updateMap := map[string]interface{}{"failures": failures, "last_failed_at": now}
+			if d := lockoutDuration(failures, s.freeAttempts); d > 0 {
+				updateMap["locked_until"] = now.Add(d)
+				log.Printf("%s %s locked out for %s after %d failed sign ins", s.kind, s.subject, d, failures)
+			}
+
+			return loginFailureStore.Update(updateMap, whereMap)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetLockouts returns the accounts and addresses currently locked out.
+func (us *userService) GetLockouts() ([]models.LoginFailureDBModel, error) {
+	lockouts, err := us.LoginFailureStore.GetLocked(time.Now())
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	return lockouts, nil
+}
+
+// Unlock lifts the lockouts of the account, for its passwords and its second factor codes, and forgets its failures.
+// The lockouts of the addresses it was tried from are left to expire.
+func (us *userService) Unlock(req *models.UnlockAccountRequest) error {
+	kinds := []string{models.LoginFailureAccount, models.LoginFailureSecondFactor}
+
+	locked, err := lockedOut(us.LoginFailureStore, []loginSubject{
+		{kind: kinds[0], subject: req.Username},
+		{kind: kinds[1], subject: req.Username},
+	})
+	if err != nil {
+		return err
+	}
+
+	if !locked {
+		return models.ErrAccountNotLocked
+	}
+
+	if err := us.LoginFailureStore.Delete(map[string]interface{}{"kind": kinds, "subject": req.Username}); err != nil {
+		return err
+	}
+
+	log.Printf("account %s unlocked by %s", req.Username, req.UnlockedBy)
+	return nil
+}
@@ -2,13 +2,16 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/gorilla/sessions"
 	"github.com/labstack/echo/v4"
 )
 
 func (app *Application) createSessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		session, err := app.CookieStore.Get(c.Request(), "signin") // this will also create the cookie if it does not exists
+		session, err := app.Sessions.Get(c.Request(), sessionCookieName) // an empty session when the request has none
 		if err != nil {
 			return err
 		}
@@ -18,6 +21,91 @@ func (app *Application) createSessionMiddleware(next echo.HandlerFunc) echo.Hand
 	}
 }
 
+// tokenScopesKey holds the scopes of the api token the request was authenticated with.
+const tokenScopesKey = "token_scopes"
+
+// BearerAuth authenticates the requests carrying an api token or a jwt access token in the Authorization header. They
+// get an unsaved session of the owner of the token, so the handlers find the username the same way as for the browser
+// sessions. The permissions of an api token are limited to its scopes, an access token has all the permissions of its
+// user. The requests without the header keep their session.
+func (app *Application) BearerAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		if !strings.HasPrefix(header, "Bearer ") {
+			return next(c)
+		}
+
+		token := strings.TrimPrefix(header, "Bearer ")
+		session := sessions.NewSession(app.Sessions, sessionCookieName)
+		session.Values["authenticated"] = true
+
+		if strings.HasPrefix(token, apiTokenPrefix) {
+			apiToken, err := app.APITokenService.Authenticate(token)
+			if err != nil {
+				if err == models.ErrInvalidAPIToken {
+					return echo.NewHTTPError(http.StatusUnauthorized, err)
+				}
+
+				c.Logger().Error(err)
+				return err
+			}
+
+			session.Values["username"] = apiToken.Username
+			c.Set(tokenScopesKey, apiTokenScopes(apiToken))
+		} else {
+			username, err := app.AuthTokenService.Verify(token)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, err)
+			}
+
+			session.Values["username"] = username
+		}
+
+		c.Set("session", session)
+		return next(c)
+	}
+}
+
+// RequireSession answers with 403 to the requests authenticated by an api token, so a token can't manage the account
+// of its owner: the tokens, the second factor and the sessions.
+func (app *Application) RequireSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := c.Get(tokenScopesKey).([]string); ok {
+			return echo.NewHTTPError(http.StatusForbidden, "a signed in session is required")
+		}
+		return next(c)
+	}
+}
+
+// RequireScope answers with 403 to the requests authenticated by an api token without the user scope.
+func (app *Application) RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !inTokenScope(c, scope) {
+				return echo.NewHTTPError(http.StatusForbidden, "missing scope "+scope)
+			}
+			return next(c)
+		}
+	}
+}
+
+// csrfExempt skips the csrf checks of the json api requests that don't come with the session cookie, the anonymous
+// ones and the ones authenticated by a bearer token, which browsers never add on their own. A forged request needs the
+// browser to send the cookie.
+func csrfExempt(c echo.Context) bool {
+	req := c.Request()
+	if !strings.HasPrefix(req.URL.Path, "/api/") {
+		return false
+	}
+
+	if strings.HasPrefix(req.Header.Get(echo.HeaderAuthorization), "Bearer ") {
+		return true
+	}
+
+	_, err := req.Cookie(sessionCookieName)
+	return err != nil
+}
+
 func (app *Application) IfAlreadyLogined(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if app.alreadyLoggedIn(c) {
@@ -37,13 +125,34 @@ func (app *Application) IfNotLogined(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-func (app *Application) IfNotAdmin(next echo.HandlerFunc) echo.HandlerFunc {
-
+// IfSecondFactorMissing sends the users whose role requires two-factor authentication to its page until they enable it.
+func (app *Application) IfSecondFactorMissing(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if app.secondFactorMissing(c) {
+			return c.Redirect(http.StatusFound, "/user/2fa")
+		}
+		return next(c)
+	}
+}
 
-		if !app.isAdmin(c) {
-			return echo.NewHTTPError(http.StatusForbidden, "only admins are allowed")
+// IfNotAuthenticated is the /api/v1 counterpart of IfNotLogined, it answers with 401 instead of redirecting to the login page.
+func (app *Application) IfNotAuthenticated(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !app.alreadyLoggedIn(c) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
 		}
 		return next(c)
 	}
 }
+
+// RequirePermission answers with 403 unless one of the roles of the user grants the permission.
+func (app *Application) RequirePermission(permission string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !app.hasPermission(c, permission) {
+				return echo.NewHTTPError(http.StatusForbidden, "missing permission "+permission)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	// the sign in with the identity provider must come back within oidcSignInTimeout
+	oidcCookieName    = "signin_oidc"
+	oidcSignInTimeout = 10 * time.Minute
+
+	defaultOIDCGroupsClaim = "groups"
+
+	// oidcChangedBy records the admin changes made from the groups of the identity provider
+	oidcChangedBy = "oidc"
+)
+
+var defaultOIDCScopes = []string{oidc.ScopeOpenID, "profile", "email"}
+
+// oidcSignIn is what the sign in with the identity provider was started with, kept in a signed cookie until it comes
+// back to the callback.
+type oidcSignIn struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// OIDCService signs the users in with an openid connect identity provider, using the authorization code flow with
+// PKCE.
+type OIDCService interface {
+	Enabled() bool
+	AuthCodeURL(signIn *oidcSignIn) (string, error)
+	SignIn(ctx context.Context, req *models.OIDCSignInRequest) (*models.UserDBModel, error)
+}
+
+type oidcService struct {
+	store.UsersStore
+	store.UserIdentityStore
+	store.AdminsStore
+	adminService AdminService
+	passwords    *Passwords
+
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	groupsClaim  string
+	adminGroups  []string
+
+	// provider is discovered at the first sign in, so the market starts while the identity provider is down
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// NewOIDCService takes the oidc settings of the config, which NewApplication has filled with the defaults.
+func NewOIDCService(usersStore store.UsersStore, userIdentityStore store.UserIdentityStore, adminsStore store.AdminsStore,
+	adminService AdminService, passwords *Passwords, config Config) OIDCService {
+	return &oidcService{
+		UsersStore:        usersStore,
+		UserIdentityStore: userIdentityStore,
+		AdminsStore:       adminsStore,
+		adminService:      adminService,
+		passwords:         passwords,
+		issuer:            config.OIDCIssuer,
+		clientID:          config.OIDCClientID,
+		clientSecret:      config.OIDCClientSecret,
+		redirectURL:       strings.TrimSuffix(config.BaseURL, "/") + "/oidc/callback",
+		scopes:            config.OIDCScopes,
+		groupsClaim:       config.OIDCGroupsClaim,
+		adminGroups:       config.OIDCAdminGroups,
+	}
+}
+
+func (oss *oidcService) Enabled() bool {
+	return oss.issuer != ""
+}
+
+func (oss *oidcService) getProvider(ctx context.Context) (*oidc.Provider, error) {
+	if !oss.Enabled() {
+		return nil, models.ErrOIDCNotConfigured
+	}
+
+	oss.mu.Lock()
+	defer oss.mu.Unlock()
+
+	if oss.provider == nil {
+		provider, err := oidc.NewProvider(ctx, oss.issuer)
+		if err != nil {
+			return nil, err
+		}
+
+		oss.provider = provider
+	}
+
+	return oss.provider, nil
+}
+
+func (oss *oidcService) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     oss.clientID,
+		ClientSecret: oss.clientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  oss.redirectURL,
+		Scopes:       oss.scopes,
+	}
+}
+
+// newOIDCSignIn returns the random values of a new sign in.
+func newOIDCSignIn() (*oidcSignIn, error) {
+	state, _, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, _, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcSignIn{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+// AuthCodeURL returns the address of the identity provider the user signs in at.
+func (oss *oidcService) AuthCodeURL(signIn *oidcSignIn) (string, error) {
+	provider, err := oss.getProvider(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	return oss.oauth2Config(provider).AuthCodeURL(signIn.State, oidc.Nonce(signIn.Nonce),
+		oauth2.S256ChallengeOption(signIn.CodeVerifier)), nil
+}
+
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// SignIn exchanges the code for the id token of the user and returns the user it is linked to. The first sign in of
+// an identity links it to the user with the same verified email, or makes a new user. When OIDCAdminGroups is set the
+// admin status of the user follows the groups at every sign in.
+func (oss *oidcService) SignIn(ctx context.Context, req *models.OIDCSignInRequest) (*models.UserDBModel, error) {
+	provider, err := oss.getProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oss.oauth2Config(provider).Exchange(ctx, req.Code, oauth2.VerifierOption(req.CodeVerifier))
+	if err != nil {
+		log.Printf("oidc code exchange: %v", err)
+		return nil, models.ErrOIDCSignInFailed
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Print("oidc token response without an id token")
+		return nil, models.ErrOIDCSignInFailed
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: oss.clientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		log.Printf("oidc id token: %v", err)
+		return nil, models.ErrOIDCSignInFailed
+	}
+
+	if idToken.Nonce != req.Nonce {
+		log.Print("oidc id token with the wrong nonce")
+		return nil, models.ErrOIDCSignInFailed
+	}
+
+	claims := oidcClaims{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	user, err := oss.linkOrProvision(idToken.Issuer, idToken.Subject, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(oss.adminGroups) != 0 {
+		allClaims := map[string]interface{}{}
+		if err := idToken.Claims(&allClaims); err != nil {
+			return nil, err
+		}
+
+		if err := oss.syncAdmin(user.Username, claimStrings(allClaims[oss.groupsClaim])); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// linkOrProvision returns the user linked to the identity, linking or making one at its first sign in. Only a verified
+// email on both sides links an existing user, otherwise anyone setting the email at the identity provider would take
+// the account over.
+func (oss *oidcService) linkOrProvision(issuer, subject string, claims *oidcClaims) (*models.UserDBModel, error) {
+	var user *models.UserDBModel
+
+	db := oss.UsersStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		usersStore := store.NewUsersStore(tx)
+		userIdentityStore := store.NewUserIdentityStore(tx)
+
+		identity, err := userIdentityStore.GetOne(map[string]interface{}{"issuer": issuer, "subject": subject})
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		if err == nil {
+			user, err = usersStore.GetOne(map[string]interface{}{"username": identity.Username})
+			return err
+		}
+
+		email := strings.ToLower(strings.TrimSpace(claims.Email))
+		if !claims.EmailVerified || validateEmail(email) != "" {
+			return models.ErrOIDCEmailMissing
+		}
+
+		user, err = usersStore.GetOne(map[string]interface{}{"email": email})
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		now := time.Now()
+
+		if err == nil {
+			if user.EmailVerifiedAt == nil {
+				return models.ErrOIDCAccountConflict
+			}
+
+			log.Printf("%s linked to the %s account %s", user.Username, issuer, subject)
+		} else {
+			if user, err = oss.provision(usersStore, email, claims.PreferredUsername, now); err != nil {
+				return err
+			}
+
+			log.Printf("%s made for the %s account %s", user.Username, issuer, subject)
+		}
+
+		return userIdentityStore.Create(models.UserIdentityDBModel{
+			Issuer:    issuer,
+			Subject:   subject,
+			Username:  user.Username,
+			CreatedAt: now,
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// provision makes the user of a new identity. The username is the preferred one when it is valid and free, with a
+// random suffix otherwise. The password is random, the user can set one with a password reset.
+func (oss *oidcService) provision(usersStore store.UsersStore, email, preferredUsername string, now time.Time) (*models.UserDBModel, error) {
+	username := preferredUsername
+	if !usernamePattern.MatchString(username) {
+		username = email[:strings.LastIndex(email, "@")]
+	}
+
+	if !usernamePattern.MatchString(username) {
+		username = "user"
+	}
+
+	exists, err := usersStore.IsExists(map[string]interface{}{"username": username})
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		_, suffix, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(username) > 25 {
+			username = username[:25]
+		}
+		username += "-" + suffix[:6]
+	}
+
+	password, _, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := oss.passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.UserDBModel{
+		Username:        username,
+		Email:           email,
+		Password:        hash,
+		EmailVerifiedAt: &now,
+	}
+
+	if err := usersStore.Create(user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// claimStrings reads a claim holding a list of strings, or a single string.
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := []string{}
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	}
+
+	return nil
+}
+
+// syncAdmin grants the admin status to the members of the admin groups and revokes it from the others. The last admin
+// is never revoked.
+func (oss *oidcService) syncAdmin(username string, groups []string) error {
+	member := false
+	for _, group := range groups {
+		for _, adminGroup := range oss.adminGroups {
+			if group == adminGroup {
+				member = true
+			}
+		}
+	}
+
+	isAdmin, err := oss.AdminsStore.IsExists(map[string]interface{}{"username": username})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case member && !isAdmin:
+		err = oss.adminService.Grant(&models.GrantAdminRequest{Username: username, GrantedBy: oidcChangedBy})
+	case !member && isAdmin:
+		err = oss.adminService.Revoke(&models.RevokeAdminRequest{Username: username, RevokedBy: oidcChangedBy})
+		if err == models.ErrLastAdmin {
+			log.Printf("%s left the admin groups but stays the last admin", username)
+			return nil
+		}
+	}
+
+	return err
+}
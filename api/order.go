@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/NikhilSharmaWe/market/models"
@@ -11,9 +12,36 @@ import (
 )
 
 type OrderService interface {
-	Create(*models.CreateOrderRequest) (*models.OrderDBModel, error)
+	Create(*models.CreateOrderRequest) (*models.CreateOrderResponse, error)
 	GetOrdersByUsername(username string) (map[string]models.OrdersResponse, error)
 	GetOrdersForAdmin(*models.GetOrdersForAdminRequest) (map[string]models.OrdersResponse, error)
+	UpdateStatus(*models.UpdateOrderStatusRequest) (*models.OrderDBModel, error)
+	Cancel(*models.CancelOrderRequest) (*models.OrderCancellationDBModel, error)
+}
+
+// orderStatusTransitions lists, for every order status, the statuses an order can be moved to from it.
+var orderStatusTransitions = map[string][]string{
+	models.OrderStatusPending:   {models.OrderStatusPaid, models.OrderStatusCancelled},
+	models.OrderStatusPaid:      {models.OrderStatusShipped, models.OrderStatusCancelled, models.OrderStatusRefunded},
+	models.OrderStatusShipped:   {models.OrderStatusDelivered},
+	models.OrderStatusDelivered: {models.OrderStatusRefunded},
+	models.OrderStatusCancelled: {},
+	models.OrderStatusRefunded:  {},
+}
+
+func isValidOrderStatus(status string) bool {
+	_, ok := orderStatusTransitions[status]
+	return ok
+}
+
+func canTransitionOrder(from, to string) bool {
+	for _, status := range orderStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
 }
 
 type orderService struct {
@@ -21,19 +49,26 @@ type orderService struct {
 	store.OrderPerProductStore
 	store.ProductStore
 	store.InventoryStore
+	store.OrderCancellationStore
 }
 
-func NewOrderService(orderStore store.OrderStore, orderPerProductStore store.OrderPerProductStore, productStore store.ProductStore, inventoryStore store.InventoryStore) OrderService {
+func NewOrderService(orderStore store.OrderStore, orderPerProductStore store.OrderPerProductStore, productStore store.ProductStore, inventoryStore store.InventoryStore, orderCancellationStore store.OrderCancellationStore) OrderService {
 	return &orderService{
-		OrderStore:           orderStore,
-		OrderPerProductStore: orderPerProductStore,
-		ProductStore:         productStore,
-		InventoryStore:       inventoryStore,
+		OrderStore:             orderStore,
+		OrderPerProductStore:   orderPerProductStore,
+		ProductStore:           productStore,
+		InventoryStore:         inventoryStore,
+		OrderCancellationStore: orderCancellationStore,
 	}
 }
 
-func (os *orderService) Create(req *models.CreateOrderRequest) (*models.OrderDBModel, error) {
-	var order models.OrderDBModel
+func (os *orderService) Create(req *models.CreateOrderRequest) (*models.CreateOrderResponse, error) {
+	lines, err := orderLines(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp models.CreateOrderResponse
 	db := os.InventoryStore.DB()
 
 	if err := db.Transaction(func(tx *gorm.DB) error {
@@ -42,20 +77,32 @@ func (os *orderService) Create(req *models.CreateOrderRequest) (*models.OrderDBM
 		inventoryStore := store.NewInventoryStore(tx)
 		orderStore := store.NewOrdersStore(tx)
 		orderPerProductStore := store.NewOrderPerProductStore(tx)
+		inventoryMovementStore := store.NewInventoryMovementStore(tx)
 		orderID := uuid.NewV4().String()
 
-		order = models.OrderDBModel{
+		resp = models.CreateOrderResponse{
 			OrderID:   orderID,
 			Username:  req.Username,
 			TotalCost: 0,
+			Lines:     []models.OrderLineResponse{},
+			Status:    models.OrderStatusPending,
 			CreatedAt: time.Now(),
 		}
 
-		if err := orderStore.Create(order); err != nil {
+		if err := orderStore.Create(models.OrderDBModel{
+			OrderID:   resp.OrderID,
+			Username:  resp.Username,
+			TotalCost: resp.TotalCost,
+			Status:    resp.Status,
+			CreatedAt: resp.CreatedAt,
+			UpdatedAt: resp.CreatedAt,
+		}); err != nil {
 			return err
 		}
 
-		for productName, quantity := range req.ProductsAndQuantity {
+		for _, line := range lines {
+			productName, quantity := line.ProductName, line.Quantity
+
 			exists, err := productStore.IsExists(map[string]interface{}{"product_name": productName})
 			if err != nil {
 				return err
@@ -70,36 +117,56 @@ func (os *orderService) Create(req *models.CreateOrderRequest) (*models.OrderDBM
 				return err
 			}
 
-			inventory, err := inventoryStore.GetOne(map[string]interface{}{"product_name": productName})
+			// lines are sorted by product name, so concurrent orders lock the inventory rows in the same order
+			// and can't deadlock each other
+			reserved, err := inventoryStore.Adjust(productName, -quantity, nil)
 			if err != nil {
 				return err
 			}
 
-			if inventory.QuantityInStock < quantity {
+			if !reserved {
 				return models.ErrInvalidQuantity
 			}
 
 			totalCost += product.Price * quantity
-			order.TotalCost = totalCost
-
-			uq := inventory.QuantityInStock - quantity
+			resp.TotalCost = totalCost
+			resp.Lines = append(resp.Lines, models.OrderLineResponse{
+				ProductName: productName,
+				Quantity:    quantity,
+				UnitPrice:   product.Price,
+				LineTotal:   product.Price * quantity,
+			})
 
 			if err := orderPerProductStore.Create(models.OrderPerProductDBModel{
 				OrderID:     orderID,
 				ProductName: productName,
 				Quantity:    quantity,
+				UnitPrice:   product.Price,
 			}); err != nil {
 				return err
 			}
 
-			inventory.QuantityInStock = uq
-			if err := inventoryStore.Update(map[string]interface{}{
-				"quantity_in_stock": uq,
-			}, map[string]interface{}{"product_name": productName}); err != nil {
+			if err := inventoryMovementStore.Create(models.InventoryMovementDBModel{
+				ProductName: productName,
+				Delta:       -quantity,
+				Reason:      models.MovementOrderSale,
+				Actor:       req.Username,
+				Reference:   orderID,
+				CreatedAt:   resp.CreatedAt,
+			}); err != nil {
 				return err
 			}
 		}
 
+		productNames := []string{}
+		for _, line := range lines {
+			productNames = append(productNames, line.ProductName)
+		}
+
+		if _, err := repriceProducts(tx, productNames, false); err != nil {
+			return err
+		}
+
 		return orderStore.Update(map[string]interface{}{
 			"total_cost": totalCost,
 		}, map[string]interface{}{
@@ -109,7 +176,67 @@ func (os *orderService) Create(req *models.CreateOrderRequest) (*models.OrderDBM
 		return nil, err
 	}
 
-	return &order, nil
+	return &resp, nil
+}
+
+func isOrderRequestError(err error) bool {
+	switch err {
+	case models.ErrProductNotFound, models.ErrInvalidQuantity, models.ErrEmptyOrder, models.ErrDuplicateOrderLine, models.ErrInvalidOrderLine:
+		return true
+	}
+
+	return false
+}
+
+// orderLines merges the Lines and ProductsAndQuantity of the request into a single list sorted by product name,
+// rejecting empty orders, duplicate products and non positive quantities.
+func orderLines(req *models.CreateOrderRequest) ([]models.OrderLineRequest, error) {
+	lines := []models.OrderLineRequest{}
+	seen := map[string]bool{}
+
+	add := func(productName string, quantity int) error {
+		if productName == "" {
+			return models.ErrInvalidOrderLine
+		}
+
+		if quantity <= 0 {
+			return models.ErrInvalidQuantity
+		}
+
+		if seen[productName] {
+			return models.ErrDuplicateOrderLine
+		}
+
+		seen[productName] = true
+		lines = append(lines, models.OrderLineRequest{
+			ProductName: productName,
+			Quantity:    quantity,
+		})
+
+		return nil
+	}
+
+	for _, line := range req.Lines {
+		if err := add(line.ProductName, line.Quantity); err != nil {
+			return nil, err
+		}
+	}
+
+	for productName, quantity := range req.ProductsAndQuantity {
+		if err := add(productName, quantity); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, models.ErrEmptyOrder
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].ProductName < lines[j].ProductName
+	})
+
+	return lines, nil
 }
 
 func (os *orderService) GetOrdersByUsername(username string) (map[string]models.OrdersResponse, error) {
@@ -128,10 +255,12 @@ func (os *orderService) GetOrdersByUsername(username string) (map[string]models.
 
 		productsName := []string{}
 		quantities := []int{}
+		unitPrices := []int{}
 
 		for _, opp := range ordersPerProduct {
 			productsName = append(productsName, opp.ProductName)
 			quantities = append(quantities, opp.Quantity)
+			unitPrices = append(unitPrices, opp.UnitPrice)
 		}
 
 		resp[order.OrderID] = models.OrdersResponse{
@@ -139,6 +268,8 @@ func (os *orderService) GetOrdersByUsername(username string) (map[string]models.
 			TotalCost:  order.TotalCost,
 			Products:   productsName,
 			Quantities: quantities,
+			UnitPrices: unitPrices,
+			Status:     order.Status,
 			CreatedAt:  order.CreatedAt,
 		}
 	}
@@ -149,10 +280,14 @@ func (os *orderService) GetOrdersByUsername(username string) (map[string]models.
 func (os *orderService) GetOrdersForAdmin(req *models.GetOrdersForAdminRequest) (map[string]models.OrdersResponse, error) {
 	resp := map[string]models.OrdersResponse{}
 
-	query := os.OrderStore.DB().Table("orders")
+	query := os.OrderStore.DB().Table("orders").Select("orders.*")
 
 	if req.Username != "" {
-		query = query.Where("username = ?", req.Username)
+		query = query.Where("orders.username = ?", req.Username)
+	}
+
+	if req.Status != "" {
+		query = query.Where("orders.status = ?", req.Status)
 	}
 
 	if req.ProductName != "" {
@@ -173,10 +308,12 @@ func (os *orderService) GetOrdersForAdmin(req *models.GetOrdersForAdminRequest)
 
 		productsName := []string{}
 		quantities := []int{}
+		unitPrices := []int{}
 
 		for _, opp := range ordersPerProduct {
 			productsName = append(productsName, opp.ProductName)
 			quantities = append(quantities, opp.Quantity)
+			unitPrices = append(unitPrices, opp.UnitPrice)
 		}
 
 		resp[fmt.Sprint(i)+"__"+order.OrderID+"__"+order.Username] = models.OrdersResponse{
@@ -184,9 +321,161 @@ func (os *orderService) GetOrdersForAdmin(req *models.GetOrdersForAdminRequest)
 			TotalCost:  order.TotalCost,
 			Products:   productsName,
 			Quantities: quantities,
+			UnitPrices: unitPrices,
+			Status:     order.Status,
 			CreatedAt:  order.CreatedAt,
 		}
 	}
 
 	return resp, nil
 }
+
+func (os *orderService) UpdateStatus(req *models.UpdateOrderStatusRequest) (*models.OrderDBModel, error) {
+	if !isValidOrderStatus(req.Status) {
+		return nil, models.ErrInvalidOrderStatus
+	}
+
+	// cancelling has to return the stock to the inventory, which only Cancel does
+	if req.Status == models.OrderStatusCancelled {
+		return nil, models.ErrInvalidTransition
+	}
+
+	var order *models.OrderDBModel
+	db := os.OrderStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		orderStore := store.NewOrdersStore(tx)
+
+		var err error
+		order, err = orderStore.GetOneForUpdate(map[string]interface{}{"order_id": req.OrderID})
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return models.ErrOrderNotFound
+			}
+
+			return err
+		}
+
+		if !canTransitionOrder(order.Status, req.Status) {
+			return models.ErrInvalidTransition
+		}
+
+		order.Status = req.Status
+		order.UpdatedAt = time.Now()
+
+		return orderStore.Update(map[string]interface{}{
+			"status":     order.Status,
+			"updated_at": order.UpdatedAt,
+		}, map[string]interface{}{
+			"order_id": order.OrderID,
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// Cancel marks the order cancelled, puts the ordered quantities back in the inventory and records who cancelled it
+// and why, all in a single transaction. Users can only cancel their own pending orders, admins any order the status
+// transitions allow to cancel, which are the pending and paid ones.
+func (os *orderService) Cancel(req *models.CancelOrderRequest) (*models.OrderCancellationDBModel, error) {
+	var cancellation models.OrderCancellationDBModel
+	db := os.OrderStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		orderStore := store.NewOrdersStore(tx)
+		orderPerProductStore := store.NewOrderPerProductStore(tx)
+		inventoryStore := store.NewInventoryStore(tx)
+		orderCancellationStore := store.NewOrderCancellationStore(tx)
+		inventoryMovementStore := store.NewInventoryMovementStore(tx)
+
+		order, err := orderStore.GetOneForUpdate(map[string]interface{}{"order_id": req.OrderID})
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return models.ErrOrderNotFound
+			}
+
+			return err
+		}
+
+		if req.AsAdmin {
+			// shipped goods have left the warehouse, they can't be put back in the inventory
+			if !canTransitionOrder(order.Status, models.OrderStatusCancelled) {
+				return models.ErrInvalidTransition
+			}
+		} else {
+			// other users orders are reported as missing so their ids can't be probed
+			if order.Username != req.CancelledBy {
+				return models.ErrOrderNotFound
+			}
+
+			if order.Status != models.OrderStatusPending {
+				return models.ErrInvalidTransition
+			}
+		}
+
+		ordersPerProduct, err := orderPerProductStore.GetMany(map[string]interface{}{"order_id": order.OrderID})
+		if err != nil && err != models.ErrMatchingRecordNotFound {
+			return err
+		}
+
+		// same lock order as Create, products removed since the order was placed have no inventory row left
+		sort.Slice(ordersPerProduct, func(i, j int) bool {
+			return ordersPerProduct[i].ProductName < ordersPerProduct[j].ProductName
+		})
+
+		now := time.Now()
+		restockedProducts := []string{}
+		for _, opp := range ordersPerProduct {
+			restocked, err := inventoryStore.Adjust(opp.ProductName, opp.Quantity, nil)
+			if err != nil {
+				return err
+			}
+
+			if !restocked {
+				continue
+			}
+			restockedProducts = append(restockedProducts, opp.ProductName)
+
+			if err := inventoryMovementStore.Create(models.InventoryMovementDBModel{
+				ProductName: opp.ProductName,
+				Delta:       opp.Quantity,
+				Reason:      models.MovementCancellationRestock,
+				Actor:       req.CancelledBy,
+				Reference:   order.OrderID,
+				CreatedAt:   now,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if len(restockedProducts) != 0 {
+			if _, err := repriceProducts(tx, restockedProducts, false); err != nil {
+				return err
+			}
+		}
+
+		if err := orderStore.Update(map[string]interface{}{
+			"status":     models.OrderStatusCancelled,
+			"updated_at": now,
+		}, map[string]interface{}{
+			"order_id": order.OrderID,
+		}); err != nil {
+			return err
+		}
+
+		cancellation = models.OrderCancellationDBModel{
+			OrderID:     order.OrderID,
+			CancelledBy: req.CancelledBy,
+			Reason:      req.Reason,
+			CancelledAt: now,
+		}
+
+		return orderCancellationStore.Create(cancellation)
+	}); err != nil {
+		return nil, err
+	}
+
+	return &cancellation, nil
+}
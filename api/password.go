@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordAlgorithmBcrypt   = "bcrypt"
+	PasswordAlgorithmArgon2id = "argon2id"
+
+	defaultBcryptCost = 12
+)
+
+// Argon2Params are the argon2id settings, Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+var defaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+}
+
+// passwordHasher is one password hashing algorithm. Every hash starts with a prefix naming its algorithm so hashes of
+// several algorithms can live in the users table while it moves from one to another.
+type passwordHasher interface {
+	Hash(password string) ([]byte, error)
+	// Owns reports whether the hash was made by this algorithm.
+	Owns(hash []byte) bool
+	Verify(hash []byte, password string) bool
+	// Outdated reports whether the hash was made with weaker settings than the current ones.
+	Outdated(hash []byte) bool
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (bh bcryptHasher) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bh.cost)
+}
+
+func (bh bcryptHasher) Owns(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) || bytes.HasPrefix(hash, []byte("$2b$")) || bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func (bh bcryptHasher) Verify(hash []byte, password string) bool {
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+func (bh bcryptHasher) Outdated(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost < bh.cost
+}
+
+// argon2idHasher writes the hashes in the PHC string format, $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+type argon2idHasher struct {
+	params Argon2Params
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func (ah argon2idHasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, ah.params.Iterations, ah.params.Memory, ah.params.Parallelism, argon2KeyLength)
+
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, ah.params.Memory, ah.params.Iterations,
+		ah.params.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+func (ah argon2idHasher) Owns(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func parseArgon2idHash(hash []byte) (Argon2Params, []byte, []byte, bool) {
+	var (
+		params  Argon2Params
+		version int
+	)
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, false
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, false
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, false
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, false
+	}
+
+	return params, salt, key, true
+}
+
+func (ah argon2idHasher) Verify(hash []byte, password string) bool {
+	params, salt, key, ok := parseArgon2idHash(hash)
+	if !ok {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (ah argon2idHasher) Outdated(hash []byte) bool {
+	params, _, _, ok := parseArgon2idHash(hash)
+	return !ok || params.Memory < ah.params.Memory || params.Iterations < ah.params.Iterations ||
+		params.Parallelism < ah.params.Parallelism
+}
+
+// Passwords hashes the new passwords with the configured algorithm and verifies the hashes of every known one.
+type Passwords struct {
+	current passwordHasher
+	hashers []passwordHasher
+}
+
+func NewPasswords(config Config) *Passwords {
+	cost := config.BcryptCost
+	if cost == 0 {
+		cost = defaultBcryptCost
+	}
+
+	// every unset parameter gets its default, argon2 panics with no iterations or threads
+	params := config.Argon2
+	if params.Memory == 0 {
+		params.Memory = defaultArgon2Params.Memory
+	}
+
+	if params.Iterations == 0 {
+		params.Iterations = defaultArgon2Params.Iterations
+	}
+
+	if params.Parallelism == 0 {
+		params.Parallelism = defaultArgon2Params.Parallelism
+	}
+
+	bcryptHasher := bcryptHasher{cost: cost}
+	argon2idHasher := argon2idHasher{params: params}
+
+	p := &Passwords{
+		current: bcryptHasher,
+		hashers: []passwordHasher{bcryptHasher, argon2idHasher},
+	}
+
+	if config.PasswordAlgorithm == PasswordAlgorithmArgon2id {
+		p.current = argon2idHasher
+	}
+
+	return p
+}
+
+func (p *Passwords) Hash(password string) ([]byte, error) {
+	return p.current.Hash(password)
+}
+
+// Verify reports whether the password matches the hash, and if so whether the hash should be replaced because it was
+// made by another algorithm or with weaker settings than the configured ones.
+func (p *Passwords) Verify(hash []byte, password string) (bool, bool) {
+	for _, hasher := range p.hashers {
+		if !hasher.Owns(hash) {
+			continue
+		}
+
+		if !hasher.Verify(hash, password) {
+			return false, false
+		}
+
+		return true, hasher != p.current || hasher.Outdated(hash)
+	}
+
+	return false, false
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"sort"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"gorm.io/gorm"
+)
+
+type PricingService interface {
+	CreateRule(*models.CreatePricingRuleRequest) (*models.PricingRuleDBModel, error)
+	GetRules() ([]models.PricingRuleDBModel, error)
+	UpdateRule(*models.UpdatePricingRuleRequest) (*models.PricingRuleDBModel, error)
+	DeleteRule(*models.DeletePricingRuleRequest) error
+}
+
+type pricingService struct {
+	store.PricingRuleStore
+}
+
+func NewPricingService(pricingRuleStore store.PricingRuleStore) PricingService {
+	return &pricingService{
+		PricingRuleStore: pricingRuleStore,
+	}
+}
+
+func validatePricingRule(rule *models.PricingRuleDBModel) error {
+	if rule.Name == "" || rule.StockThreshold < 0 || rule.SurchargePercent < 0 || rule.CooldownSeconds < 0 {
+		return models.ErrInvalidPricingRule
+	}
+
+	// a cap below the base price would turn the surcharge into a discount
+	if rule.MaxPricePercent != 0 && rule.MaxPricePercent < 100 {
+		return models.ErrInvalidPricingRule
+	}
+
+	return nil
+}
+
+func (ps *pricingService) CreateRule(req *models.CreatePricingRuleRequest) (*models.PricingRuleDBModel, error) {
+	rule := models.PricingRuleDBModel{
+		Name:             req.Name,
+		Category:         req.Category,
+		StockThreshold:   req.StockThreshold,
+		SurchargePercent: req.SurchargePercent,
+		MaxPricePercent:  req.MaxPricePercent,
+		CooldownSeconds:  req.CooldownSeconds,
+		Enabled:          req.Enabled,
+		CreatedAt:        time.Now(),
+	}
+
+	if err := validatePricingRule(&rule); err != nil {
+		return nil, err
+	}
+
+	return ps.PricingRuleStore.Create(rule)
+}
+
+func (ps *pricingService) GetRules() ([]models.PricingRuleDBModel, error) {
+	rules, err := ps.PricingRuleStore.GetMany(nil)
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (ps *pricingService) UpdateRule(req *models.UpdatePricingRuleRequest) (*models.PricingRuleDBModel, error) {
+	rule, err := ps.PricingRuleStore.GetOne(map[string]interface{}{"id": req.ID})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrPricingRuleNotFound
+		}
+
+		return nil, err
+	}
+
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Category != nil {
+		rule.Category = *req.Category
+	}
+	if req.StockThreshold != nil {
+		rule.StockThreshold = *req.StockThreshold
+	}
+	if req.SurchargePercent != nil {
+		rule.SurchargePercent = *req.SurchargePercent
+	}
+	if req.MaxPricePercent != nil {
+		rule.MaxPricePercent = *req.MaxPricePercent
+	}
+	if req.CooldownSeconds != nil {
+		rule.CooldownSeconds = *req.CooldownSeconds
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+
+	if err := validatePricingRule(rule); err != nil {
+		return nil, err
+	}
+
+	if err := ps.PricingRuleStore.Update(map[string]interface{}{
+		"name":              rule.Name,
+		"category":          rule.Category,
+		"stock_threshold":   rule.StockThreshold,
+		"surcharge_percent": rule.SurchargePercent,
+		"max_price_percent": rule.MaxPricePercent,
+		"cooldown_seconds":  rule.CooldownSeconds,
+		"enabled":           rule.Enabled,
+	}, map[string]interface{}{"id": rule.ID}); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+func (ps *pricingService) DeleteRule(req *models.DeletePricingRuleRequest) error {
+	exists, err := ps.PricingRuleStore.IsExists(map[string]interface{}{"id": req.ID})
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrPricingRuleNotFound
+	}
+
+	return ps.PricingRuleStore.Delete(map[string]interface{}{"id": req.ID})
+}
+
+// evaluatePrice computes the price of the product from its base price, never from its current price, so surcharges
+// don't compound. The surcharges of every enabled rule of the product category whose stock threshold is above the
+// stock add up, and the result is capped by the lowest cap among those rules. The names of the applied rules are
+// returned along with the price.
+func evaluatePrice(product *models.ProductDBModel, stock int, rules []models.PricingRuleDBModel) (int, []string) {
+	applied := []string{}
+	surcharge := 0
+	maxPrice := 0
+
+	// products created before base prices existed keep their last price as base
+	basePrice := product.BasePrice
+	if basePrice == 0 {
+		basePrice = product.Price
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled || (rule.Category != "" && rule.Category != product.Category) {
+			continue
+		}
+
+		if stock >= rule.StockThreshold {
+			continue
+		}
+
+		applied = append(applied, rule.Name)
+		surcharge += rule.SurchargePercent
+
+		if rule.MaxPricePercent != 0 {
+			ruleMax := basePrice * rule.MaxPricePercent / 100
+			if maxPrice == 0 || ruleMax < maxPrice {
+				maxPrice = ruleMax
+			}
+		}
+	}
+
+	// rounded up like the database trigger this replaces did
+	price := (basePrice*(100+surcharge) + 99) / 100
+	if maxPrice != 0 && price > maxPrice {
+		price = maxPrice
+	}
+
+	return price, applied
+}
+
+// inPriceCooldown reports whether the price of the product changed too recently to change again, the longest cooldown
+// of the enabled rules of its category applies.
+func inPriceCooldown(product *models.ProductDBModel, rules []models.PricingRuleDBModel, now time.Time) bool {
+	if product.PriceChangedAt.IsZero() {
+		return false
+	}
+
+	cooldown := 0
+	for _, rule := range rules {
+		if rule.Enabled && (rule.Category == "" || rule.Category == product.Category) && rule.CooldownSeconds > cooldown {
+			cooldown = rule.CooldownSeconds
+		}
+	}
+
+	return now.Sub(product.PriceChangedAt) < time.Duration(cooldown)*time.Second
+}
+
+// repriceProducts runs the pricing rules over the given products, or over every product when none is given, and
+// updates the prices that changed unless dryRun is set. It is meant to run inside the transaction that changed the
+// stock so the new price is committed along with it.
+func repriceProducts(tx *gorm.DB, productNames []string, dryRun bool) ([]models.PriceChangeResponse, error) {
+	productStore := store.NewProductsStore(tx)
+	inventoryStore := store.NewInventoryStore(tx)
+	pricingRuleStore := store.NewPricingRuleStore(tx)
+
+	changes := []models.PriceChangeResponse{}
+
+	rules, err := pricingRuleStore.GetMany(map[string]interface{}{"enabled": true})
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	var whereMap map[string]interface{}
+	if len(productNames) != 0 {
+		whereMap = map[string]interface{}{"product_name": productNames}
+	}
+
+	products, err := productStore.GetMany(whereMap)
+	if err != nil {
+		if err == models.ErrMatchingRecordNotFound {
+			return changes, nil
+		}
+
+		return nil, err
+	}
+
+	// same lock order as order creation
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ProductName < products[j].ProductName
+	})
+
+	now := time.Now()
+	for i := range products {
+		product := &products[i]
+
+		inventory, err := inventoryStore.GetOne(map[string]interface{}{"product_name": product.ProductName})
+		if err != nil {
+			return nil, err
+		}
+
+		price, applied := evaluatePrice(product, inventory.QuantityInStock, rules)
+		if price == product.Price || inPriceCooldown(product, rules, now) {
+			continue
+		}
+
+		changes = append(changes, models.PriceChangeResponse{
+			ProductName:     product.ProductName,
+			Category:        product.Category,
+			BasePrice:       product.BasePrice,
+			CurrentPrice:    product.Price,
+			NewPrice:        price,
+			QuantityInStock: inventory.QuantityInStock,
+			Rules:           applied,
+			Applied:         !dryRun,
+		})
+
+		if dryRun {
+			continue
+		}
+
+		if err := productStore.Update(map[string]interface{}{
+			"price":            price,
+			"price_changed_at": now,
+		}, map[string]interface{}{"product_name": product.ProductName}); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
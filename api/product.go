@@ -1,8 +1,16 @@
 package api
 
 import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/NikhilSharmaWe/market/models"
 	"github.com/NikhilSharmaWe/market/store"
+	"gorm.io/gorm"
 )
 
 type ProductService interface {
@@ -10,17 +18,22 @@ type ProductService interface {
 	Get(*models.GetProductsRequest) (map[string]models.ProductResponse, error)
 	Delete(*models.DeleteProductRequest) error
 	Update(*models.UpdateProductRequest) error
+	Reprice(*models.RepriceRequest) ([]models.PriceChangeResponse, error)
+	GetPriceHistory(*models.GetPriceHistoryRequest) ([]models.PriceHistoryDBModel, error)
+	Import(*models.ImportProductsRequest) (*models.ImportProductsResponse, error)
 }
 
 type productService struct {
 	store.ProductStore
 	store.InventoryStore
+	store.PriceHistoryStore
 }
 
-func NewProductService(productStore store.ProductStore, inventoryStore store.InventoryStore) ProductService {
+func NewProductService(productStore store.ProductStore, inventoryStore store.InventoryStore, priceHistoryStore store.PriceHistoryStore) ProductService {
 	return &productService{
-		ProductStore:   productStore,
-		InventoryStore: inventoryStore,
+		ProductStore:      productStore,
+		InventoryStore:    inventoryStore,
+		PriceHistoryStore: priceHistoryStore,
 	}
 }
 
@@ -34,31 +47,58 @@ func (ps *productService) Create(req *models.CreateProductRequest) error {
 		return models.ErrProductAlreadyExists
 	}
 
-	if err := ps.ProductStore.Create(models.ProductDBModel{
-		ProductName: req.ProductName,
-		Category:    req.Category,
-		Price:       req.Price,
-	}); err != nil {
-		return err
-	}
+	db := ps.ProductStore.DB()
 
-	if err := ps.InventoryStore.Create(models.InventoryDBModel{
-		ProductName:     req.ProductName,
-		QuantityInStock: req.InitialQuantity,
-	}); err != nil {
-		return err
-	}
+	now := time.Now()
 
-	return nil
+	return db.Transaction(func(tx *gorm.DB) error {
+		productStore := store.NewProductsStore(tx)
+		inventoryStore := store.NewInventoryStore(tx)
+		inventoryMovementStore := store.NewInventoryMovementStore(tx)
+
+		if err := productStore.Create(models.ProductDBModel{
+			ProductName: req.ProductName,
+			Category:    req.Category,
+			Price:       req.Price,
+			BasePrice:   req.Price,
+			CreatedAt:   now,
+		}); err != nil {
+			return err
+		}
+
+		if err := inventoryStore.Create(models.InventoryDBModel{
+			ProductName:     req.ProductName,
+			QuantityInStock: req.InitialQuantity,
+		}); err != nil {
+			return err
+		}
+
+		if err := inventoryMovementStore.Create(models.InventoryMovementDBModel{
+			ProductName: req.ProductName,
+			Delta:       req.InitialQuantity,
+			Reason:      models.MovementInitialStock,
+			Actor:       req.CreatedBy,
+			CreatedAt:   now,
+		}); err != nil {
+			return err
+		}
+
+		_, err := repriceProducts(tx, []string{req.ProductName}, false)
+		return err
+	})
 }
 
 func (ps *productService) Get(req *models.GetProductsRequest) (map[string]models.ProductResponse, error) {
 
 	var wheremap map[string]interface{}
-	if req == nil {
-		wheremap = nil
-	} else {
-		wheremap = map[string]interface{}{"category": req.Category}
+	if req != nil {
+		wheremap = map[string]interface{}{}
+		if req.ProductName != "" {
+			wheremap["product_name"] = req.ProductName
+		}
+		if req.Category != "" {
+			wheremap["category"] = req.Category
+		}
 	}
 
 	products, err := ps.ProductStore.GetMany(wheremap)
@@ -108,9 +148,125 @@ func (ps *productService) Update(req *models.UpdateProductRequest) error {
 	if req.Category != "" {
 		updateMap["category"] = req.Category
 	}
+	// an explicit price from an admin becomes the new base price, the pricing rules apply on top of it again
+	// from the next stock change once the cooldown is over
 	if req.Price != 0 {
 		updateMap["price"] = req.Price
+		updateMap["base_price"] = req.Price
+		updateMap["price_changed_at"] = time.Now()
 	}
 
 	return ps.ProductStore.Update(updateMap, map[string]interface{}{"product_name": req.ProductName})
 }
+
+// Reprice runs the pricing rules over the requested product, or over every product when no name is given, and returns
+// the prices that change. With DryRun set nothing is written.
+func (ps *productService) Reprice(req *models.RepriceRequest) ([]models.PriceChangeResponse, error) {
+	var productNames []string
+	if req.ProductName != "" {
+		exists, err := ps.ProductStore.IsExists(map[string]interface{}{"product_name": req.ProductName})
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return nil, models.ErrProductNotFound
+		}
+
+		productNames = []string{req.ProductName}
+	}
+
+	var changes []models.PriceChangeResponse
+	db := ps.ProductStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changes, err = repriceProducts(tx, productNames, req.DryRun)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+func (ps *productService) GetPriceHistory(req *models.GetPriceHistoryRequest) ([]models.PriceHistoryDBModel, error) {
+	product, err := ps.ProductStore.GetOne(map[string]interface{}{"product_name": req.ProductName})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrProductNotFound
+		}
+
+		return nil, err
+	}
+
+	// the history of a deleted product with the same name is kept but isn't part of this one
+	history, err := ps.PriceHistoryStore.GetSince(req.ProductName, product.CreatedAt)
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// Import creates the products of the csv. Products that already exist are skipped so the same file can be imported
+// again. The errors name the line they were found at, the products of the lines before it are already created and
+// counted in the response.
+func (ps *productService) Import(req *models.ImportProductsRequest) (*models.ImportProductsResponse, error) {
+	reader := csv.NewReader(req.Input)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	resp := &models.ImportProductsResponse{}
+
+	header, err := reader.Read()
+	if err != nil {
+		return resp, err
+	}
+
+	if strings.Join(header, ",") != "product_name,category,price,quantity" {
+		return resp, models.ErrInvalidImportHeader
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return resp, err
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		price, err := strconv.Atoi(record[2])
+		if err != nil || price < 0 {
+			return resp, fmt.Errorf("line %d: %w", line, models.ErrInvalidPrice)
+		}
+
+		quantity, err := strconv.Atoi(record[3])
+		if err != nil || quantity < 0 {
+			return resp, fmt.Errorf("line %d: %w", line, models.ErrInvalidQuantity)
+		}
+
+		if err := ps.Create(&models.CreateProductRequest{
+			ProductName:     record[0],
+			Category:        record[1],
+			Price:           price,
+			InitialQuantity: quantity,
+			CreatedBy:       req.ImportedBy,
+		}); err != nil {
+			if err == models.ErrProductAlreadyExists {
+				resp.Skipped++
+				continue
+			}
+
+			return resp, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		resp.Created++
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"html/template"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// csrfFormField is the form field the csrf token of the post forms is read from.
+const csrfFormField = "_csrf"
+
+// templateRenderer renders the html files of the public directory, the template name is the path of the file. Every
+// file is parsed once. The templates can call csrfField to add the csrf token of the request to their forms.
+type templateRenderer struct {
+	mu        sync.Mutex
+	templates map[string]*template.Template
+}
+
+func newTemplateRenderer() *templateRenderer {
+	return &templateRenderer{
+		templates: map[string]*template.Template{},
+	}
+}
+
+// csrfFuncs returns the template functions for the request, the token is empty when the csrf middleware didn't run.
+func csrfFuncs(c echo.Context) template.FuncMap {
+	return template.FuncMap{
+		"csrfField": func() template.HTML {
+			token, _ := c.Get(middleware.DefaultCSRFConfig.ContextKey).(string)
+			return template.HTML(`<input type="hidden" name="` + csrfFormField + `" value="` + template.HTMLEscapeString(token) + `">`)
+		},
+	}
+}
+
+func (tr *templateRenderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	tr.mu.Lock()
+	tmpl, ok := tr.templates[name]
+	if !ok {
+		var err error
+		tmpl, err = template.New(filepath.Base(name)).Funcs(csrfFuncs(c)).ParseFiles(name)
+		if err != nil {
+			tr.mu.Unlock()
+			return err
+		}
+
+		tr.templates[name] = tmpl
+	}
+	tr.mu.Unlock()
+
+	// the parsed template is shared, the clone gets the functions of this request
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Funcs(csrfFuncs(c)).Execute(w, data)
+}
@@ -0,0 +1,759 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// apiError maps the errors returned by the services to the status codes used by the /api/v1 routes.
+func apiError(c echo.Context, err error) error {
+	var fields models.ValidationError
+	if errors.As(err, &fields) {
+		return echo.NewHTTPError(http.StatusBadRequest, models.ValidationErrorResponse{
+			Message: "invalid fields",
+			Fields:  fields,
+		})
+	}
+
+	switch err {
+	case models.ErrProductNotFound, models.ErrMatchingRecordNotFound, models.ErrCartItemNotFound, models.ErrOrderNotFound,
+		models.ErrPricingRuleNotFound, models.ErrUserNotFound, models.ErrNotAdmin,
+		models.ErrRoleNotAssigned, models.ErrAccountNotLocked, models.ErrSessionNotFound, models.ErrAPITokenNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err)
+	case models.ErrProductAlreadyExists, models.ErrInvalidTransition, models.ErrVersionConflict, models.ErrAlreadyAdmin,
+		models.ErrLastAdmin, models.ErrRoleAlreadyAssigned, models.ErrEmailAlreadyVerified, models.ErrTOTPAlreadyEnabled,
+		models.ErrTOTPNotEnabled, models.ErrTOTPNotEnrolled:
+		return echo.NewHTTPError(http.StatusConflict, err)
+	case models.ErrInvalidOperaton, models.ErrInvalidQuantity, models.ErrInvalidPrice,
+		models.ErrEmptyOrder, models.ErrDuplicateOrderLine, models.ErrInvalidOrderLine, models.ErrCartEmpty,
+		models.ErrInvalidOrderStatus, models.ErrInvalidPricingRule, models.ErrInvalidRole, models.ErrInvalidToken,
+		models.ErrInvalidScope, models.ErrUnsupportedGrantType, models.ErrInvalidTOTPCode:
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	case models.ErrInvalidCredentials, models.ErrInvalidRefreshToken, models.ErrRefreshTokenReused, models.ErrTOTPCodeRequired:
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	case models.ErrScopeNotGranted:
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	case models.ErrTooManyAttempts:
+		return echo.NewHTTPError(http.StatusTooManyRequests, err)
+	}
+
+	c.Logger().Error(err)
+	return err
+}
+
+// HandleAPICSRFToken returns the token to send in the X-CSRF-Token header of the json requests authenticated by the
+// session cookie.
+func (app *Application) HandleAPICSRFToken(c echo.Context) error {
+	token, _ := c.Get(middleware.DefaultCSRFConfig.ContextKey).(string)
+	return c.JSON(http.StatusOK, models.CSRFTokenResponse{Token: token})
+}
+
+// HandleAPIAuthToken issues a jwt access token and a refresh token for the password or the refresh_token grant.
+func (app *Application) HandleAPIAuthToken(c echo.Context) error {
+	req := &models.AuthTokenRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.IP = c.RealIP()
+
+	resp, err := app.AuthTokenService.Issue(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIRevokeRefreshToken(c echo.Context) error {
+	req := &models.RevokeRefreshTokenRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	if err := app.AuthTokenService.Revoke(req); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPITwoFactorStatus(c echo.Context) error {
+	resp, err := app.TwoFactorService.Status(sessionUsername(c))
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIEnrollTOTP(c echo.Context) error {
+	resp, err := app.TwoFactorService.Enroll(sessionUsername(c))
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// HandleAPIEnableTOTP returns the recovery codes, which are only ever shown here.
+func (app *Application) HandleAPIEnableTOTP(c echo.Context) error {
+	req := &models.VerifyTOTPRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.Username = sessionUsername(c)
+	req.IP = c.RealIP()
+
+	resp, err := app.TwoFactorService.Enable(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIDisableTOTP(c echo.Context) error {
+	req := &models.VerifyTOTPRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.Username = sessionUsername(c)
+	req.IP = c.RealIP()
+
+	if err := app.TwoFactorService.Disable(req); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleAPIJWKS returns the public keys verifying the access tokens.
+func (app *Application) HandleAPIJWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, app.AuthTokenService.JWKS())
+}
+
+func (app *Application) HandleAPIListProducts(c echo.Context) error {
+	req := &models.GetProductsRequest{
+		Category: c.QueryParam("category"),
+	}
+
+	resp, err := app.ProductService.Get(req)
+	if err != nil {
+		if err == models.ErrMatchingRecordNotFound {
+			return c.JSON(http.StatusOK, map[string]models.ProductResponse{})
+		}
+
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIGetProduct(c echo.Context) error {
+	resp, err := app.ProductService.Get(&models.GetProductsRequest{
+		ProductName: c.Param("name"),
+	})
+	if err != nil {
+		if err == models.ErrMatchingRecordNotFound {
+			return apiError(c, models.ErrProductNotFound)
+		}
+
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPICreateProduct(c echo.Context) error {
+	req := &models.CreateProductRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	if req.ProductName == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "product_name is required")
+	}
+	req.CreatedBy = sessionUsername(c)
+
+	if req.Price < 0 {
+		return apiError(c, models.ErrInvalidPrice)
+	}
+
+	if req.InitialQuantity < 0 {
+		return apiError(c, models.ErrInvalidQuantity)
+	}
+
+	if err := app.ProductService.Create(req); err != nil {
+		return apiError(c, err)
+	}
+
+	resp, err := app.ProductService.Get(&models.GetProductsRequest{
+		ProductName: req.ProductName,
+	})
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
+
+func (app *Application) HandleAPIUpdateProduct(c echo.Context) error {
+	req := &models.UpdateProductRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.ProductName = c.Param("name")
+
+	if req.Price < 0 {
+		return apiError(c, models.ErrInvalidPrice)
+	}
+
+	if err := app.ProductService.Update(req); err != nil {
+		return apiError(c, err)
+	}
+
+	resp, err := app.ProductService.Get(&models.GetProductsRequest{
+		ProductName: req.ProductName,
+	})
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIDeleteProduct(c echo.Context) error {
+	if err := app.ProductService.Delete(&models.DeleteProductRequest{
+		ProductName: c.Param("name"),
+	}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPIPriceHistory(c echo.Context) error {
+	history, err := app.ProductService.GetPriceHistory(&models.GetPriceHistoryRequest{
+		ProductName: c.Param("name"),
+	})
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
+func (app *Application) HandleAPIListOrders(c echo.Context) error {
+	resp, err := app.OrderService.GetOrdersByUsername(sessionUsername(c))
+	if err != nil {
+		if err == models.ErrMatchingRecordNotFound {
+			return c.JSON(http.StatusOK, map[string]models.OrdersResponse{})
+		}
+
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPICreateOrder(c echo.Context) error {
+	req := &models.CreateOrderRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	// orders are always placed on behalf of the authenticated user
+	req.Username = sessionUsername(c)
+
+	resp, err := app.OrderService.Create(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
+
+func (app *Application) HandleAPIAdminOrders(c echo.Context) error {
+	sortBy := c.QueryParam("sort")
+	if sortBy != "" && sortBy != "created_at" && sortBy != "total_cost" {
+		return echo.NewHTTPError(http.StatusBadRequest, "sort must be created_at or total_cost")
+	}
+
+	resp, err := app.OrderService.GetOrdersForAdmin(&models.GetOrdersForAdminRequest{
+		Username:    c.QueryParam("username"),
+		ProductName: c.QueryParam("product_name"),
+		Status:      c.QueryParam("status"),
+		SortBy:      sortBy,
+	})
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIUpdateOrderStatus(c echo.Context) error {
+	req := &models.UpdateOrderStatusRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.OrderID = c.Param("id")
+
+	order, err := app.OrderService.UpdateStatus(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+func (app *Application) HandleAPICancelOrder(c echo.Context) error {
+	req := &models.CancelOrderRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.OrderID = c.Param("id")
+	req.CancelledBy = sessionUsername(c)
+	req.AsAdmin = app.hasPermission(c, models.PermissionOrdersWrite)
+
+	cancellation, err := app.OrderService.Cancel(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, cancellation)
+}
+
+func inventoryETag(resp *models.InventoryResponse) string {
+	return `"` + strconv.Itoa(resp.Version) + `"`
+}
+
+func (app *Application) HandleAPIGetInventory(c echo.Context) error {
+	resp, err := app.InventoryService.Get(&models.GetInventoryRequest{
+		ProductName: c.Param("name"),
+	})
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	c.Response().Header().Set("ETag", inventoryETag(resp))
+	return c.JSON(http.StatusOK, resp)
+}
+
+// HandleAPIUpdateInventory takes the expected version either from the expected_version field of the body or from an
+// If-Match header carrying the ETag returned by HandleAPIGetInventory.
+func (app *Application) HandleAPIUpdateInventory(c echo.Context) error {
+	req := &models.UpdateInventoryRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.ProductName = c.Param("name")
+	req.UpdatedBy = sessionUsername(c)
+
+	if ifMatch := c.Request().Header.Get("If-Match"); ifMatch != "" && req.ExpectedVersion == nil {
+		version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid If-Match header")
+		}
+		req.ExpectedVersion = &version
+	}
+
+	resp, err := app.InventoryService.Update(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	c.Response().Header().Set("ETag", inventoryETag(resp))
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIInventoryLedger(c echo.Context) error {
+	resp, err := app.InventoryService.GetLedger(&models.GetInventoryLedgerRequest{
+		ProductName: c.Param("name"),
+	})
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIGetCart(c echo.Context) error {
+	resp, err := app.CartService.Get(sessionUsername(c))
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIAddCartItem(c echo.Context) error {
+	req := &models.AddCartItemRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.Username = sessionUsername(c)
+
+	if err := app.CartService.AddItem(req); err != nil {
+		return apiError(c, err)
+	}
+
+	return app.HandleAPIGetCart(c)
+}
+
+func (app *Application) HandleAPIUpdateCartItem(c echo.Context) error {
+	req := &models.UpdateCartItemRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.Username = sessionUsername(c)
+	req.ProductName = c.Param("name")
+
+	if err := app.CartService.UpdateItem(req); err != nil {
+		return apiError(c, err)
+	}
+
+	return app.HandleAPIGetCart(c)
+}
+
+func (app *Application) HandleAPIRemoveCartItem(c echo.Context) error {
+	if err := app.CartService.RemoveItem(&models.RemoveCartItemRequest{
+		Username:    sessionUsername(c),
+		ProductName: c.Param("name"),
+	}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPICheckout(c echo.Context) error {
+	resp, err := app.CartService.Checkout(sessionUsername(c))
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
+
+func (app *Application) HandleAPIListPricingRules(c echo.Context) error {
+	rules, err := app.PricingService.GetRules()
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, rules)
+}
+
+func (app *Application) HandleAPICreatePricingRule(c echo.Context) error {
+	req := &models.CreatePricingRuleRequest{Enabled: true}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	rule, err := app.PricingService.CreateRule(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, rule)
+}
+
+func (app *Application) HandleAPIUpdatePricingRule(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return apiError(c, models.ErrPricingRuleNotFound)
+	}
+
+	req := &models.UpdatePricingRuleRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.ID = id
+
+	rule, err := app.PricingService.UpdateRule(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+func (app *Application) HandleAPIDeletePricingRule(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return apiError(c, models.ErrPricingRuleNotFound)
+	}
+
+	if err := app.PricingService.DeleteRule(&models.DeletePricingRuleRequest{ID: id}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleAPIReprice serves both the dry-run and the apply endpoints, the optional product_name query parameter limits
+// the run to a single product.
+func (app *Application) HandleAPIReprice(dryRun bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		changes, err := app.ProductService.Reprice(&models.RepriceRequest{
+			ProductName: c.QueryParam("product_name"),
+			DryRun:      dryRun,
+		})
+		if err != nil {
+			return apiError(c, err)
+		}
+
+		return c.JSON(http.StatusOK, changes)
+	}
+}
+
+func (app *Application) HandleAPIListAdmins(c echo.Context) error {
+	admins, err := app.AdminService.List()
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, admins)
+}
+
+func (app *Application) HandleAPIGrantAdmin(c echo.Context) error {
+	req := &models.GrantAdminRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.GrantedBy = sessionUsername(c)
+
+	if err := app.AdminService.Grant(req); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, models.AdminDBModel{Username: req.Username})
+}
+
+func (app *Application) HandleAPIRevokeAdmin(c echo.Context) error {
+	if err := app.AdminService.Revoke(&models.RevokeAdminRequest{
+		Username:  c.Param("username"),
+		RevokedBy: sessionUsername(c),
+	}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPIAdminChanges(c echo.Context) error {
+	changes, err := app.AdminService.GetChanges()
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, changes)
+}
+
+func (app *Application) HandleAPIListLockouts(c echo.Context) error {
+	lockouts, err := app.UserService.GetLockouts()
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, lockouts)
+}
+
+func (app *Application) HandleAPIUnlock(c echo.Context) error {
+	if err := app.UserService.Unlock(&models.UnlockAccountRequest{
+		Username:   c.Param("username"),
+		UnlockedBy: sessionUsername(c),
+	}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPIRevokeUserSessions(c echo.Context) error {
+	if err := app.SessionService.RevokeAll(&models.RevokeAllSessionsRequest{
+		Username:  c.Param("username"),
+		RevokedBy: sessionUsername(c),
+	}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPIListTokens(c echo.Context) error {
+	resp, err := app.APITokenService.List(sessionUsername(c))
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPICreateToken(c echo.Context) error {
+	req := &models.CreateAPITokenRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	req.Username = sessionUsername(c)
+
+	resp, err := app.APITokenService.Create(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
+
+func (app *Application) HandleAPIRevokeToken(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := app.APITokenService.Revoke(&models.RevokeAPITokenRequest{
+		ID:       id,
+		Username: sessionUsername(c),
+	}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPIListSessions(c echo.Context) error {
+	resp, err := app.SessionService.List(sessionUsername(c), sessionToken(c))
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (app *Application) HandleAPIRevokeSession(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := app.SessionService.Revoke(&models.RevokeSessionRequest{
+		ID:       id,
+		Username: sessionUsername(c),
+	}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPIListRoles(c echo.Context) error {
+	return c.JSON(http.StatusOK, app.RoleService.Roles())
+}
+
+func (app *Application) HandleAPIUserRoles(c echo.Context) error {
+	roles, err := app.RoleService.GetUserRoles(c.Param("username"))
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+func (app *Application) HandleAPIAssignRole(c echo.Context) error {
+	if err := app.RoleService.Assign(&models.AssignRoleRequest{
+		Username:   c.Param("username"),
+		Role:       c.Param("role"),
+		AssignedBy: sessionUsername(c),
+	}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPIUnassignRole(c echo.Context) error {
+	if err := app.RoleService.Unassign(&models.UnassignRoleRequest{
+		Username:     c.Param("username"),
+		Role:         c.Param("role"),
+		UnassignedBy: sessionUsername(c),
+	}); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (app *Application) HandleAPISignUp(c echo.Context) error {
+	req := &models.SignUpRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	user, err := app.UserService.SignUp(req)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	if err := app.AccountService.SendVerification(user.Username); err != nil {
+		c.Logger().Error(err)
+	}
+
+	return c.JSON(http.StatusCreated, user)
+}
+
+func (app *Application) HandleAPIResendVerification(c echo.Context) error {
+	if err := app.AccountService.SendVerification(sessionUsername(c)); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (app *Application) HandleAPIVerifyEmail(c echo.Context) error {
+	req := &models.VerifyEmailRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	if err := app.AccountService.VerifyEmail(req); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleAPIRequestPasswordReset answers the same whether the email belongs to someone or not.
+func (app *Application) HandleAPIRequestPasswordReset(c echo.Context) error {
+	req := &models.PasswordResetRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	if err := app.AccountService.RequestPasswordReset(req); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (app *Application) HandleAPIConfirmPasswordReset(c echo.Context) error {
+	req := &models.ConfirmPasswordResetRequest{}
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	if err := app.AccountService.ResetPassword(req); err != nil {
+		return apiError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"gorm.io/gorm"
+)
+
+// rolePermissions lists the permissions granted by every role.
+var rolePermissions = map[string][]string{
+	models.RoleSuperAdmin: {
+		models.PermissionDashboard, models.PermissionProductsWrite, models.PermissionInventoryRead,
+		models.PermissionInventoryWrite, models.PermissionOrdersRead, models.PermissionOrdersWrite,
+		models.PermissionPricingManage, models.PermissionAdminsManage, models.PermissionUsersManage,
+	},
+	models.RoleCatalogManager: {models.PermissionDashboard, models.PermissionProductsWrite, models.PermissionPricingManage},
+	models.RoleInventoryClerk: {models.PermissionDashboard, models.PermissionInventoryRead, models.PermissionInventoryWrite},
+	models.RoleOrderViewer:    {models.PermissionDashboard, models.PermissionOrdersRead},
+}
+
+// isAssignableRole reports whether the role is assigned through user_roles, super admins are managed by the
+// AdminService so the last admin safeguard can't be bypassed.
+func isAssignableRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok && role != models.RoleSuperAdmin
+}
+
+// isPermission reports whether the permission exists, the super admin role holds all of them.
+func isPermission(permission string) bool {
+	for _, p := range rolePermissions[models.RoleSuperAdmin] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// userScopes are held by every user, a token may have them whatever the roles of its owner.
+var userScopes = []string{models.ScopeOrdersPlace, models.ScopeCartWrite}
+
+func isUserScope(scope string) bool {
+	for _, s := range userScopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+type RoleService interface {
+	Roles() []models.RoleResponse
+	GetUserRoles(username string) ([]string, error)
+	HasPermission(username, permission string) (bool, error)
+	Assign(*models.AssignRoleRequest) error
+	Unassign(*models.UnassignRoleRequest) error
+}
+
+type roleService struct {
+	store.UserRoleStore
+	store.AdminsStore
+}
+
+func NewRoleService(userRoleStore store.UserRoleStore, adminsStore store.AdminsStore) RoleService {
+	return &roleService{
+		UserRoleStore: userRoleStore,
+		AdminsStore:   adminsStore,
+	}
+}
+
+func (rs *roleService) Roles() []models.RoleResponse {
+	resp := []models.RoleResponse{}
+	for role, permissions := range rolePermissions {
+		resp = append(resp, models.RoleResponse{
+			Name:        role,
+			Permissions: permissions,
+			Assignable:  isAssignableRole(role),
+		})
+	}
+
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Name < resp[j].Name
+	})
+
+	return resp
+}
+
+// GetUserRoles returns the roles of the user, including the super admin role of the users in the admins table.
+func (rs *roleService) GetUserRoles(username string) ([]string, error) {
+	roles := []string{}
+
+	isAdmin, err := rs.AdminsStore.IsExists(map[string]interface{}{"username": username})
+	if err != nil {
+		return nil, err
+	}
+
+	if isAdmin {
+		roles = append(roles, models.RoleSuperAdmin)
+	}
+
+	assigned, err := rs.UserRoleStore.GetMany(map[string]interface{}{"username": username})
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	for _, userRole := range assigned {
+		roles = append(roles, userRole.Role)
+	}
+
+	return roles, nil
+}
+
+func (rs *roleService) HasPermission(username, permission string) (bool, error) {
+	roles, err := rs.GetUserRoles(username)
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// Assign gives the role to the user and records the change in the admin changes, in the same transaction.
+func (rs *roleService) Assign(req *models.AssignRoleRequest) error {
+	if !isAssignableRole(req.Role) {
+		return models.ErrInvalidRole
+	}
+
+	db := rs.UserRoleStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		usersStore := store.NewUsersStore(tx)
+		userRoleStore := store.NewUserRoleStore(tx)
+		adminChangeStore := store.NewAdminChangeStore(tx)
+
+		exists, err := usersStore.IsExists(map[string]interface{}{"username": req.Username})
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return models.ErrUserNotFound
+		}
+
+		whereMap := map[string]interface{}{"username": req.Username, "role": req.Role}
+
+		assigned, err := userRoleStore.IsExists(whereMap)
+		if err != nil {
+			return err
+		}
+
+		if assigned {
+			return models.ErrRoleAlreadyAssigned
+		}
+
+		now := time.Now()
+		if err := userRoleStore.Create(models.UserRoleDBModel{
+			Username:  req.Username,
+			Role:      req.Role,
+			GrantedBy: req.AssignedBy,
+			GrantedAt: now,
+		}); err != nil {
+			return err
+		}
+
+		return adminChangeStore.Create(models.AdminChangeDBModel{
+			Username:  req.Username,
+			Action:    models.AdminChangeGrant,
+			Role:      req.Role,
+			ChangedBy: req.AssignedBy,
+			ChangedAt: now,
+		})
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("role %s granted to %s by %s", req.Role, req.Username, req.AssignedBy)
+	return nil
+}
+
+func (rs *roleService) Unassign(req *models.UnassignRoleRequest) error {
+	if !isAssignableRole(req.Role) {
+		return models.ErrInvalidRole
+	}
+
+	db := rs.UserRoleStore.DB()
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		userRoleStore := store.NewUserRoleStore(tx)
+		adminChangeStore := store.NewAdminChangeStore(tx)
+
+		whereMap := map[string]interface{}{"username": req.Username, "role": req.Role}
+
+		assigned, err := userRoleStore.IsExists(whereMap)
+		if err != nil {
+			return err
+		}
+
+		if !assigned {
+			return models.ErrRoleNotAssigned
+		}
+
+		if err := userRoleStore.Delete(whereMap); err != nil {
+			return err
+		}
+
+		return adminChangeStore.Create(models.AdminChangeDBModel{
+			Username:  req.Username,
+			Action:    models.AdminChangeRevoke,
+			Role:      req.Role,
+			ChangedBy: req.UnassignedBy,
+			ChangedAt: time.Now(),
+		})
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("role %s revoked from %s by %s", req.Role, req.Username, req.UnassignedBy)
+	return nil
+}
@@ -1,100 +1,361 @@
 package api
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"image/png"
 	"net/http"
 	"strconv"
 
 	"github.com/NikhilSharmaWe/market/models"
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo/v4/middleware"
-	"golang.org/x/crypto/bcrypt"
 
 	echo "github.com/labstack/echo/v4"
-
-	"gorm.io/gorm"
 )
 
 func (app *Application) Router() *echo.Echo {
 	e := echo.New()
+	e.Renderer = newTemplateRenderer()
 
 	e.Pre(middleware.RemoveTrailingSlash())
 
+	e.IPExtractor = newIPExtractor(app.config)
+
+	// the forms carry the token in the _csrf field, the json requests sent with the session cookie in the
+	// X-CSRF-Token header, which GET /api/v1/auth/csrf returns
+	e.Use(middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper:        csrfExempt,
+		TokenLookup:    "header:" + echo.HeaderXCSRFToken + ",form:" + csrfFormField,
+		CookieName:     csrfFormField,
+		CookiePath:     "/",
+		CookieHTTPOnly: true,
+		CookieSecure:   app.config.SessionCookieSecure,
+		CookieSameSite: app.config.SessionCookieSameSite,
+	}))
+
 	e.Use(app.createSessionMiddleware)
 
+	// every staff role can open the admin pages, each route then requires its own permission
 	admin := e.Group("/admin")
-	admin.Use(app.IfNotLogined, app.IfNotAdmin)
+	admin.Use(app.IfNotLogined, app.IfSecondFactorMissing, app.RequirePermission(models.PermissionDashboard))
+
+	canWriteProducts := app.RequirePermission(models.PermissionProductsWrite)
+	canReadInventory := app.RequirePermission(models.PermissionInventoryRead)
+	canWriteInventory := app.RequirePermission(models.PermissionInventoryWrite)
+	canReadOrders := app.RequirePermission(models.PermissionOrdersRead)
+	canWriteOrders := app.RequirePermission(models.PermissionOrdersWrite)
+	canManagePricing := app.RequirePermission(models.PermissionPricingManage)
+	canManageAdmins := app.RequirePermission(models.PermissionAdminsManage)
+	canManageUsers := app.RequirePermission(models.PermissionUsersManage)
+	canPlaceOrders := app.RequireScope(models.ScopeOrdersPlace)
+	canWriteCart := app.RequireScope(models.ScopeCartWrite)
 
 	user := e.Group("/user")
 	user.Use(app.IfNotLogined)
 
 	e.Static("/assets", "./public")
 
-	e.GET("/", ServeHTML("./public/login/index.html"), app.IfAlreadyLogined)
+	e.GET("/", app.HandleSignInPage, app.IfAlreadyLogined)
 	e.POST("/", app.HandleSignIn)
 
-	e.GET("/signup", ServeHTML("./public/signup/index.html"), app.IfAlreadyLogined)
+	e.GET("/oidc/login", app.HandleOIDCLogin, app.IfAlreadyLogined)
+	e.GET("/oidc/callback", app.HandleOIDCCallback)
+
+	e.GET("/signin/second-factor", ServeHTML("./public/second_factor/index.html"), app.IfAlreadyLogined)
+	e.POST("/signin/second-factor", app.HandleSecondFactor)
+
+	e.GET("/signup", app.HandleSignUpPage, app.IfAlreadyLogined)
 	e.POST("/signup", app.HandleSignUp)
 
+	e.GET("/verify-email", app.HandleVerifyEmail)
+
+	e.GET("/forgot-password", ServeHTML("./public/forgot_password/index.html"))
+	e.POST("/forgot-password", app.HandleForgotPassword)
+
+	e.GET("/reset-password", app.HandleResetPasswordPage)
+	e.POST("/reset-password", app.HandleResetPassword)
+
+	// the json apis open to anonymous users
+	e.GET("/api/v1/auth/csrf", app.HandleAPICSRFToken)
+	e.POST("/api/v1/auth/token", app.HandleAPIAuthToken)
+	e.POST("/api/v1/auth/revoke", app.HandleAPIRevokeRefreshToken)
+	e.GET("/api/v1/auth/jwks", app.HandleAPIJWKS)
+	e.POST("/api/v1/users", app.HandleAPISignUp)
+	e.POST("/api/v1/auth/verify-email", app.HandleAPIVerifyEmail)
+	e.POST("/api/v1/auth/password-reset", app.HandleAPIRequestPasswordReset)
+	e.POST("/api/v1/auth/password-reset/confirm", app.HandleAPIConfirmPasswordReset)
+
 	e.GET("/logout", app.HandleLogout)
 
 	// user apis
 	user.GET("/home", ServeHTML("./public/home/index.html"))
 
+	user.POST("/verify-email/resend", app.HandleResendVerification)
+
+	user.GET("/sessions", ServeHTML("./public/sessions/index.html"))
+	user.GET("/sessions/list", app.HandleMySessions)
+	user.POST("/sessions/revoke", app.HandleRevokeSession)
+
+	user.GET("/2fa", ServeHTML("./public/two_factor/index.html"))
+	user.GET("/2fa/status", app.HandleTwoFactorStatus)
+	user.GET("/2fa/qr", app.HandleTwoFactorQRCode)
+	user.POST("/2fa/:operation", app.HandleTwoFactorOperations)
+
+	user.GET("/tokens", ServeHTML("./public/tokens/index.html"))
+	user.GET("/tokens/list", app.HandleMyTokens)
+	user.POST("/tokens/:operation", app.HandleTokenOperations)
+
 	user.GET("/order", ServeHTML("./public/order/index.html"))
 	user.POST("/order", app.HandleUserOrder)
 
+	user.POST("/order/cancel", app.HandleUserCancelOrder)
+
 	user.GET("/myorders", app.HandleMyOrders)
 
 	user.GET("/products", ServeHTML("./public/product/index.html"))
 	user.POST("/products", app.HandleGetProducts)
 
+	user.GET("/cart", ServeHTML("./public/cart/index.html"))
+	user.GET("/mycart", app.HandleMyCart)
+	user.POST("/cart/:operation", app.HandleCartOperations)
+
 	// admin apis
 	admin.GET("/home", ServeHTML("./public/admin_home/index.html"))
 
-	admin.GET("/product", ServeHTML("./public/admin_product/index.html"))
-
-	admin.GET("/product/:operation", app.HandleAdminProductFiles)
-	admin.POST("/product/:operation", app.HandleAdminProductOperations, app.IfNotLogined)
-
-	admin.GET("/inventory", ServeHTML("./public/admin_inventory/index.html"), app.IfNotLogined)
-	admin.POST("/inventory", app.HandleInventory, app.IfNotLogined)
-
-	admin.GET("/order", ServeHTML("./public/admin_order/index.html"), app.IfNotLogined)
-	admin.POST("/order", app.HandleAdminOrder, app.IfNotLogined)
+	admin.GET("/product", ServeHTML("./public/admin_product/index.html"), canWriteProducts)
+
+	admin.GET("/product/:operation", app.HandleAdminProductFiles, canWriteProducts)
+	admin.POST("/product/:operation", app.HandleAdminProductOperations, canWriteProducts)
+
+	admin.GET("/inventory", app.HandleInventoryPage, canWriteInventory)
+	admin.POST("/inventory", app.HandleInventory, canWriteInventory)
+	admin.GET("/inventory/ledger", app.HandleInventoryLedger, canReadInventory)
+
+	admin.GET("/pricing", ServeHTML("./public/admin_pricing/index.html"), canManagePricing)
+	admin.GET("/pricing/rules", app.HandlePricingRules, canManagePricing)
+	admin.POST("/pricing/rules/:operation", app.HandlePricingRuleOperations, canManagePricing)
+	admin.POST("/pricing/reprice", app.HandleReprice, canManagePricing)
+
+	admin.GET("/admins", ServeHTML("./public/admin_admins/index.html"), canManageAdmins)
+	admin.GET("/admins/list", app.HandleAdmins, canManageAdmins)
+	admin.GET("/admins/changes", app.HandleAdminChanges, canManageAdmins)
+	admin.POST("/admins/:operation", app.HandleAdminOperations, canManageAdmins)
+
+	admin.GET("/roles", ServeHTML("./public/admin_roles/index.html"), canManageAdmins)
+	admin.GET("/roles/list", app.HandleRoles, canManageAdmins)
+	admin.GET("/roles/user", app.HandleUserRoles, canManageAdmins)
+	admin.POST("/roles/:operation", app.HandleRoleOperations, canManageAdmins)
+
+	admin.GET("/users", ServeHTML("./public/admin_users/index.html"), canManageUsers)
+	admin.GET("/users/lockouts", app.HandleLockouts, canManageUsers)
+	admin.POST("/users/:operation", app.HandleUserOperations, canManageUsers)
+
+	admin.GET("/order", ServeHTML("./public/admin_order/index.html"), canReadOrders)
+	admin.POST("/order", app.HandleAdminOrder, canReadOrders)
+	admin.POST("/order/status", app.HandleAdminOrderStatus, canWriteOrders)
+	admin.POST("/order/cancel", app.HandleAdminCancelOrder, canWriteOrders)
+
+	// json apis
+	v1 := e.Group("/api/v1")
+	v1.Use(app.BearerAuth, app.IfNotAuthenticated)
+
+	v1.POST("/auth/verification", app.HandleAPIResendVerification, app.RequireSession)
+
+	v1.GET("/products", app.HandleAPIListProducts)
+	v1.GET("/products/:name", app.HandleAPIGetProduct)
+	v1.POST("/products", app.HandleAPICreateProduct, canWriteProducts)
+	v1.PATCH("/products/:name", app.HandleAPIUpdateProduct, canWriteProducts)
+	v1.DELETE("/products/:name", app.HandleAPIDeleteProduct, canWriteProducts)
+	v1.GET("/products/:name/price-history", app.HandleAPIPriceHistory)
+
+	v1.GET("/orders", app.HandleAPIListOrders)
+	v1.POST("/orders", app.HandleAPICreateOrder, canPlaceOrders)
+	v1.GET("/admin/orders", app.HandleAPIAdminOrders, canReadOrders)
+	v1.PATCH("/orders/:id/status", app.HandleAPIUpdateOrderStatus, canWriteOrders)
+	v1.POST("/orders/:id/cancel", app.HandleAPICancelOrder, canPlaceOrders)
+
+	v1.GET("/pricing/rules", app.HandleAPIListPricingRules, canManagePricing)
+	v1.POST("/pricing/rules", app.HandleAPICreatePricingRule, canManagePricing)
+	v1.PATCH("/pricing/rules/:id", app.HandleAPIUpdatePricingRule, canManagePricing)
+	v1.DELETE("/pricing/rules/:id", app.HandleAPIDeletePricingRule, canManagePricing)
+	v1.POST("/pricing/dry-run", app.HandleAPIReprice(true), canManagePricing)
+	v1.POST("/pricing/apply", app.HandleAPIReprice(false), canManagePricing)
+
+	v1.GET("/admins", app.HandleAPIListAdmins, canManageAdmins)
+	v1.POST("/admins", app.HandleAPIGrantAdmin, canManageAdmins)
+	v1.DELETE("/admins/:username", app.HandleAPIRevokeAdmin, canManageAdmins)
+	v1.GET("/admins/changes", app.HandleAPIAdminChanges, canManageAdmins)
+
+	v1.GET("/roles", app.HandleAPIListRoles, canManageAdmins)
+	v1.GET("/users/:username/roles", app.HandleAPIUserRoles, canManageAdmins)
+	v1.PUT("/users/:username/roles/:role", app.HandleAPIAssignRole, canManageAdmins)
+	v1.DELETE("/users/:username/roles/:role", app.HandleAPIUnassignRole, canManageAdmins)
+
+	v1.GET("/lockouts", app.HandleAPIListLockouts, canManageUsers)
+	v1.DELETE("/lockouts/:username", app.HandleAPIUnlock, canManageUsers)
+	v1.DELETE("/users/:username/sessions", app.HandleAPIRevokeUserSessions, canManageUsers)
+
+	v1.GET("/tokens", app.HandleAPIListTokens, app.RequireSession)
+	v1.POST("/tokens", app.HandleAPICreateToken, app.RequireSession)
+	v1.DELETE("/tokens/:id", app.HandleAPIRevokeToken, app.RequireSession)
+
+	v1.GET("/2fa", app.HandleAPITwoFactorStatus, app.RequireSession)
+	v1.POST("/2fa/enroll", app.HandleAPIEnrollTOTP, app.RequireSession)
+	v1.POST("/2fa/enable", app.HandleAPIEnableTOTP, app.RequireSession)
+	v1.POST("/2fa/disable", app.HandleAPIDisableTOTP, app.RequireSession)
+
+	v1.GET("/sessions", app.HandleAPIListSessions, app.RequireSession)
+	v1.DELETE("/sessions/:id", app.HandleAPIRevokeSession, app.RequireSession)
+
+	v1.GET("/cart", app.HandleAPIGetCart)
+	v1.POST("/cart/items", app.HandleAPIAddCartItem, canWriteCart)
+	v1.PATCH("/cart/items/:name", app.HandleAPIUpdateCartItem, canWriteCart)
+	v1.DELETE("/cart/items/:name", app.HandleAPIRemoveCartItem, canWriteCart)
+	v1.POST("/cart/checkout", app.HandleAPICheckout, canPlaceOrders)
+
+	v1.GET("/inventory/:name", app.HandleAPIGetInventory, canReadInventory)
+	v1.PATCH("/inventory/:name", app.HandleAPIUpdateInventory, canWriteInventory)
+	v1.GET("/inventory/:name/ledger", app.HandleAPIInventoryLedger, canReadInventory)
 
 	return e
 }
 
+// ServeHTML renders the page as a template without data, so its forms get the csrf token.
 func ServeHTML(htmlPath string) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		return c.File(htmlPath)
+		return c.Render(http.StatusOK, htmlPath, nil)
 	}
 }
 
+const signUpTemplate = "./public/signup/index.html"
+
+// signUpPage is the data of the signup template, the form is rendered again with the errors next to the fields and
+// the values entered, except the password.
+type signUpPage struct {
+	Username string
+	Email    string
+	Errors   models.ValidationError
+}
+
+func (app *Application) HandleSignUpPage(c echo.Context) error {
+	return c.Render(http.StatusOK, signUpTemplate, signUpPage{})
+}
+
 func (app *Application) HandleSignUp(c echo.Context) error {
-	user, err := userFromContext(c)
-	if err != nil {
+	req := signUpReqFromContext(c)
+
+	if _, err := app.UserService.SignUp(req); err != nil {
+		var fields models.ValidationError
+		if errors.As(err, &fields) {
+			return c.Render(http.StatusBadRequest, signUpTemplate, signUpPage{
+				Username: req.Username,
+				Email:    req.Email,
+				Errors:   fields,
+			})
+		}
+
 		c.Logger().Error(err)
 		return err
 	}
 
-	exists, err := app.UsersStore.IsExists(map[string]interface{}{"username": user.Username})
+	// the account is usable right away, a failed email can be sent again from the home page
+	if err := app.AccountService.SendVerification(req.Username); err != nil {
+		c.Logger().Error(err)
+	}
+
+	if err := setSession(c, req.Username); err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.Redirect(http.StatusSeeOther, "/user/home/"); err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// signInPage is the data of the login template, SSO shows the link to sign in with the identity provider.
+type signInPage struct {
+	SSO bool
+}
+
+func (app *Application) HandleSignInPage(c echo.Context) error {
+	return c.Render(http.StatusOK, "./public/login/index.html", signInPage{SSO: app.OIDCService.Enabled()})
+}
+
+func (app *Application) HandleSignIn(c echo.Context) error {
+	user, err := app.UserService.Authenticate(&models.SignInRequest{
+		Username: c.FormValue("username"),
+		Password: c.FormValue("password"),
+		IP:       c.RealIP(),
+	})
 	if err != nil {
+		if err == models.ErrInvalidCredentials {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		if err == models.ErrTooManyAttempts {
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		}
+
 		c.Logger().Error(err)
 		return err
 	}
 
-	if exists {
-		return echo.NewHTTPError(http.StatusBadRequest, "user already exists")
+	// the users with two-factor authentication are only signed in by the second step
+	if user.TOTPEnabledAt != nil {
+		if err := app.startSecondFactor(c, user.Username); err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/signin/second-factor")
 	}
 
-	if err := app.UsersStore.Create(*user); err != nil {
+	if err := setSession(c, user.Username); err != nil {
 		c.Logger().Error(err)
 		return err
 	}
 
-	if err := setSession(c); err != nil {
+	if err := c.Redirect(http.StatusSeeOther, "/user/home/"); err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// HandleSecondFactor completes the sign in started with the password when the code is right.
+func (app *Application) HandleSecondFactor(c echo.Context) error {
+	username := app.secondFactorUsername(c)
+	if username == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "the sign in expired, sign in again")
+	}
+
+	if err := app.TwoFactorService.Verify(&models.VerifyTOTPRequest{
+		Username: username,
+		Code:     c.FormValue("code"),
+		IP:       c.RealIP(),
+	}); err != nil {
+		if err == models.ErrInvalidTOTPCode {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		if err == models.ErrTooManyAttempts {
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		}
+
+		c.Logger().Error(err)
+		return err
+	}
+
+	app.clearSecondFactor(c)
+
+	if err := setSession(c, username); err != nil {
 		c.Logger().Error(err)
 		return err
 	}
@@ -107,25 +368,77 @@ func (app *Application) HandleSignUp(c echo.Context) error {
 	return nil
 }
 
-func (app *Application) HandleSignIn(c echo.Context) error {
-	username := c.FormValue("username")
-	user, err := app.UsersStore.GetOne(map[string]interface{}{"username": username})
+// HandleOIDCLogin sends the user to sign in at the identity provider.
+func (app *Application) HandleOIDCLogin(c echo.Context) error {
+	if !app.OIDCService.Enabled() {
+		return echo.NewHTTPError(http.StatusNotFound, models.ErrOIDCNotConfigured.Error())
+	}
+
+	signIn, err := newOIDCSignIn()
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusBadRequest, "user not found")
-		}
+		c.Logger().Error(err)
+		return err
+	}
 
+	authCodeURL, err := app.OIDCService.AuthCodeURL(signIn)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusBadGateway, models.ErrOIDCSignInFailed.Error())
+	}
+
+	if err := app.startOIDCSignIn(c, signIn); err != nil {
 		c.Logger().Error(err)
 		return err
 	}
 
-	password := c.FormValue("password")
-	err = bcrypt.CompareHashAndPassword(user.Password, []byte(password))
+	return c.Redirect(http.StatusSeeOther, authCodeURL)
+}
+
+// HandleOIDCCallback completes the sign in the identity provider redirected back from. The state must be the one of
+// the sign in started in this browser, so nobody can sign the user in with the code of another account.
+func (app *Application) HandleOIDCCallback(c echo.Context) error {
+	signIn := app.oidcSignInFromCookie(c)
+	app.clearOIDCSignIn(c)
+
+	if signIn == nil || subtle.ConstantTimeCompare([]byte(signIn.State), []byte(c.QueryParam("state"))) != 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "the sign in expired, sign in again")
+	}
+
+	if errCode := c.QueryParam("error"); errCode != "" {
+		c.Logger().Warnf("oidc sign in refused: %s %s", errCode, c.QueryParam("error_description"))
+		return echo.NewHTTPError(http.StatusUnauthorized, models.ErrOIDCSignInFailed.Error())
+	}
+
+	user, err := app.OIDCService.SignIn(c.Request().Context(), &models.OIDCSignInRequest{
+		Code:         c.QueryParam("code"),
+		CodeVerifier: signIn.CodeVerifier,
+		Nonce:        signIn.Nonce,
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "wrong password")
+		switch err {
+		case models.ErrOIDCSignInFailed:
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		case models.ErrOIDCEmailMissing, models.ErrOIDCAccountConflict:
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		case models.ErrOIDCNotConfigured:
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+
+		c.Logger().Error(err)
+		return err
 	}
 
-	if err := setSession(c); err != nil {
+	// the identity provider stands for the password, the second factor of the market is still asked for
+	if user.TOTPEnabledAt != nil {
+		if err := app.startSecondFactor(c, user.Username); err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+
+		return c.Redirect(http.StatusSeeOther, "/signin/second-factor")
+	}
+
+	if err := setSession(c, user.Username); err != nil {
 		c.Logger().Error(err)
 		return err
 	}
@@ -188,6 +501,34 @@ func (app *Application) HandleAdminProductOperations(c echo.Context) error {
 	return nil
 }
 
+const inventoryTemplate = "./public/admin_inventory/index.html"
+
+// inventoryPage is the data of the inventory template. The stock of the product looked up is shown with the form to
+// adjust it, which sends back the version it was shown at so the change is refused if the stock moved meanwhile.
+type inventoryPage struct {
+	ProductName string
+	Inventory   *models.InventoryResponse
+	Error       string
+}
+
+func (app *Application) HandleInventoryPage(c echo.Context) error {
+	productName := c.QueryParam("product_name")
+	if productName == "" {
+		return c.Render(http.StatusOK, inventoryTemplate, inventoryPage{})
+	}
+
+	inventory, err := app.InventoryService.Get(&models.GetInventoryRequest{ProductName: productName})
+	if err != nil {
+		if err == models.ErrProductNotFound {
+			return c.Render(http.StatusNotFound, inventoryTemplate, inventoryPage{ProductName: productName, Error: err.Error()})
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	return c.Render(http.StatusOK, inventoryTemplate, inventoryPage{ProductName: productName, Inventory: inventory})
+}
+
 func (app *Application) HandleInventory(c echo.Context) error {
 	productName := c.FormValue("product_name")
 	operation := c.FormValue("operation")
@@ -202,34 +543,81 @@ func (app *Application) HandleInventory(c echo.Context) error {
 		ProductName: productName,
 		Operation:   operation,
 		Quantity:    quantity,
+		UpdatedBy:   sessionUsername(c),
+	}
+
+	if expectedVersion := c.FormValue("expected_version"); expectedVersion != "" {
+		version, err := strconv.Atoi(expectedVersion)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid expected version")
+		}
+		req.ExpectedVersion = &version
 	}
 
-	if err := app.InventoryService.Update(req); err != nil {
+	resp, err := app.InventoryService.Update(req)
+	if err != nil {
 		if err == models.ErrInvalidOperaton || err == models.ErrProductNotFound || err == models.ErrInvalidQuantity {
 			return echo.NewHTTPError(http.StatusBadRequest, err)
 		}
+		if err == models.ErrVersionConflict {
+			return echo.NewHTTPError(http.StatusConflict, err)
+		}
 		c.Logger().Error(err)
 		return err
 	}
 
+	if err := c.JSONPretty(http.StatusOK, resp, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleInventoryLedger(c echo.Context) error {
+	resp, err := app.InventoryService.GetLedger(&models.GetInventoryLedgerRequest{
+		ProductName: c.QueryParam("product_name"),
+	})
+	if err != nil {
+		if err == models.ErrProductNotFound {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, resp, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
 	return nil
 }
 
 func (app *Application) HandleUserOrder(c echo.Context) error {
 	req, err := createOrderReqFromContext(c)
 	if err != nil {
+		if err == models.ErrInvalidOrderLine || err == models.ErrInvalidQuantity {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
 		c.Logger().Error(err)
 		return err
 	}
 
-	if _, err := app.OrderService.Create(req); err != nil {
-		if err == models.ErrProductNotFound || err == models.ErrInvalidQuantity {
+	resp, err := app.OrderService.Create(req)
+	if err != nil {
+		if isOrderRequestError(err) {
 			return echo.NewHTTPError(http.StatusBadRequest, err)
 		}
 		c.Logger().Error(err)
 		return err
 	}
 
+	if err := c.JSONPretty(http.StatusCreated, resp, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
 	return nil
 }
 
@@ -286,6 +674,7 @@ func (app *Application) HandleAdminOrder(c echo.Context) error {
 	req := models.GetOrdersForAdminRequest{
 		Username:    c.FormValue("username"),
 		ProductName: c.FormValue("product_name"),
+		Status:      c.FormValue("status"),
 		SortBy:      c.FormValue("sort"),
 	}
 
@@ -302,3 +691,634 @@ func (app *Application) HandleAdminOrder(c echo.Context) error {
 
 	return nil
 }
+
+func (app *Application) HandleAdminOrderStatus(c echo.Context) error {
+	order, err := app.OrderService.UpdateStatus(&models.UpdateOrderStatusRequest{
+		OrderID: c.FormValue("order_id"),
+		Status:  c.FormValue("status"),
+	})
+	if err != nil {
+		if err == models.ErrOrderNotFound || err == models.ErrInvalidOrderStatus || err == models.ErrInvalidTransition {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, order, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleUserCancelOrder(c echo.Context) error {
+	return app.cancelOrder(c, false)
+}
+
+func (app *Application) HandleAdminCancelOrder(c echo.Context) error {
+	return app.cancelOrder(c, true)
+}
+
+func (app *Application) HandleMyCart(c echo.Context) error {
+	resp, err := app.CartService.Get(sessionUsername(c))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, resp, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleCartOperations(c echo.Context) error {
+	operation := c.Param("operation")
+	if operation != "add" && operation != "update" && operation != "remove" && operation != "checkout" {
+		return echo.NewHTTPError(http.StatusNotFound, "invalid operation")
+	}
+
+	username := sessionUsername(c)
+	productName := c.FormValue("product_name")
+
+	var err error
+	switch operation {
+	case "add", "update":
+		quantity, convErr := strconv.Atoi(c.FormValue("quantity"))
+		if convErr != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, models.ErrInvalidQuantity)
+		}
+
+		if operation == "add" {
+			err = app.CartService.AddItem(&models.AddCartItemRequest{Username: username, ProductName: productName, Quantity: quantity})
+		} else {
+			err = app.CartService.UpdateItem(&models.UpdateCartItemRequest{Username: username, ProductName: productName, Quantity: quantity})
+		}
+	case "remove":
+		err = app.CartService.RemoveItem(&models.RemoveCartItemRequest{Username: username, ProductName: productName})
+	case "checkout":
+		resp, err := app.CartService.Checkout(username)
+		if err != nil {
+			if err == models.ErrCartEmpty || isOrderRequestError(err) {
+				return echo.NewHTTPError(http.StatusBadRequest, err)
+			}
+			c.Logger().Error(err)
+			return err
+		}
+
+		return c.JSONPretty(http.StatusCreated, resp, "    ")
+	}
+
+	if err != nil {
+		if err == models.ErrProductNotFound || err == models.ErrInvalidQuantity || err == models.ErrCartItemNotFound {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	return app.HandleMyCart(c)
+}
+
+func (app *Application) HandlePricingRules(c echo.Context) error {
+	rules, err := app.PricingService.GetRules()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, rules, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandlePricingRuleOperations(c echo.Context) error {
+	operation := c.Param("operation")
+	if operation != "add" && operation != "update" && operation != "remove" {
+		return echo.NewHTTPError(http.StatusNotFound, "invalid operation")
+	}
+
+	var (
+		rule *models.PricingRuleDBModel
+		err  error
+	)
+
+	switch operation {
+	case "add":
+		req, reqErr := createPricingRuleReqFromContext(c)
+		if reqErr != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, reqErr)
+		}
+		rule, err = app.PricingService.CreateRule(req)
+	case "update":
+		req, reqErr := updatePricingRuleReqFromContext(c)
+		if reqErr != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, reqErr)
+		}
+		rule, err = app.PricingService.UpdateRule(req)
+	case "remove":
+		id, convErr := strconv.ParseInt(c.FormValue("id"), 10, 64)
+		if convErr != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, models.ErrPricingRuleNotFound)
+		}
+		err = app.PricingService.DeleteRule(&models.DeletePricingRuleRequest{ID: id})
+	}
+
+	if err != nil {
+		if err == models.ErrPricingRuleNotFound || err == models.ErrInvalidPricingRule {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	if rule == nil {
+		return c.NoContent(http.StatusOK)
+	}
+
+	if err := c.JSONPretty(http.StatusOK, rule, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleReprice(c echo.Context) error {
+	changes, err := app.ProductService.Reprice(&models.RepriceRequest{
+		ProductName: c.FormValue("product_name"),
+		DryRun:      c.FormValue("dry_run") != "",
+	})
+	if err != nil {
+		if err == models.ErrProductNotFound {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, changes, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleAdmins(c echo.Context) error {
+	admins, err := app.AdminService.List()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, admins, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleAdminChanges(c echo.Context) error {
+	changes, err := app.AdminService.GetChanges()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, changes, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleAdminOperations(c echo.Context) error {
+	var err error
+	username := c.FormValue("username")
+
+	switch c.Param("operation") {
+	case "promote":
+		err = app.AdminService.Grant(&models.GrantAdminRequest{
+			Username:  username,
+			GrantedBy: sessionUsername(c),
+		})
+	case "demote":
+		err = app.AdminService.Revoke(&models.RevokeAdminRequest{
+			Username:  username,
+			RevokedBy: sessionUsername(c),
+		})
+	default:
+		return echo.NewHTTPError(http.StatusNotFound, "invalid operation")
+	}
+
+	if err != nil {
+		if err == models.ErrUserNotFound || err == models.ErrAlreadyAdmin || err == models.ErrNotAdmin || err == models.ErrLastAdmin {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	return app.HandleAdmins(c)
+}
+
+func (app *Application) HandleLockouts(c echo.Context) error {
+	lockouts, err := app.UserService.GetLockouts()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, lockouts, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleUserOperations(c echo.Context) error {
+	var err error
+	username := c.FormValue("username")
+
+	switch c.Param("operation") {
+	case "unlock":
+		err = app.UserService.Unlock(&models.UnlockAccountRequest{
+			Username:   username,
+			UnlockedBy: sessionUsername(c),
+		})
+	case "logout":
+		err = app.SessionService.RevokeAll(&models.RevokeAllSessionsRequest{
+			Username:  username,
+			RevokedBy: sessionUsername(c),
+		})
+	default:
+		return echo.NewHTTPError(http.StatusNotFound, "invalid operation")
+	}
+
+	if err != nil {
+		if err == models.ErrAccountNotLocked || err == models.ErrUserNotFound {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	return app.HandleLockouts(c)
+}
+
+func (app *Application) HandleMyTokens(c echo.Context) error {
+	resp, err := app.APITokenService.List(sessionUsername(c))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, resp, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleTokenOperations(c echo.Context) error {
+	switch c.Param("operation") {
+	case "create":
+		req, err := createAPITokenReqFromContext(c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		resp, err := app.APITokenService.Create(req)
+		if err != nil {
+			var fields models.ValidationError
+			if errors.As(err, &fields) || err == models.ErrInvalidScope || err == models.ErrScopeNotGranted {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			c.Logger().Error(err)
+			return err
+		}
+
+		// the token is only ever shown here
+		if err := c.JSONPretty(http.StatusCreated, resp, "    "); err != nil {
+			fmt.Println(err)
+			return err
+		}
+
+		return nil
+	case "revoke":
+		id, err := strconv.ParseInt(c.FormValue("id"), 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		if err := app.APITokenService.Revoke(&models.RevokeAPITokenRequest{
+			ID:       id,
+			Username: sessionUsername(c),
+		}); err != nil {
+			if err == models.ErrAPITokenNotFound {
+				return echo.NewHTTPError(http.StatusBadRequest, err)
+			}
+			c.Logger().Error(err)
+			return err
+		}
+
+		return app.HandleMyTokens(c)
+	default:
+		return echo.NewHTTPError(http.StatusNotFound, "invalid operation")
+	}
+}
+
+func (app *Application) HandleTwoFactorStatus(c echo.Context) error {
+	resp, err := app.TwoFactorService.Status(sessionUsername(c))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, resp, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// HandleTwoFactorQRCode returns the qr code of the secret being enrolled as a png image.
+func (app *Application) HandleTwoFactorQRCode(c echo.Context) error {
+	img, err := app.TwoFactorService.EnrollmentQRCode(sessionUsername(c))
+	if err != nil {
+		if err == models.ErrTOTPAlreadyEnabled || err == models.ErrTOTPNotEnrolled {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "image/png")
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	c.Response().WriteHeader(http.StatusOK)
+	return png.Encode(c.Response(), img)
+}
+
+func (app *Application) HandleTwoFactorOperations(c echo.Context) error {
+	req := &models.VerifyTOTPRequest{
+		Username: sessionUsername(c),
+		Code:     c.FormValue("code"),
+		IP:       c.RealIP(),
+	}
+
+	var (
+		resp interface{}
+		err  error
+	)
+
+	switch c.Param("operation") {
+	case "enroll":
+		resp, err = app.TwoFactorService.Enroll(req.Username)
+	case "enable":
+		// the recovery codes are only ever shown here
+		resp, err = app.TwoFactorService.Enable(req)
+	case "disable":
+		err = app.TwoFactorService.Disable(req)
+		resp = map[string]string{"status": "two-factor authentication disabled"}
+	default:
+		return echo.NewHTTPError(http.StatusNotFound, "invalid operation")
+	}
+
+	if err != nil {
+		switch err {
+		case models.ErrTOTPAlreadyEnabled, models.ErrTOTPNotEnabled, models.ErrTOTPNotEnrolled, models.ErrInvalidTOTPCode:
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		case models.ErrTooManyAttempts:
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, resp, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleMySessions(c echo.Context) error {
+	resp, err := app.SessionService.List(sessionUsername(c), sessionToken(c))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, resp, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleRevokeSession(c echo.Context) error {
+	id, err := strconv.ParseInt(c.FormValue("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := app.SessionService.Revoke(&models.RevokeSessionRequest{
+		ID:       id,
+		Username: sessionUsername(c),
+	}); err != nil {
+		if err == models.ErrSessionNotFound {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	return app.HandleMySessions(c)
+}
+
+func (app *Application) HandleRoles(c echo.Context) error {
+	if err := c.JSONPretty(http.StatusOK, app.RoleService.Roles(), "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleUserRoles(c echo.Context) error {
+	roles, err := app.RoleService.GetUserRoles(c.QueryParam("username"))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, roles, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+func (app *Application) HandleRoleOperations(c echo.Context) error {
+	var err error
+	username := c.FormValue("username")
+	role := c.FormValue("role")
+
+	switch c.Param("operation") {
+	case "assign":
+		err = app.RoleService.Assign(&models.AssignRoleRequest{
+			Username:   username,
+			Role:       role,
+			AssignedBy: sessionUsername(c),
+		})
+	case "unassign":
+		err = app.RoleService.Unassign(&models.UnassignRoleRequest{
+			Username:     username,
+			Role:         role,
+			UnassignedBy: sessionUsername(c),
+		})
+	default:
+		return echo.NewHTTPError(http.StatusNotFound, "invalid operation")
+	}
+
+	if err != nil {
+		if err == models.ErrUserNotFound || err == models.ErrInvalidRole || err == models.ErrRoleAlreadyAssigned || err == models.ErrRoleNotAssigned {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+		c.Logger().Error(err)
+		return err
+	}
+
+	roles, err := app.RoleService.GetUserRoles(username)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	if err := c.JSONPretty(http.StatusOK, roles, "    "); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+const (
+	messageTemplate       = "./public/message/index.html"
+	resetPasswordTemplate = "./public/reset_password/index.html"
+)
+
+type messagePage struct {
+	Title   string
+	Message string
+}
+
+type resetPasswordPage struct {
+	Token  string
+	Errors models.ValidationError
+}
+
+func (app *Application) HandleVerifyEmail(c echo.Context) error {
+	if err := app.AccountService.VerifyEmail(&models.VerifyEmailRequest{
+		Token: c.QueryParam("token"),
+	}); err != nil {
+		if err == models.ErrInvalidToken {
+			return c.Render(http.StatusBadRequest, messageTemplate, messagePage{
+				Title:   "Email not verified",
+				Message: "The link is invalid or expired, you can ask for a new one from your home page.",
+			})
+		}
+
+		c.Logger().Error(err)
+		return err
+	}
+
+	return c.Render(http.StatusOK, messageTemplate, messagePage{
+		Title:   "Email verified",
+		Message: "Thank you, your email is verified.",
+	})
+}
+
+func (app *Application) HandleResendVerification(c echo.Context) error {
+	if err := app.AccountService.SendVerification(sessionUsername(c)); err != nil {
+		if err == models.ErrEmailAlreadyVerified {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		c.Logger().Error(err)
+		return err
+	}
+
+	return c.Render(http.StatusOK, messageTemplate, messagePage{
+		Title:   "Verification sent",
+		Message: "Check your inbox for the verification link.",
+	})
+}
+
+func (app *Application) HandleForgotPassword(c echo.Context) error {
+	if err := app.AccountService.RequestPasswordReset(&models.PasswordResetRequest{
+		Email: c.FormValue("email"),
+	}); err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	return c.Render(http.StatusOK, messageTemplate, messagePage{
+		Title:   "Check your inbox",
+		Message: "If an account uses this email, a link to reset its password is on its way.",
+	})
+}
+
+func (app *Application) HandleResetPasswordPage(c echo.Context) error {
+	return c.Render(http.StatusOK, resetPasswordTemplate, resetPasswordPage{
+		Token: c.QueryParam("token"),
+	})
+}
+
+func (app *Application) HandleResetPassword(c echo.Context) error {
+	req := &models.ConfirmPasswordResetRequest{
+		Token:    c.FormValue("token"),
+		Password: c.FormValue("password"),
+	}
+
+	if err := app.AccountService.ResetPassword(req); err != nil {
+		var fields models.ValidationError
+		if errors.As(err, &fields) {
+			return c.Render(http.StatusBadRequest, resetPasswordTemplate, resetPasswordPage{
+				Token:  req.Token,
+				Errors: fields,
+			})
+		}
+
+		if err == models.ErrInvalidToken {
+			return c.Render(http.StatusBadRequest, messageTemplate, messagePage{
+				Title:   "Password not reset",
+				Message: "The link is invalid or expired, ask for a new one.",
+			})
+		}
+
+		c.Logger().Error(err)
+		return err
+	}
+
+	return c.Render(http.StatusOK, messageTemplate, messagePage{
+		Title:   "Password reset",
+		Message: "You can now log in with your new password.",
+	})
+}
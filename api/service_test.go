@@ -1,14 +1,36 @@
 package api
 
 import (
-	"io/ioutil"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
-	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/NikhilSharmaWe/market/mailer"
+	"github.com/NikhilSharmaWe/market/migrations"
 	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -16,6 +38,8 @@ import (
 var (
 	db  *gorm.DB
 	app *Application
+	// mailbox gets the emails sent by the application
+	mailbox bytes.Buffer
 )
 
 func TestMain(m *testing.M) {
@@ -26,7 +50,13 @@ func TestMain(m *testing.M) {
 		log.Fatal(err)
 	}
 
-	app = NewApplication(db, "5e496d654290c30e962c5f1c81bdd20d69bc1e0c4a13c9cb6beb6db81e5e43bd")
+	app = NewApplication(db, Config{
+		SessionKeys: [][]byte{[]byte("5e496d654290c30e962c5f1c81bdd20d69bc1e0c4a13c9cb6beb6db81e5e43bd"), nil},
+		BaseURL:     "http://market.test",
+		Mailer:      mailer.NewWriterMailer(&mailbox),
+		// the cheapest cost above bcrypt.MinCost keeps the tests fast and leaves room to test the rehash
+		BcryptCost: bcrypt.MinCost + 1,
+	})
 
 	result := m.Run()
 
@@ -45,34 +75,28 @@ func createDB() (*gorm.DB, error) {
 func setupTestingEnvironment(db *gorm.DB) error {
 	log.Println("seeding")
 
-	seedQuery := ""
-
-	for _, file := range []string{"../db.sql"} {
-		filePath, err := filepath.Abs(file)
-		if err != nil {
-			return err
-		}
-
-		seedFile, err := os.Open(filePath)
-		if err != nil {
-			return err
-		}
-
-		content, err := ioutil.ReadAll(seedFile)
-		if err != nil {
-			return err
-		}
-
-		seedQuery += string(content) + "\n"
-
-		seedFile.Close()
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
 	}
 
-	return db.Exec(seedQuery).Error
+	_, err = migrator.Up(0)
+	return err
 }
 
 func cleanupTestingEnvironment(db *gorm.DB) error {
-	return db.Exec("drop table admins, inventory, orders, order_per_product, products, users;").Error
+	all, err := migrations.Load()
+	if err != nil {
+		return err
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = migrator.Down(len(all))
+	return err
 }
 
 func TestProductServices(t *testing.T) {
@@ -108,6 +132,13 @@ func TestProductServices(t *testing.T) {
 		Price:    exampleProductReq.Price,
 	}}, resp)
 
+	resp, err = app.ProductService.Get(&models.GetProductsRequest{ProductName: exampleProductReq.ProductName})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp))
+
+	_, err = app.ProductService.Get(&models.GetProductsRequest{ProductName: "non_existent_product_name"})
+	assert.Equal(t, models.ErrMatchingRecordNotFound, err)
+
 	inventory, err := app.InventoryStore.GetOne(map[string]interface{}{"product_name": exampleProductReq.ProductName})
 	assert.Nil(t, err)
 	assert.Equal(t, models.InventoryDBModel{
@@ -132,11 +163,10 @@ func TestProductServices(t *testing.T) {
 
 	p, err := app.ProductStore.GetOne(map[string]interface{}{"product_name": exampleProductReq.ProductName})
 	assert.Nil(t, err)
-	assert.Equal(t, models.ProductDBModel{
-		ProductName: exampleProductReq.ProductName,
-		Category:    "Steel",
-		Price:       12,
-	}, *p)
+	assert.Equal(t, exampleProductReq.ProductName, p.ProductName)
+	assert.Equal(t, "Steel", p.Category)
+	assert.Equal(t, 12, p.Price)
+	assert.Equal(t, 12, p.BasePrice)
 
 	// testing Delete method
 	err = app.ProductService.Delete(&models.DeleteProductRequest{
@@ -147,6 +177,19 @@ func TestProductServices(t *testing.T) {
 	exists, err := app.ProductStore.IsExists(map[string]interface{}{"product_name": exampleProductReq.ProductName})
 	assert.Nil(t, err)
 	assert.Equal(t, false, exists)
+
+	// a product made again with the same name doesn't inherit the price history of the deleted one, which is kept
+	err = app.ProductService.Create(&exampleProductReq)
+	assert.Nil(t, err)
+
+	history, err := app.ProductService.GetPriceHistory(&models.GetPriceHistoryRequest{ProductName: exampleProductReq.ProductName})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, exampleProductReq.Price, history[0].NewPrice)
+
+	history, err = app.PriceHistoryStore.GetMany(map[string]interface{}{"product_name": exampleProductReq.ProductName})
+	assert.Nil(t, err)
+	assert.Less(t, 1, len(history))
 }
 
 func TestOrderService(t *testing.T) {
@@ -226,6 +269,25 @@ func TestOrderService(t *testing.T) {
 
 	expectedTotalCost := exampleProductOneReq.Price*exampleOrderReqOne.ProductsAndQuantity[exampleProductOneReq.ProductName] + exampleProductTwoReq.Price*exampleOrderReqOne.ProductsAndQuantity[exampleProductTwoReq.ProductName]
 	assert.Equal(t, expectedTotalCost, order1.TotalCost)
+	assert.Equal(t, []models.OrderLineResponse{
+		{ProductName: "Handle", Quantity: 2, UnitPrice: 12, LineTotal: 24},
+		{ProductName: "Wheel", Quantity: 4, UnitPrice: 20, LineTotal: 80},
+	}, order1.Lines)
+
+	_, err = app.OrderService.Create(&models.CreateOrderRequest{Username: "Nikhil"})
+	assert.Equal(t, models.ErrEmptyOrder, err)
+
+	_, err = app.OrderService.Create(&models.CreateOrderRequest{
+		Username: "Nikhil",
+		Lines:    []models.OrderLineRequest{{ProductName: "Handle", Quantity: 1}, {ProductName: "Handle", Quantity: 2}},
+	})
+	assert.Equal(t, models.ErrDuplicateOrderLine, err)
+
+	_, err = app.OrderService.Create(&models.CreateOrderRequest{
+		Username: "Nikhil",
+		Lines:    []models.OrderLineRequest{{ProductName: "Handle", Quantity: 0}},
+	})
+	assert.Equal(t, models.ErrInvalidQuantity, err)
 
 	order2, err := app.OrderService.Create(&exampleOrderReqTwo)
 	assert.Nil(t, err)
@@ -252,6 +314,21 @@ func TestOrderService(t *testing.T) {
 		}
 	}
 
+	// unit prices are the ones at purchase time
+	err = app.ProductService.Update(&models.UpdateProductRequest{ProductName: "Handle", Price: 50})
+	assert.Nil(t, err)
+
+	resp, err = app.OrderService.GetOrdersByUsername(exampleOrderReqOne.Username)
+	assert.Nil(t, err)
+	for _, orderResponse := range resp {
+		assert.Equal(t, 2, len(orderResponse.UnitPrices))
+		for i, productName := range orderResponse.Products {
+			if productName == "Handle" {
+				assert.Equal(t, 12, orderResponse.UnitPrices[i])
+			}
+		}
+	}
+
 	// testing GetOrdersForAdmin method
 	resp, err = app.OrderService.GetOrdersForAdmin(&models.GetOrdersForAdminRequest{
 		Username:    "Rewak",
@@ -264,6 +341,209 @@ func TestOrderService(t *testing.T) {
 			t.Fatal("unexpected response: expected order id:", order3.OrderID)
 		}
 	}
+
+	// testing UpdateStatus method
+	assert.Equal(t, models.OrderStatusPending, order1.Status)
+
+	_, err = app.OrderService.UpdateStatus(&models.UpdateOrderStatusRequest{OrderID: order1.OrderID, Status: "lost"})
+	assert.Equal(t, models.ErrInvalidOrderStatus, err)
+
+	_, err = app.OrderService.UpdateStatus(&models.UpdateOrderStatusRequest{OrderID: "non_existent_order_id", Status: models.OrderStatusPaid})
+	assert.Equal(t, models.ErrOrderNotFound, err)
+
+	_, err = app.OrderService.UpdateStatus(&models.UpdateOrderStatusRequest{OrderID: order1.OrderID, Status: models.OrderStatusShipped})
+	assert.Equal(t, models.ErrInvalidTransition, err)
+
+	updated, err := app.OrderService.UpdateStatus(&models.UpdateOrderStatusRequest{OrderID: order1.OrderID, Status: models.OrderStatusPaid})
+	assert.Nil(t, err)
+	assert.Equal(t, models.OrderStatusPaid, updated.Status)
+
+	_, err = app.OrderService.UpdateStatus(&models.UpdateOrderStatusRequest{OrderID: order2.OrderID, Status: models.OrderStatusCancelled})
+	assert.Equal(t, models.ErrInvalidTransition, err)
+
+	resp, err = app.OrderService.GetOrdersForAdmin(&models.GetOrdersForAdminRequest{Status: models.OrderStatusPaid})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp))
+	for _, orderResponse := range resp {
+		if orderResponse.OrderID != order1.OrderID {
+			t.Fatal("unexpected response: expected order id:", order1.OrderID)
+		}
+	}
+}
+
+func TestConcurrentOrders(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// keep the goroutines below the connection limit of the test database, they queue up on the pool instead. The pool is
+	// shared with the other tests, its limit is put back after this one.
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.SetMaxOpenConns(sqlDB.Stats().MaxOpenConnections)
+	sqlDB.SetMaxOpenConns(20)
+
+	const (
+		stock  = 100
+		orders = 300
+	)
+
+	err = app.UsersStore.Create(models.UserDBModel{Username: "Nikhil", Email: "a@a.com", Password: []byte("123")})
+	assert.Nil(t, err)
+
+	err = app.ProductService.Create(&models.CreateProductRequest{
+		ProductName:     "Handle",
+		Category:        "Wood",
+		Price:           12,
+		InitialQuantity: stock,
+	})
+	assert.Nil(t, err)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+		failures  []error
+	)
+
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := app.OrderService.Create(&models.CreateOrderRequest{
+				Username:            "Nikhil",
+				ProductsAndQuantity: map[string]int{"Handle": 1},
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				succeeded++
+			} else if err != models.ErrInvalidQuantity {
+				failures = append(failures, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Empty(t, failures)
+	assert.Equal(t, stock, succeeded)
+
+	inventory, err := app.InventoryStore.GetOne(map[string]interface{}{"product_name": "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, inventory.QuantityInStock)
+
+	var ordered int64
+	err = db.Table("order_per_product").Select("COALESCE(SUM(quantity), 0)").Scan(&ordered).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(stock), ordered)
+}
+
+func TestCancelOrder(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err := app.UsersStore.Create(models.UserDBModel{Username: "Nikhil", Email: "a@a.com", Password: []byte("123")})
+	assert.Nil(t, err)
+
+	err = app.ProductService.Create(&models.CreateProductRequest{
+		ProductName:     "Handle",
+		Category:        "Wood",
+		Price:           12,
+		InitialQuantity: 20,
+	})
+	assert.Nil(t, err)
+
+	order, err := app.OrderService.Create(&models.CreateOrderRequest{
+		Username:            "Nikhil",
+		ProductsAndQuantity: map[string]int{"Handle": 5},
+	})
+	assert.Nil(t, err)
+
+	// other users can't cancel the order
+	_, err = app.OrderService.Cancel(&models.CancelOrderRequest{OrderID: order.OrderID, CancelledBy: "Rewak"})
+	assert.Equal(t, models.ErrOrderNotFound, err)
+
+	cancellation, err := app.OrderService.Cancel(&models.CancelOrderRequest{
+		OrderID:     order.OrderID,
+		CancelledBy: "Nikhil",
+		Reason:      "changed my mind",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "changed my mind", cancellation.Reason)
+
+	inventory, err := app.InventoryStore.GetOne(map[string]interface{}{"product_name": "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, 20, inventory.QuantityInStock)
+
+	cancelled, err := app.OrderStore.GetOne(map[string]interface{}{"order_id": order.OrderID})
+	assert.Nil(t, err)
+	assert.Equal(t, models.OrderStatusCancelled, cancelled.Status)
+
+	// a cancelled order can't be cancelled twice, not even by an admin
+	_, err = app.OrderService.Cancel(&models.CancelOrderRequest{OrderID: order.OrderID, CancelledBy: "admin", AsAdmin: true})
+	assert.Equal(t, models.ErrInvalidTransition, err)
+
+	// users can only cancel pending orders, admins any
+	order, err = app.OrderService.Create(&models.CreateOrderRequest{
+		Username:            "Nikhil",
+		ProductsAndQuantity: map[string]int{"Handle": 5},
+	})
+	assert.Nil(t, err)
+
+	_, err = app.OrderService.UpdateStatus(&models.UpdateOrderStatusRequest{OrderID: order.OrderID, Status: models.OrderStatusPaid})
+	assert.Nil(t, err)
+
+	_, err = app.OrderService.Cancel(&models.CancelOrderRequest{OrderID: order.OrderID, CancelledBy: "Nikhil"})
+	assert.Equal(t, models.ErrInvalidTransition, err)
+
+	_, err = app.OrderService.Cancel(&models.CancelOrderRequest{OrderID: order.OrderID, CancelledBy: "admin", Reason: "fraud", AsAdmin: true})
+	assert.Nil(t, err)
+
+	inventory, err = app.InventoryStore.GetOne(map[string]interface{}{"product_name": "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, 20, inventory.QuantityInStock)
+
+	// not even admins can cancel a shipped order, its goods are not restocked
+	order, err = app.OrderService.Create(&models.CreateOrderRequest{
+		Username:            "Nikhil",
+		ProductsAndQuantity: map[string]int{"Handle": 5},
+	})
+	assert.Nil(t, err)
+
+	for _, status := range []string{models.OrderStatusPaid, models.OrderStatusShipped} {
+		_, err = app.OrderService.UpdateStatus(&models.UpdateOrderStatusRequest{OrderID: order.OrderID, Status: status})
+		assert.Nil(t, err)
+	}
+
+	_, err = app.OrderService.Cancel(&models.CancelOrderRequest{OrderID: order.OrderID, CancelledBy: "admin", Reason: "lost", AsAdmin: true})
+	assert.Equal(t, models.ErrInvalidTransition, err)
+
+	inventory, err = app.InventoryStore.GetOne(map[string]interface{}{"product_name": "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, 15, inventory.QuantityInStock)
+
+	// three sales and two restocks on top of the initial stock
+	ledger, err := app.InventoryService.GetLedger(&models.GetInventoryLedgerRequest{ProductName: "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(ledger.Movements))
+	assert.Equal(t, true, ledger.Reconciled)
 }
 
 func TestInventoryService(t *testing.T) {
@@ -288,28 +568,1902 @@ func TestInventoryService(t *testing.T) {
 	assert.Nil(t, err)
 
 	// testing Update method
-	err = app.InventoryService.Update(&models.UpdateInventoryRequest{
+	_, err = app.InventoryService.Update(&models.UpdateInventoryRequest{
 		ProductName: exampleProductReq.ProductName,
 		Operation:   "invalid_operation",
 		Quantity:    10,
 	})
 	assert.Equal(t, models.ErrInvalidOperaton, err)
 
-	err = app.InventoryService.Update(&models.UpdateInventoryRequest{
+	_, err = app.InventoryService.Update(&models.UpdateInventoryRequest{
+		ProductName: exampleProductReq.ProductName,
+		Operation:   "add",
+		Quantity:    0,
+	})
+	assert.Equal(t, models.ErrInvalidQuantity, err)
+
+	_, err = app.InventoryService.Update(&models.UpdateInventoryRequest{
 		ProductName: exampleProductReq.ProductName,
 		Operation:   "remove",
 		Quantity:    100,
 	})
 	assert.Equal(t, models.ErrInvalidQuantity, err)
 
-	err = app.InventoryService.Update(&models.UpdateInventoryRequest{
+	updated, err := app.InventoryService.Update(&models.UpdateInventoryRequest{
 		ProductName: exampleProductReq.ProductName,
 		Operation:   "remove",
 		Quantity:    10,
 	})
 	assert.Nil(t, err)
+	assert.Equal(t, models.InventoryResponse{
+		ProductName:     exampleProductReq.ProductName,
+		QuantityInStock: 10,
+		Version:         1,
+	}, *updated)
 
 	inventory, err := app.InventoryStore.GetOne(map[string]interface{}{"product_name": exampleProductReq.ProductName})
 	assert.Nil(t, err)
 	assert.Equal(t, 10, inventory.QuantityInStock)
+
+	// a stale expected version is refused and leaves the stock untouched
+	staleVersion := 0
+	_, err = app.InventoryService.Update(&models.UpdateInventoryRequest{
+		ProductName:     exampleProductReq.ProductName,
+		Operation:       "add",
+		Quantity:        5,
+		ExpectedVersion: &staleVersion,
+	})
+	assert.Equal(t, models.ErrVersionConflict, err)
+
+	updated, err = app.InventoryService.Update(&models.UpdateInventoryRequest{
+		ProductName:     exampleProductReq.ProductName,
+		Operation:       "add",
+		Quantity:        5,
+		ExpectedVersion: &updated.Version,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 15, updated.QuantityInStock)
+	assert.Equal(t, 2, updated.Version)
+
+	// testing Get method
+	_, err = app.InventoryService.Get(&models.GetInventoryRequest{ProductName: "non_existent_product_name"})
+	assert.Equal(t, models.ErrProductNotFound, err)
+
+	inventoryResp, err := app.InventoryService.Get(&models.GetInventoryRequest{ProductName: exampleProductReq.ProductName})
+	assert.Nil(t, err)
+	assert.Equal(t, models.InventoryResponse{
+		ProductName:     exampleProductReq.ProductName,
+		QuantityInStock: 15,
+		Version:         2,
+	}, *inventoryResp)
+
+	// testing GetLedger method
+	ledger, err := app.InventoryService.GetLedger(&models.GetInventoryLedgerRequest{ProductName: exampleProductReq.ProductName})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(ledger.Movements))
+	assert.Equal(t, models.MovementInitialStock, ledger.Movements[0].Reason)
+	assert.Equal(t, models.MovementAdminRemove, ledger.Movements[1].Reason)
+	assert.Equal(t, models.MovementAdminAdd, ledger.Movements[2].Reason)
+	assert.Equal(t, 15, ledger.LedgerTotal)
+	assert.Equal(t, true, ledger.Reconciled)
+
+	// a product made again with the same name doesn't inherit the ledger of the deleted one, which is kept
+	err = app.ProductService.Delete(&models.DeleteProductRequest{ProductName: exampleProductReq.ProductName})
+	assert.Nil(t, err)
+
+	err = app.ProductService.Create(&exampleProductReq)
+	assert.Nil(t, err)
+
+	ledger, err = app.InventoryService.GetLedger(&models.GetInventoryLedgerRequest{ProductName: exampleProductReq.ProductName})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ledger.Movements))
+	assert.Equal(t, 20, ledger.LedgerTotal)
+	assert.Equal(t, true, ledger.Reconciled)
+
+	movements, err := app.InventoryMovementStore.GetMany(map[string]interface{}{"product_name": exampleProductReq.ProductName})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(movements))
+}
+
+// conflictingInventoryStore changes the stock behind the back of the first read, as an order placed meanwhile would, so
+// the update made from that read is refused for its stale version.
+type conflictingInventoryStore struct {
+	store.InventoryStore
+	orders InventoryService
+	reads  int
+}
+
+func (cis *conflictingInventoryStore) GetOne(whereMap map[string]interface{}) (*models.InventoryDBModel, error) {
+	inventory, err := cis.InventoryStore.GetOne(whereMap)
+	if err != nil {
+		return nil, err
+	}
+
+	cis.reads++
+	if cis.reads == 1 {
+		if _, err := cis.orders.Update(&models.UpdateInventoryRequest{
+			ProductName: inventory.ProductName,
+			Operation:   "remove",
+			Quantity:    1,
+			UpdatedBy:   "order",
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return inventory, nil
+}
+
+func TestImport(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// testing Import method
+	var productService ProductService = app.ProductService
+
+	_, err := productService.Import(&models.ImportProductsRequest{
+		Input:      strings.NewReader("name,category,price,quantity\nHandle,Wood,12,20\n"),
+		ImportedBy: "cli",
+	})
+	assert.Equal(t, models.ErrInvalidImportHeader, err)
+
+	products := "product_name,category,price,quantity\nHandle,Wood,12,20\nHammer, Steel, 30, 5\n"
+
+	imported, err := productService.Import(&models.ImportProductsRequest{Input: strings.NewReader(products), ImportedBy: "cli"})
+	assert.Nil(t, err)
+	assert.Equal(t, models.ImportProductsResponse{Created: 2, Skipped: 0}, *imported)
+
+	hammer, err := app.ProductStore.GetOne(map[string]interface{}{"product_name": "Hammer"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Steel", hammer.Category)
+	assert.Equal(t, 30, hammer.Price)
+
+	// importing the file again skips the products that exist
+	imported, err = productService.Import(&models.ImportProductsRequest{
+		Input:      strings.NewReader(products + "Saw,Steel,40,3\n"),
+		ImportedBy: "cli",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, models.ImportProductsResponse{Created: 1, Skipped: 2}, *imported)
+
+	// the errors name their line, the lines before it are imported
+	imported, err = productService.Import(&models.ImportProductsRequest{
+		Input:      strings.NewReader("product_name,category,price,quantity\nNail,Steel,1,100\nScrew,Steel,cheap,100\n"),
+		ImportedBy: "cli",
+	})
+	assert.Equal(t, 1, imported.Created)
+	assert.ErrorIs(t, err, models.ErrInvalidPrice)
+	assert.Equal(t, "line 3: "+models.ErrInvalidPrice.Error(), err.Error())
+
+	_, err = productService.Import(&models.ImportProductsRequest{
+		Input:      strings.NewReader("product_name,category,price,quantity\nBolt,Steel,1,-4\n"),
+		ImportedBy: "cli",
+	})
+	assert.ErrorIs(t, err, models.ErrInvalidQuantity)
+	assert.Equal(t, "line 2: "+models.ErrInvalidQuantity.Error(), err.Error())
+
+	// testing Set method
+	var inventoryService InventoryService = app.InventoryService
+
+	resp, err := inventoryService.Set(&models.SetInventoryRequest{ProductName: "Handle", Quantity: 20, UpdatedBy: "cli"})
+	assert.Nil(t, err)
+	assert.False(t, resp.Changed)
+	assert.Equal(t, 20, resp.QuantityInStock)
+
+	resp, err = inventoryService.Set(&models.SetInventoryRequest{ProductName: "Handle", Quantity: 8, UpdatedBy: "cli"})
+	assert.Nil(t, err)
+	assert.True(t, resp.Changed)
+	assert.Equal(t, 8, resp.QuantityInStock)
+
+	_, err = inventoryService.Set(&models.SetInventoryRequest{ProductName: "Handle", Quantity: -1, UpdatedBy: "cli"})
+	assert.Equal(t, models.ErrInvalidQuantity, err)
+
+	_, err = inventoryService.Set(&models.SetInventoryRequest{ProductName: "Chisel", Quantity: 8, UpdatedBy: "cli"})
+	assert.Equal(t, models.ErrProductNotFound, err)
+
+	// a stock changed between reading and updating it is read again
+	conflicting := &conflictingInventoryStore{InventoryStore: app.InventoryStore, orders: app.InventoryService}
+	inventoryService = NewInventoryService(app.ProductStore, conflicting, app.InventoryMovementStore)
+
+	resp, err = inventoryService.Set(&models.SetInventoryRequest{ProductName: "Handle", Quantity: 15, UpdatedBy: "cli"})
+	assert.Nil(t, err)
+	assert.True(t, resp.Changed)
+	assert.Equal(t, 15, resp.QuantityInStock)
+	assert.Equal(t, 2, conflicting.reads)
+
+	ledger, err := app.InventoryService.GetLedger(&models.GetInventoryLedgerRequest{ProductName: "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, true, ledger.Reconciled)
+}
+
+func TestPricingService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err := app.ProductService.Create(&models.CreateProductRequest{
+		ProductName:     "Handle",
+		Category:        "Wood",
+		Price:           100,
+		InitialQuantity: 20,
+	})
+	assert.Nil(t, err)
+
+	err = app.ProductService.Create(&models.CreateProductRequest{
+		ProductName:     "Wheel",
+		Category:        "Steel",
+		Price:           100,
+		InitialQuantity: 5,
+	})
+	assert.Nil(t, err)
+
+	// testing CreateRule method
+	_, err = app.PricingService.CreateRule(&models.CreatePricingRuleRequest{Name: "bad cap", StockThreshold: 10, SurchargePercent: 10, MaxPricePercent: 50})
+	assert.Equal(t, models.ErrInvalidPricingRule, err)
+
+	lowStock, err := app.PricingService.CreateRule(&models.CreatePricingRuleRequest{
+		Name:             "low stock",
+		StockThreshold:   10,
+		SurchargePercent: 10,
+		Enabled:          true,
+	})
+	assert.Nil(t, err)
+
+	_, err = app.PricingService.CreateRule(&models.CreatePricingRuleRequest{
+		Name:             "scarce wood",
+		Category:         "Wood",
+		StockThreshold:   10,
+		SurchargePercent: 50,
+		MaxPricePercent:  130,
+		Enabled:          true,
+	})
+	assert.Nil(t, err)
+
+	rules, err := app.PricingService.GetRules()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rules))
+
+	// testing Reprice method, a dry run only reports the low stock wheel
+	changes, err := app.ProductService.Reprice(&models.RepriceRequest{DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.PriceChangeResponse{{
+		ProductName:     "Wheel",
+		Category:        "Steel",
+		BasePrice:       100,
+		CurrentPrice:    100,
+		NewPrice:        110,
+		QuantityInStock: 5,
+		Rules:           []string{"low stock"},
+		Applied:         false,
+	}}, changes)
+
+	wheel, err := app.ProductStore.GetOne(map[string]interface{}{"product_name": "Wheel"})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, wheel.Price)
+
+	// stock changes reprice the product, the wood surcharges add up to 60% but are capped at 130%
+	_, err = app.InventoryService.Update(&models.UpdateInventoryRequest{ProductName: "Handle", Operation: "remove", Quantity: 15})
+	assert.Nil(t, err)
+
+	handle, err := app.ProductStore.GetOne(map[string]interface{}{"product_name": "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, 130, handle.Price)
+
+	// further stock changes don't compound the surcharge
+	_, err = app.InventoryService.Update(&models.UpdateInventoryRequest{ProductName: "Handle", Operation: "remove", Quantity: 1})
+	assert.Nil(t, err)
+
+	handle, err = app.ProductStore.GetOne(map[string]interface{}{"product_name": "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, 130, handle.Price)
+
+	// testing GetPriceHistory method
+	history, err := app.ProductService.GetPriceHistory(&models.GetPriceHistoryRequest{ProductName: "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, 0, history[0].OldPrice)
+	assert.Equal(t, 100, history[0].NewPrice)
+	assert.Equal(t, 100, history[1].OldPrice)
+	assert.Equal(t, 130, history[1].NewPrice)
+
+	// testing UpdateRule and DeleteRule methods
+	disabled := false
+	_, err = app.PricingService.UpdateRule(&models.UpdatePricingRuleRequest{ID: lowStock.ID, Enabled: &disabled})
+	assert.Nil(t, err)
+
+	err = app.PricingService.DeleteRule(&models.DeletePricingRuleRequest{ID: lowStock.ID})
+	assert.Nil(t, err)
+
+	err = app.PricingService.DeleteRule(&models.DeletePricingRuleRequest{ID: lowStock.ID})
+	assert.Equal(t, models.ErrPricingRuleNotFound, err)
+}
+
+func TestCartService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err := app.UsersStore.Create(models.UserDBModel{
+		Username: "Nikhil",
+		Email:    "a@a.com",
+		Password: []byte("123"),
+	})
+	assert.Nil(t, err)
+
+	err = app.ProductService.Create(&models.CreateProductRequest{
+		ProductName:     "Handle",
+		Category:        "Wood",
+		Price:           12,
+		InitialQuantity: 20,
+	})
+	assert.Nil(t, err)
+
+	// testing AddItem method
+	err = app.CartService.AddItem(&models.AddCartItemRequest{Username: "Nikhil", ProductName: "non_existent_product_name", Quantity: 1})
+	assert.Equal(t, models.ErrProductNotFound, err)
+
+	err = app.CartService.AddItem(&models.AddCartItemRequest{Username: "Nikhil", ProductName: "Handle", Quantity: 2})
+	assert.Nil(t, err)
+
+	err = app.CartService.AddItem(&models.AddCartItemRequest{Username: "Nikhil", ProductName: "Handle", Quantity: 3})
+	assert.Nil(t, err)
+
+	// testing Get method
+	cart, err := app.CartService.Get("Nikhil")
+	assert.Nil(t, err)
+	assert.Equal(t, models.CartResponse{
+		Items: []models.CartItemResponse{{
+			ProductName:     "Handle",
+			Quantity:        5,
+			UnitPrice:       12,
+			LineTotal:       60,
+			QuantityInStock: 20,
+			Available:       true,
+		}},
+		TotalCost: 60,
+	}, *cart)
+
+	// testing UpdateItem method
+	err = app.CartService.UpdateItem(&models.UpdateCartItemRequest{Username: "Nikhil", ProductName: "Handle", Quantity: 30})
+	assert.Nil(t, err)
+
+	// checkout fails on insufficient stock and leaves the cart untouched
+	_, err = app.CartService.Checkout("Nikhil")
+	assert.Equal(t, models.ErrInvalidQuantity, err)
+
+	cart, err = app.CartService.Get("Nikhil")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cart.Items))
+	assert.Equal(t, false, cart.Items[0].Available)
+
+	// testing Checkout method
+	err = app.CartService.UpdateItem(&models.UpdateCartItemRequest{Username: "Nikhil", ProductName: "Handle", Quantity: 4})
+	assert.Nil(t, err)
+
+	order, err := app.CartService.Checkout("Nikhil")
+	assert.Nil(t, err)
+	assert.Equal(t, 48, order.TotalCost)
+
+	cart, err = app.CartService.Get("Nikhil")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(cart.Items))
+
+	_, err = app.CartService.Checkout("Nikhil")
+	assert.Equal(t, models.ErrCartEmpty, err)
+
+	// testing RemoveItem method
+	err = app.CartService.RemoveItem(&models.RemoveCartItemRequest{Username: "Nikhil", ProductName: "Handle"})
+	assert.Equal(t, models.ErrCartItemNotFound, err)
+
+	// concurrent adds of the same product all count
+	const adds = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, adds)
+
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- app.CartService.AddItem(&models.AddCartItemRequest{Username: "Nikhil", ProductName: "Handle", Quantity: 1})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	item, err := app.CartStore.GetOne(map[string]interface{}{"username": "Nikhil", "product_name": "Handle"})
+	assert.Nil(t, err)
+	assert.Equal(t, adds, item.Quantity)
+}
+
+func TestAdminService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, username := range []string{"alice", "bob"} {
+		err := app.UsersStore.Create(models.UserDBModel{Username: username, Email: username + "@market.test", Password: []byte("hash")})
+		assert.Nil(t, err)
+	}
+
+	// testing Grant method
+	err := app.AdminService.Grant(&models.GrantAdminRequest{Username: "carol", GrantedBy: "cli"})
+	assert.Equal(t, models.ErrUserNotFound, err)
+
+	err = app.AdminService.Grant(&models.GrantAdminRequest{Username: "alice", GrantedBy: "cli"})
+	assert.Nil(t, err)
+
+	err = app.AdminService.Grant(&models.GrantAdminRequest{Username: "alice", GrantedBy: "cli"})
+	assert.Equal(t, models.ErrAlreadyAdmin, err)
+
+	err = app.AdminService.Grant(&models.GrantAdminRequest{Username: "bob", GrantedBy: "alice"})
+	assert.Nil(t, err)
+
+	// testing List method
+	admins, err := app.AdminService.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []models.AdminDBModel{{Username: "alice"}, {Username: "bob"}}, admins)
+
+	// testing Revoke method, the last admin can't remove themselves
+	err = app.AdminService.Revoke(&models.RevokeAdminRequest{Username: "bob", RevokedBy: "bob"})
+	assert.Nil(t, err)
+
+	err = app.AdminService.Revoke(&models.RevokeAdminRequest{Username: "bob", RevokedBy: "alice"})
+	assert.Equal(t, models.ErrNotAdmin, err)
+
+	err = app.AdminService.Revoke(&models.RevokeAdminRequest{Username: "alice", RevokedBy: "alice"})
+	assert.Equal(t, models.ErrLastAdmin, err)
+
+	// testing GetChanges method, refused changes are not recorded
+	changes, err := app.AdminService.GetChanges()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, models.AdminChangeRevoke, changes[2].Action)
+	assert.Equal(t, "bob", changes[2].Username)
+	assert.Equal(t, "bob", changes[2].ChangedBy)
+}
+
+func TestRoleService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, username := range []string{"alice", "bob"} {
+		err := app.UsersStore.Create(models.UserDBModel{Username: username, Email: username + "@market.test", Password: []byte("hash")})
+		assert.Nil(t, err)
+	}
+
+	err := app.AdminService.Grant(&models.GrantAdminRequest{Username: "alice", GrantedBy: "cli"})
+	assert.Nil(t, err)
+
+	// testing Assign method, super admins can't be assigned as a role
+	err = app.RoleService.Assign(&models.AssignRoleRequest{Username: "bob", Role: models.RoleSuperAdmin, AssignedBy: "alice"})
+	assert.Equal(t, models.ErrInvalidRole, err)
+
+	err = app.RoleService.Assign(&models.AssignRoleRequest{Username: "carol", Role: models.RoleOrderViewer, AssignedBy: "alice"})
+	assert.Equal(t, models.ErrUserNotFound, err)
+
+	err = app.RoleService.Assign(&models.AssignRoleRequest{Username: "bob", Role: models.RoleInventoryClerk, AssignedBy: "alice"})
+	assert.Nil(t, err)
+
+	err = app.RoleService.Assign(&models.AssignRoleRequest{Username: "bob", Role: models.RoleInventoryClerk, AssignedBy: "alice"})
+	assert.Equal(t, models.ErrRoleAlreadyAssigned, err)
+
+	// testing GetUserRoles method
+	roles, err := app.RoleService.GetUserRoles("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{models.RoleSuperAdmin}, roles)
+
+	roles, err = app.RoleService.GetUserRoles("bob")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{models.RoleInventoryClerk}, roles)
+
+	// testing HasPermission method
+	for _, tc := range []struct {
+		username   string
+		permission string
+		allowed    bool
+	}{
+		{"alice", models.PermissionAdminsManage, true},
+		{"alice", models.PermissionInventoryWrite, true},
+		{"bob", models.PermissionInventoryWrite, true},
+		{"bob", models.PermissionProductsWrite, false},
+		{"bob", models.PermissionAdminsManage, false},
+		{"carol", models.PermissionDashboard, false},
+	} {
+		allowed, err := app.RoleService.HasPermission(tc.username, tc.permission)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.allowed, allowed, tc.username+" "+tc.permission)
+	}
+
+	// testing Unassign method
+	err = app.RoleService.Unassign(&models.UnassignRoleRequest{Username: "bob", Role: models.RoleInventoryClerk, UnassignedBy: "alice"})
+	assert.Nil(t, err)
+
+	err = app.RoleService.Unassign(&models.UnassignRoleRequest{Username: "bob", Role: models.RoleInventoryClerk, UnassignedBy: "alice"})
+	assert.Equal(t, models.ErrRoleNotAssigned, err)
+
+	allowed, err := app.RoleService.HasPermission("bob", models.PermissionInventoryWrite)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+
+	changes, err := app.AdminService.GetChanges()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, models.RoleInventoryClerk, changes[2].Role)
+}
+
+func TestUserService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// testing validateSignUp, every invalid field is reported at once
+	for _, tc := range []struct {
+		req    models.SignUpRequest
+		fields []string
+	}{
+		{models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "correct horse 1"}, nil},
+		{models.SignUpRequest{}, []string{"email", "password", "username"}},
+		{models.SignUpRequest{Username: "al", Email: "alice@market.test", Password: "correct horse 1"}, []string{"username"}},
+		{models.SignUpRequest{Username: "alice smith", Email: "alice@market.test", Password: "correct horse 1"}, []string{"username"}},
+		{models.SignUpRequest{Username: "alice", Email: "Alice <alice@market.test>", Password: "correct horse 1"}, []string{"email"}},
+		{models.SignUpRequest{Username: "alice", Email: "alice@localhost", Password: "correct horse 1"}, []string{"email"}},
+		{models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "short1"}, []string{"password"}},
+		{models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "onlyletters"}, []string{"password"}},
+		{models.SignUpRequest{Username: "alice2024", Email: "alice@market.test", Password: "ALICE2024"}, []string{"password"}},
+	} {
+		fields := []string{}
+		if err := validateSignUp(&tc.req); err != nil {
+			for field := range err.(models.ValidationError) {
+				fields = append(fields, field)
+			}
+		}
+
+		sort.Strings(fields)
+		if tc.fields == nil {
+			tc.fields = []string{}
+		}
+		assert.Equal(t, tc.fields, fields, tc.req)
+	}
+
+	// testing SignUp method
+	user, err := app.UserService.SignUp(&models.SignUpRequest{Username: "alice", Email: " Alice@Market.test", Password: "correct horse 1"})
+	assert.Nil(t, err)
+	assert.Equal(t, &models.UserResponse{Username: "alice", Email: "alice@market.test"}, user)
+
+	stored, err := app.UsersStore.GetOne(map[string]interface{}{"username": "alice"})
+	assert.Nil(t, err)
+	assert.Nil(t, bcrypt.CompareHashAndPassword(stored.Password, []byte("correct horse 1")))
+
+	_, err = app.UserService.SignUp(&models.SignUpRequest{Username: "alice", Email: "ALICE@market.test", Password: "correct horse 1"})
+	assert.Equal(t, models.ValidationError{"username": "is already taken", "email": "is already registered"}, err)
+
+	// the unique index catches what the checks can't, emails stored before they were lowercased
+	err = app.UsersStore.Create(models.UserDBModel{Username: "bob", Email: "Bob@market.test", Password: []byte("hash")})
+	assert.Nil(t, err)
+
+	_, err = app.UserService.SignUp(&models.SignUpRequest{Username: "bobby", Email: "bob@market.test", Password: "correct horse 1"})
+	assert.Equal(t, models.ValidationError{"email": "is already registered"}, err)
+}
+
+func TestPasswords(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// testing Authenticate method, a hash made with a lower cost is replaced on sign in
+	weak, err := bcrypt.GenerateFromPassword([]byte("correct horse 1"), bcrypt.MinCost)
+	assert.Nil(t, err)
+
+	err = app.UsersStore.Create(models.UserDBModel{Username: "alice", Email: "alice@market.test", Password: weak})
+	assert.Nil(t, err)
+
+	_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "alice", Password: "correct horse 2"})
+	assert.Equal(t, models.ErrInvalidCredentials, err)
+
+	user, err := app.UserService.Authenticate(&models.SignInRequest{Username: "alice", Password: "correct horse 1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", user.Username)
+
+	stored, err := app.UsersStore.GetOne(map[string]interface{}{"username": "alice"})
+	assert.Nil(t, err)
+
+	cost, err := bcrypt.Cost(stored.Password)
+	assert.Nil(t, err)
+	assert.Equal(t, bcrypt.MinCost+1, cost)
+
+	// testing argon2id, the bcrypt hashes keep working but get replaced
+	passwords := NewPasswords(Config{
+		PasswordAlgorithm: PasswordAlgorithmArgon2id,
+		Argon2:            Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+
+	hash, err := passwords.Hash("correct horse 1")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(hash), "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	ok, rehash := passwords.Verify(hash, "correct horse 1")
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _ = passwords.Verify(hash, "correct horse 2")
+	assert.False(t, ok)
+
+	ok, rehash = passwords.Verify(stored.Password, "correct horse 1")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	stronger := NewPasswords(Config{
+		PasswordAlgorithm: PasswordAlgorithmArgon2id,
+		Argon2:            Argon2Params{Memory: 2048, Iterations: 1, Parallelism: 1},
+	})
+
+	ok, rehash = stronger.Verify(hash, "correct horse 1")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	// the parameters left out get their defaults
+	partial := NewPasswords(Config{
+		PasswordAlgorithm: PasswordAlgorithmArgon2id,
+		Argon2:            Argon2Params{Memory: 1024},
+	})
+
+	hash, err = partial.Hash("correct horse 1")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(hash), fmt.Sprintf("$argon2id$v=19$m=1024,t=%d,p=%d$",
+		defaultArgon2Params.Iterations, defaultArgon2Params.Parallelism)))
+
+	// hashes of unknown algorithms never match
+	ok, _ = passwords.Verify([]byte("correct horse 1"), "correct horse 1")
+	assert.False(t, ok)
+}
+
+func TestLoginLockout(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	assert.Equal(t, time.Duration(0), lockoutDuration(4, 5))
+	assert.Equal(t, time.Minute, lockoutDuration(5, 5))
+	assert.Equal(t, 4*time.Minute, lockoutDuration(7, 5))
+	assert.Equal(t, time.Hour, lockoutDuration(50, 5))
+
+	_, err := app.UserService.SignUp(&models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	// unknown users and wrong passwords can't be told apart
+	_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "bob", Password: "correct horse 1", IP: "10.0.0.1"})
+	assert.Equal(t, models.ErrInvalidCredentials, err)
+
+	// a valid sign in forgets the failures of the account
+	for i := 0; i < accountFreeAttempts-1; i++ {
+		_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "alice", Password: "wrong horse 1", IP: "10.0.0.1"})
+		assert.Equal(t, models.ErrInvalidCredentials, err)
+	}
+
+	_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "alice", Password: "correct horse 1", IP: "10.0.0.1"})
+	assert.Nil(t, err)
+
+	// testing the account lockout, even the right password is refused while it lasts
+	for i := 0; i < accountFreeAttempts; i++ {
+		_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "alice", Password: "wrong horse 1", IP: "10.0.0.2"})
+		assert.Equal(t, models.ErrInvalidCredentials, err)
+	}
+
+	_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "alice", Password: "correct horse 1", IP: "10.0.0.3"})
+	assert.Equal(t, models.ErrTooManyAttempts, err)
+
+	// unknown users are locked out the same way
+	for i := 0; i < accountFreeAttempts; i++ {
+		_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "bob", Password: "wrong horse 1"})
+	}
+
+	_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "bob", Password: "wrong horse 1"})
+	assert.Equal(t, models.ErrTooManyAttempts, err)
+
+	// testing GetLockouts and Unlock methods
+	lockouts, err := app.UserService.GetLockouts()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(lockouts))
+	assert.Equal(t, "alice", lockouts[0].Subject)
+	assert.Equal(t, 5, lockouts[0].Failures)
+
+	err = app.UserService.Unlock(&models.UnlockAccountRequest{Username: "alice", UnlockedBy: "admin"})
+	assert.Nil(t, err)
+
+	err = app.UserService.Unlock(&models.UnlockAccountRequest{Username: "alice", UnlockedBy: "admin"})
+	assert.Equal(t, models.ErrAccountNotLocked, err)
+
+	_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "alice", Password: "correct horse 1", IP: "10.0.0.3"})
+	assert.Nil(t, err)
+
+	// expired lockouts let the next attempt through, a failure after them locks for twice as long
+	err = app.LoginFailureStore.Update(map[string]interface{}{"locked_until": time.Now().Add(-time.Second)},
+		map[string]interface{}{"kind": models.LoginFailureAccount, "subject": "bob"})
+	assert.Nil(t, err)
+
+	_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "bob", Password: "wrong horse 1"})
+	assert.Equal(t, models.ErrInvalidCredentials, err)
+
+	bob, err := app.LoginFailureStore.GetOne(map[string]interface{}{"kind": models.LoginFailureAccount, "subject": "bob"})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, bob.Failures)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), *bob.LockedUntil, 5*time.Second)
+
+	// testing the address lockout, spread over many usernames
+	for i := 0; i < ipFreeAttempts; i++ {
+		_, err = app.UserService.Authenticate(&models.SignInRequest{Username: fmt.Sprintf("user%d", i), Password: "wrong horse 1", IP: "10.0.0.4"})
+		assert.Equal(t, models.ErrInvalidCredentials, err)
+	}
+
+	_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "alice", Password: "correct horse 1", IP: "10.0.0.4"})
+	assert.Equal(t, models.ErrTooManyAttempts, err)
+
+	_, err = app.UserService.Authenticate(&models.SignInRequest{Username: "alice", Password: "correct horse 1", IP: "10.0.0.5"})
+	assert.Nil(t, err)
+}
+
+// signInCookie stores a session of the user through the session store and returns its cookie.
+func signInCookie(t *testing.T, store sessions.Store, username, userAgent string) *http.Cookie {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("User-Agent", userAgent)
+	rec := httptest.NewRecorder()
+
+	session, err := store.New(req, sessionCookieName)
+	assert.Nil(t, err)
+
+	session.Values["username"] = username
+	session.Values["authenticated"] = true
+	assert.Nil(t, session.Save(req, rec))
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a session cookie, got %d cookies", len(cookies))
+	}
+
+	return cookies[0]
+}
+
+// loadSession returns the session the cookie is for, as the session middleware would.
+func loadSession(t *testing.T, store sessions.Store, cookie *http.Cookie) *sessions.Session {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+
+	session, err := store.New(req, sessionCookieName)
+	assert.Nil(t, err)
+
+	return session
+}
+
+func TestSessionService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, username := range []string{"alice", "bob"} {
+		_, err := app.UserService.SignUp(&models.SignUpRequest{Username: username, Email: username + "@market.test", Password: "correct horse 1"})
+		assert.Nil(t, err)
+	}
+
+	// testing the session store, the cookie only leads to the stored session
+	laptop := signInCookie(t, app.Sessions, "alice", "laptop")
+	phone := signInCookie(t, app.Sessions, "alice", "phone")
+	assert.True(t, laptop.HttpOnly)
+
+	session := loadSession(t, app.Sessions, laptop)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "alice", session.Values["username"])
+
+	var count int64
+	err := db.Table("sessions").Where("token_hash = ?", session.ID).Count(&count).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+
+	forged := *laptop
+	forged.Value = laptop.Value[:len(laptop.Value)-4] + "AAAA"
+	assert.True(t, loadSession(t, app.Sessions, &forged).IsNew)
+
+	// testing List method
+	list, err := app.SessionService.List("alice", session.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list))
+
+	current := map[string]bool{}
+	ids := map[string]int64{}
+	for _, s := range list {
+		current[s.UserAgent] = s.Current
+		ids[s.UserAgent] = s.ID
+	}
+	assert.Equal(t, map[string]bool{"laptop": true, "phone": false}, current)
+
+	// testing Revoke method, only the sessions of the user can be revoked
+	err = app.SessionService.Revoke(&models.RevokeSessionRequest{ID: ids["phone"], Username: "bob"})
+	assert.Equal(t, models.ErrSessionNotFound, err)
+
+	err = app.SessionService.Revoke(&models.RevokeSessionRequest{ID: ids["phone"], Username: "alice"})
+	assert.Nil(t, err)
+	assert.True(t, loadSession(t, app.Sessions, phone).IsNew)
+	assert.False(t, loadSession(t, app.Sessions, laptop).IsNew)
+
+	// signing out deletes the session
+	session = loadSession(t, app.Sessions, laptop)
+	session.Options.MaxAge = -1
+	assert.Nil(t, session.Save(httptest.NewRequest(http.MethodGet, "/logout", nil), httptest.NewRecorder()))
+	assert.True(t, loadSession(t, app.Sessions, laptop).IsNew)
+
+	// expired sessions are refused
+	expired := signInCookie(t, app.Sessions, "alice", "laptop")
+	err = app.SessionStore.Update(map[string]interface{}{"expires_at": time.Now().Add(-time.Minute)}, map[string]interface{}{"username": "alice"})
+	assert.Nil(t, err)
+	assert.True(t, loadSession(t, app.Sessions, expired).IsNew)
+
+	// testing RevokeAll method
+	err = app.SessionService.RevokeAll(&models.RevokeAllSessionsRequest{Username: "nobody", RevokedBy: "admin"})
+	assert.Equal(t, models.ErrUserNotFound, err)
+
+	first := signInCookie(t, app.Sessions, "alice", "laptop")
+	second := signInCookie(t, app.Sessions, "alice", "phone")
+	other := signInCookie(t, app.Sessions, "bob", "laptop")
+
+	err = app.SessionService.RevokeAll(&models.RevokeAllSessionsRequest{Username: "alice", RevokedBy: "admin"})
+	assert.Nil(t, err)
+	assert.True(t, loadSession(t, app.Sessions, first).IsNew)
+	assert.True(t, loadSession(t, app.Sessions, second).IsNew)
+	assert.False(t, loadSession(t, app.Sessions, other).IsNew)
+
+	list, err = app.SessionService.List("alice", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func TestSessionHardening(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	_, err := app.UserService.SignUp(&models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	// the default cookie attributes
+	cookie := signInCookie(t, app.Sessions, "alice", "laptop")
+	assert.True(t, cookie.HttpOnly)
+	assert.False(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.Equal(t, int(defaultSessionAbsoluteTimeout.Seconds()), cookie.MaxAge)
+
+	// testing the key rotation, the new pair encodes and the old one still decodes
+	rotated := newDBSessionStore(app.SessionStore, echo.ExtractIPDirect(), Config{
+		SessionKeys: [][]byte{
+			[]byte("0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0"), []byte("0123456789abcdef0123456789abcdef"),
+			[]byte("5e496d654290c30e962c5f1c81bdd20d69bc1e0c4a13c9cb6beb6db81e5e43bd"), nil,
+		},
+		SessionIdleTimeout:     time.Hour,
+		SessionAbsoluteTimeout: 2 * time.Hour,
+		SessionCookieSecure:    true,
+		SessionCookieSameSite:  http.SameSiteStrictMode,
+	})
+
+	session := loadSession(t, rotated, cookie)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "alice", session.Values["username"])
+
+	rotatedCookie := signInCookie(t, rotated, "alice", "phone")
+	assert.True(t, rotatedCookie.HttpOnly)
+	assert.True(t, rotatedCookie.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, rotatedCookie.SameSite)
+	assert.Equal(t, 7200, rotatedCookie.MaxAge)
+
+	assert.False(t, loadSession(t, rotated, rotatedCookie).IsNew)
+	assert.True(t, loadSession(t, app.Sessions, rotatedCookie).IsNew)
+
+	row, err := app.SessionStore.GetOne(map[string]interface{}{"user_agent": "phone"})
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), row.ExpiresAt, 5*time.Second)
+
+	// testing the idle timeout, a session unused for longer is signed out
+	err = app.SessionStore.Update(map[string]interface{}{"last_seen_at": time.Now().Add(-90 * time.Minute)}, map[string]interface{}{"username": "alice"})
+	assert.Nil(t, err)
+
+	assert.True(t, loadSession(t, rotated, cookie).IsNew)
+	assert.False(t, loadSession(t, app.Sessions, cookie).IsNew)
+
+	// the sessions being used are touched so they don't go idle
+	row, err = app.SessionStore.GetOne(map[string]interface{}{"user_agent": "laptop"})
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), row.LastSeenAt, 5*time.Second)
+}
+
+func TestCSRF(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// the pages are read from the public directory of the repository
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(".."))
+	defer os.Chdir(wd)
+
+	e := app.Router()
+
+	serve := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the pages put the token of the cookie in their forms
+	rec := serve(http.MethodGet, "/forgot-password", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == csrfFormField {
+			cookie = c
+		}
+	}
+
+	if cookie == nil {
+		t.Fatal("expected a csrf cookie")
+	}
+
+	assert.True(t, cookie.HttpOnly)
+	assert.Contains(t, rec.Body.String(), `<input type="hidden" name="_csrf" value="`+cookie.Value+`">`)
+
+	form := http.Header{echo.HeaderContentType: {echo.MIMEApplicationForm}}
+	withCookie := func(header http.Header, cookies ...*http.Cookie) http.Header {
+		h := header.Clone()
+		for _, c := range cookies {
+			h.Add("Cookie", c.String())
+		}
+		return h
+	}
+
+	// testing the forms
+	rec = serve(http.MethodPost, "/forgot-password", "email=alice%40market.test", withCookie(form, cookie))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(http.MethodPost, "/forgot-password", "email=alice%40market.test&_csrf=forged", withCookie(form, cookie))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serve(http.MethodPost, "/forgot-password", "email=alice%40market.test&_csrf="+cookie.Value, withCookie(form, cookie))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// testing the json api, only the requests with the session cookie are checked
+	jsonHeader := http.Header{echo.HeaderContentType: {echo.MIMEApplicationJSON}}
+	body := `{"email": "alice@market.test"}`
+
+	rec = serve(http.MethodPost, "/api/v1/auth/password-reset", body, jsonHeader)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	_, err = app.UserService.SignUp(&models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "correct horse 1"})
+	assert.Nil(t, err)
+	session := signInCookie(t, app.Sessions, "alice", "laptop")
+
+	rec = serve(http.MethodPost, "/api/v1/auth/verification", "", withCookie(jsonHeader, session, cookie))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(http.MethodGet, "/api/v1/auth/csrf", "", withCookie(jsonHeader, cookie))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"csrf_token": "`+cookie.Value+`"}`, rec.Body.String())
+
+	header := withCookie(jsonHeader, session, cookie)
+	header.Set(echo.HeaderXCSRFToken, cookie.Value)
+	rec = serve(http.MethodPost, "/api/v1/auth/verification", "", header)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+}
+
+func TestAPITokenService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, username := range []string{"alice", "bob"} {
+		err := app.UsersStore.Create(models.UserDBModel{Username: username, Email: username + "@market.test", Password: []byte("hash")})
+		assert.Nil(t, err)
+	}
+
+	err := app.RoleService.Assign(&models.AssignRoleRequest{Username: "bob", Role: models.RoleOrderViewer, AssignedBy: "cli"})
+	assert.Nil(t, err)
+
+	// testing Create method
+	_, err = app.APITokenService.Create(&models.CreateAPITokenRequest{Username: "bob", Name: " ", ExpiresAt: &time.Time{}})
+	assert.Equal(t, models.ValidationError{"name": "is required", "expires_at": "must be in the future"}, err)
+
+	_, err = app.APITokenService.Create(&models.CreateAPITokenRequest{Username: "bob", Name: "erp", Scopes: []string{"orders:everything"}})
+	assert.Equal(t, models.ErrInvalidScope, err)
+
+	_, err = app.APITokenService.Create(&models.CreateAPITokenRequest{Username: "alice", Name: "erp", Scopes: []string{models.PermissionOrdersRead}})
+	assert.Equal(t, models.ErrScopeNotGranted, err)
+
+	created, err := app.APITokenService.Create(&models.CreateAPITokenRequest{
+		Username: "bob",
+		Name:     "erp",
+		Scopes:   []string{models.PermissionOrdersRead, models.PermissionDashboard, models.PermissionOrdersRead},
+	})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(created.Token, apiTokenPrefix))
+	assert.Equal(t, []string{models.PermissionDashboard, models.PermissionOrdersRead}, created.Scopes)
+	assert.Nil(t, created.ExpiresAt)
+
+	var count int64
+	err = db.Table("api_tokens").Where("token_hash = ?", created.Token).Count(&count).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+
+	// testing Authenticate method
+	apiToken, err := app.APITokenService.Authenticate(created.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", apiToken.Username)
+	assert.NotNil(t, apiToken.LastUsedAt)
+
+	_, err = app.APITokenService.Authenticate(created.Token + "x")
+	assert.Equal(t, models.ErrInvalidAPIToken, err)
+
+	_, err = app.APITokenService.Authenticate("not a token")
+	assert.Equal(t, models.ErrInvalidAPIToken, err)
+
+	// testing the bearer authentication, the token acts as its owner within its scopes and needs no csrf token
+	e := app.Router()
+
+	serve := func(method, target, token string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/cart", ""))
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/cart", apiTokenPrefix+"unknown"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/cart", created.Token))
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/v1/tokens", created.Token))
+
+	narrow, err := app.APITokenService.Create(&models.CreateAPITokenRequest{Username: "bob", Name: "cart only"})
+	assert.Nil(t, err)
+
+	// a token without scopes can't manage the account of its owner, nor place orders or change the cart
+	for _, route := range []struct{ method, target string }{
+		{http.MethodPost, "/api/v1/auth/verification"},
+		{http.MethodGet, "/api/v1/sessions"},
+		{http.MethodDelete, "/api/v1/sessions/1"},
+		{http.MethodPost, "/api/v1/orders"},
+		{http.MethodPost, "/api/v1/orders/1/cancel"},
+		{http.MethodPost, "/api/v1/cart/items"},
+		{http.MethodPatch, "/api/v1/cart/items/Handle"},
+		{http.MethodDelete, "/api/v1/cart/items/Handle"},
+		{http.MethodPost, "/api/v1/cart/checkout"},
+	} {
+		assert.Equal(t, http.StatusForbidden, serve(route.method, route.target, narrow.Token), route.method+" "+route.target)
+	}
+
+	// the user scopes need no role
+	shopping, err := app.APITokenService.Create(&models.CreateAPITokenRequest{
+		Username: "alice",
+		Name:     "shopping",
+		Scopes:   []string{models.ScopeOrdersPlace, models.ScopeCartWrite},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{models.ScopeCartWrite, models.ScopeOrdersPlace}, shopping.Scopes)
+	assert.NotEqual(t, http.StatusForbidden, serve(http.MethodPost, "/api/v1/cart/checkout", shopping.Token))
+	assert.NotEqual(t, http.StatusForbidden, serve(http.MethodDelete, "/api/v1/cart/items/Handle", shopping.Token))
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/v1/sessions", shopping.Token))
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/admin/orders", created.Token))
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/v1/admin/orders", narrow.Token))
+
+	// a token loses the permissions its owner loses
+	err = app.RoleService.Unassign(&models.UnassignRoleRequest{Username: "bob", Role: models.RoleOrderViewer, UnassignedBy: "cli"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/v1/admin/orders", created.Token))
+
+	// testing List and Revoke methods
+	list, err := app.APITokenService.List("bob")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "erp", list[0].Name)
+
+	err = app.APITokenService.Revoke(&models.RevokeAPITokenRequest{ID: created.ID, Username: "alice"})
+	assert.Equal(t, models.ErrAPITokenNotFound, err)
+
+	err = app.APITokenService.Revoke(&models.RevokeAPITokenRequest{ID: created.ID, Username: "bob"})
+	assert.Nil(t, err)
+
+	_, err = app.APITokenService.Authenticate(created.Token)
+	assert.Equal(t, models.ErrInvalidAPIToken, err)
+
+	// expired tokens are refused
+	expiresAt := time.Now().Add(time.Hour)
+	expiring, err := app.APITokenService.Create(&models.CreateAPITokenRequest{Username: "bob", Name: "expiring", ExpiresAt: &expiresAt})
+	assert.Nil(t, err)
+
+	err = app.APITokenStore.Update(map[string]interface{}{"expires_at": time.Now().Add(-time.Minute)}, map[string]interface{}{"id": expiring.ID})
+	assert.Nil(t, err)
+
+	_, err = app.APITokenService.Authenticate(expiring.Token)
+	assert.Equal(t, models.ErrInvalidAPIToken, err)
+}
+
+func TestAuthTokenService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	_, err := app.UserService.SignUp(&models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	// testing Issue method with the password grant, checked like the sign in
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: "client_credentials"})
+	assert.Equal(t, models.ErrUnsupportedGrantType, err)
+
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypePassword, Username: "alice", Password: "wrong horse 1", IP: "10.0.0.1"})
+	assert.Equal(t, models.ErrInvalidCredentials, err)
+
+	issued, err := app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypePassword, Username: "alice", Password: "correct horse 1", IP: "10.0.0.1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer", issued.TokenType)
+	assert.Equal(t, int(defaultJWTAccessTTL.Seconds()), issued.ExpiresIn)
+
+	// testing Verify method
+	username, err := app.AuthTokenService.Verify(issued.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", username)
+
+	_, err = app.AuthTokenService.Verify(issued.AccessToken + "x")
+	assert.Equal(t, models.ErrInvalidAccessToken, err)
+
+	// a token signed by another key isn't accepted
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	other := NewAuthTokenService(app.RefreshTokenStore, app.UserService, app.TwoFactorService, []*ecdsa.PrivateKey{otherKey},
+		"http://market.test", time.Minute, time.Hour)
+	forged, err := other.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypePassword, Username: "alice", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	_, err = app.AuthTokenService.Verify(forged.AccessToken)
+	assert.Equal(t, models.ErrInvalidAccessToken, err)
+
+	// testing the key rotation, the tokens signed by the previous key are still accepted and both keys are published
+	current := app.AuthTokenService.JWKS()
+	assert.Equal(t, 1, len(current.Keys))
+
+	rotated := NewAuthTokenService(app.RefreshTokenStore, app.UserService, app.TwoFactorService,
+		[]*ecdsa.PrivateKey{otherKey, app.config.JWTSigningKeys[0]}, "http://market.test", time.Minute, time.Hour)
+
+	username, err = rotated.Verify(issued.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", username)
+
+	jwks := rotated.JWKS()
+	assert.Equal(t, 2, len(jwks.Keys))
+	assert.Equal(t, current.Keys[0], jwks.Keys[1])
+	assert.Equal(t, "ES256", jwks.Keys[0].Alg)
+
+	// testing the refresh_token grant, a refresh token is used once
+	refreshed, err := app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypeRefreshToken, RefreshToken: issued.RefreshToken})
+	assert.Nil(t, err)
+	assert.NotEqual(t, issued.RefreshToken, refreshed.RefreshToken)
+
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypeRefreshToken, RefreshToken: "unknown"})
+	assert.Equal(t, models.ErrInvalidRefreshToken, err)
+
+	// reusing a refresh token revokes its whole chain, the latest token included
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypeRefreshToken, RefreshToken: issued.RefreshToken})
+	assert.Equal(t, models.ErrRefreshTokenReused, err)
+
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypeRefreshToken, RefreshToken: refreshed.RefreshToken})
+	assert.Equal(t, models.ErrInvalidRefreshToken, err)
+
+	// the chain of the other sign in is left alone
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypeRefreshToken, RefreshToken: forged.RefreshToken})
+	assert.Nil(t, err)
+
+	// testing Revoke method
+	second, err := app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypePassword, Username: "alice", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	err = app.AuthTokenService.Revoke(&models.RevokeRefreshTokenRequest{RefreshToken: second.RefreshToken})
+	assert.Nil(t, err)
+
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypeRefreshToken, RefreshToken: second.RefreshToken})
+	assert.Equal(t, models.ErrInvalidRefreshToken, err)
+
+	err = app.AuthTokenService.Revoke(&models.RevokeRefreshTokenRequest{RefreshToken: "unknown"})
+	assert.Nil(t, err)
+
+	// signing out everywhere revokes the refresh tokens too
+	third, err := app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypePassword, Username: "alice", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	err = app.SessionService.RevokeAll(&models.RevokeAllSessionsRequest{Username: "alice", RevokedBy: "alice"})
+	assert.Nil(t, err)
+
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypeRefreshToken, RefreshToken: third.RefreshToken})
+	assert.Equal(t, models.ErrInvalidRefreshToken, err)
+
+	// testing the endpoints, an access token authenticates the api requests like a session
+	e := app.Router()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/token", strings.NewReader("grant_type=password&username=alice&password=correct+horse+1"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get(echo.HeaderCacheControl))
+
+	resp := models.AuthTokenResponse{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/cart", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+resp.AccessToken)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/cart", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+forged.AccessToken)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/auth/jwks", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), current.Keys[0].Kid)
+}
+
+func TestTwoFactorService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	_, err := app.UserService.SignUp(&models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	status, err := app.TwoFactorService.Status("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, models.TwoFactorStatusResponse{}, *status)
+
+	// testing Enroll and Enable methods
+	_, err = app.TwoFactorService.Enable(&models.VerifyTOTPRequest{Username: "alice", Code: "123456"})
+	assert.Equal(t, models.ErrTOTPNotEnrolled, err)
+
+	enrollment, err := app.TwoFactorService.Enroll("alice")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/Market:alice?"))
+
+	qr, err := app.TwoFactorService.EnrollmentQRCode("alice")
+	assert.Nil(t, err)
+	assert.NotNil(t, qr)
+
+	_, err = app.TwoFactorService.Enable(&models.VerifyTOTPRequest{Username: "alice", Code: "not a code"})
+	assert.Equal(t, models.ErrInvalidTOTPCode, err)
+
+	now := time.Now()
+	code, err := totp.GenerateCode(enrollment.Secret, now)
+	assert.Nil(t, err)
+
+	recovery, err := app.TwoFactorService.Enable(&models.VerifyTOTPRequest{Username: "alice", Code: code})
+	assert.Nil(t, err)
+	assert.Equal(t, recoveryCodeCount, len(recovery.RecoveryCodes))
+
+	_, err = app.TwoFactorService.Enroll("alice")
+	assert.Equal(t, models.ErrTOTPAlreadyEnabled, err)
+
+	// testing Verify method, every code is accepted once
+	err = app.TwoFactorService.Verify(&models.VerifyTOTPRequest{Username: "alice", Code: code})
+	assert.Equal(t, models.ErrInvalidTOTPCode, err)
+
+	next, err := totp.GenerateCode(enrollment.Secret, now.Add(totpPeriod*time.Second))
+	assert.Nil(t, err)
+
+	err = app.TwoFactorService.Verify(&models.VerifyTOTPRequest{Username: "alice", Code: next[:3] + " " + next[3:]})
+	assert.Nil(t, err)
+
+	err = app.TwoFactorService.Verify(&models.VerifyTOTPRequest{Username: "alice", Code: strings.ToUpper(recovery.RecoveryCodes[0])})
+	assert.Nil(t, err)
+
+	err = app.TwoFactorService.Verify(&models.VerifyTOTPRequest{Username: "alice", Code: recovery.RecoveryCodes[0]})
+	assert.Equal(t, models.ErrInvalidTOTPCode, err)
+
+	status, err = app.TwoFactorService.Status("alice")
+	assert.Nil(t, err)
+	assert.True(t, status.Enabled)
+	assert.Equal(t, int64(recoveryCodeCount-1), status.RecoveryCodesLeft)
+
+	// the password grant asks for the second factor too
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypePassword, Username: "alice", Password: "correct horse 1"})
+	assert.Equal(t, models.ErrTOTPCodeRequired, err)
+
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{
+		GrantType: models.GrantTypePassword,
+		Username:  "alice",
+		Password:  "correct horse 1",
+		TOTPCode:  recovery.RecoveryCodes[1],
+	})
+	assert.Nil(t, err)
+
+	// testing the lockout of the wrong codes, which an admin can lift
+	for i := 0; i < accountFreeAttempts; i++ {
+		err = app.TwoFactorService.Verify(&models.VerifyTOTPRequest{Username: "alice", Code: "not a code"})
+		assert.Equal(t, models.ErrInvalidTOTPCode, err)
+	}
+
+	err = app.TwoFactorService.Verify(&models.VerifyTOTPRequest{Username: "alice", Code: recovery.RecoveryCodes[2]})
+	assert.Equal(t, models.ErrTooManyAttempts, err)
+
+	err = app.UserService.Unlock(&models.UnlockAccountRequest{Username: "alice", UnlockedBy: "cli"})
+	assert.Nil(t, err)
+
+	// testing the second step of the sign in, the session is only made once the code is checked
+	e := app.Router()
+
+	serve := func(target, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body+"&_csrf=token"))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.AddCookie(&http.Cookie{Name: csrfFormField, Value: "token"})
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cookieNamed := func(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+
+		return nil
+	}
+
+	rec := serve("/", "username=alice&password=correct+horse+1")
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/signin/second-factor", rec.Header().Get(echo.HeaderLocation))
+	assert.Nil(t, cookieNamed(rec, sessionCookieName))
+
+	pending := cookieNamed(rec, secondFactorCookieName)
+	if pending == nil {
+		t.Fatal("expected a second factor cookie")
+	}
+
+	rec = serve("/signin/second-factor", "code="+recovery.RecoveryCodes[2])
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve("/signin/second-factor", "code=not+a+code", pending)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve("/signin/second-factor", "code="+recovery.RecoveryCodes[2], pending)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/user/home/", rec.Header().Get(echo.HeaderLocation))
+	assert.NotNil(t, cookieNamed(rec, sessionCookieName))
+	assert.Equal(t, -1, cookieNamed(rec, secondFactorCookieName).MaxAge)
+
+	// testing Disable method
+	err = app.TwoFactorService.Disable(&models.VerifyTOTPRequest{Username: "alice", Code: recovery.RecoveryCodes[3]})
+	assert.Nil(t, err)
+
+	status, err = app.TwoFactorService.Status("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, models.TwoFactorStatusResponse{}, *status)
+
+	// testing the roles requiring the second factor
+	required := NewTwoFactorService(app.UsersStore, app.RecoveryCodeStore, app.LoginFailureStore, app.RoleService,
+		[]string{models.RoleOrderViewer})
+
+	missing, err := required.EnrollmentRequired("alice")
+	assert.Nil(t, err)
+	assert.False(t, missing)
+
+	err = app.RoleService.Assign(&models.AssignRoleRequest{Username: "alice", Role: models.RoleOrderViewer, AssignedBy: "cli"})
+	assert.Nil(t, err)
+
+	missing, err = required.EnrollmentRequired("alice")
+	assert.Nil(t, err)
+	assert.True(t, missing)
+
+	enrollment, err = required.Enroll("alice")
+	assert.Nil(t, err)
+
+	code, err = totp.GenerateCode(enrollment.Secret, time.Now())
+	assert.Nil(t, err)
+
+	_, err = required.Enable(&models.VerifyTOTPRequest{Username: "alice", Code: code})
+	assert.Nil(t, err)
+
+	missing, err = required.EnrollmentRequired("alice")
+	assert.Nil(t, err)
+	assert.False(t, missing)
+}
+
+// lastMailedToken returns the token of the last link sent by email.
+func lastMailedToken(t *testing.T) string {
+	matches := regexp.MustCompile(`\?token=([A-Za-z0-9_-]+)`).FindAllStringSubmatch(mailbox.String(), -1)
+	if len(matches) == 0 {
+		t.Fatal("no token was mailed")
+	}
+
+	return matches[len(matches)-1][1]
+}
+
+func TestAccountService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	mailbox.Reset()
+
+	_, err := app.UserService.SignUp(&models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	// testing SendVerification and VerifyEmail methods, a new link replaces the previous one
+	err = app.AccountService.SendVerification("alice")
+	assert.Nil(t, err)
+	first := lastMailedToken(t)
+
+	err = app.AccountService.SendVerification("alice")
+	assert.Nil(t, err)
+	second := lastMailedToken(t)
+	assert.Contains(t, mailbox.String(), "To: alice@market.test")
+	assert.Contains(t, mailbox.String(), "http://market.test/verify-email?token="+second)
+
+	err = app.AccountService.VerifyEmail(&models.VerifyEmailRequest{Token: first})
+	assert.Equal(t, models.ErrInvalidToken, err)
+
+	err = app.AccountService.VerifyEmail(&models.VerifyEmailRequest{Token: second})
+	assert.Nil(t, err)
+
+	err = app.AccountService.VerifyEmail(&models.VerifyEmailRequest{Token: second})
+	assert.Equal(t, models.ErrInvalidToken, err)
+
+	user, err := app.UsersStore.GetOne(map[string]interface{}{"username": "alice"})
+	assert.Nil(t, err)
+	assert.NotNil(t, user.EmailVerifiedAt)
+
+	err = app.AccountService.SendVerification("alice")
+	assert.Equal(t, models.ErrEmailAlreadyVerified, err)
+
+	// tokens are only stored hashed
+	var count int64
+	err = db.Table("user_tokens").Where("token_hash = ?", second).Count(&count).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+
+	// testing RequestPasswordReset method, unknown emails are silently ignored
+	mailbox.Reset()
+	err = app.AccountService.RequestPasswordReset(&models.PasswordResetRequest{Email: "nobody@market.test"})
+	assert.Nil(t, err)
+	assert.Equal(t, "", mailbox.String())
+
+	err = app.AccountService.RequestPasswordReset(&models.PasswordResetRequest{Email: "Alice@market.test"})
+	assert.Nil(t, err)
+	reset := lastMailedToken(t)
+
+	// testing ResetPassword method, a weak password doesn't use the token up
+	err = app.AccountService.ResetPassword(&models.ConfirmPasswordResetRequest{Token: reset, Password: "weak"})
+	assert.IsType(t, models.ValidationError{}, err)
+
+	// the reset signs out the sessions and refresh tokens made with the old password
+	cookie := signInCookie(t, app.Sessions, "alice", "laptop")
+	tokens, err := app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypePassword, Username: "alice", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	err = app.AccountService.ResetPassword(&models.ConfirmPasswordResetRequest{Token: reset, Password: "battery staple 2"})
+	assert.Nil(t, err)
+
+	assert.True(t, loadSession(t, app.Sessions, cookie).IsNew)
+
+	_, err = app.AuthTokenService.Issue(&models.AuthTokenRequest{GrantType: models.GrantTypeRefreshToken, RefreshToken: tokens.RefreshToken})
+	assert.Equal(t, models.ErrInvalidRefreshToken, err)
+
+	err = app.AccountService.ResetPassword(&models.ConfirmPasswordResetRequest{Token: reset, Password: "battery staple 3"})
+	assert.Equal(t, models.ErrInvalidToken, err)
+
+	user, err = app.UsersStore.GetOne(map[string]interface{}{"username": "alice"})
+	assert.Nil(t, err)
+	assert.Nil(t, bcrypt.CompareHashAndPassword(user.Password, []byte("battery staple 2")))
+
+	// expired tokens are refused
+	err = app.AccountService.RequestPasswordReset(&models.PasswordResetRequest{Email: "alice@market.test"})
+	assert.Nil(t, err)
+	expired := lastMailedToken(t)
+
+	err = app.UserTokenStore.Update(map[string]interface{}{"expires_at": time.Now().Add(-time.Minute)}, map[string]interface{}{"username": "alice", "used_at": nil})
+	assert.Nil(t, err)
+
+	err = app.AccountService.ResetPassword(&models.ConfirmPasswordResetRequest{Token: expired, Password: "battery staple 4"})
+	assert.Equal(t, models.ErrInvalidToken, err)
+}
+
+// oidcStub is a local openid connect provider, the codes it accepts are registered by the test with the claims of
+// the id token they are exchanged for.
+type oidcStub struct {
+	*httptest.Server
+	key *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]oidcStubCode
+}
+
+type oidcStubCode struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newOIDCStub(t *testing.T) *oidcStub {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &oidcStub{key: key, codes: map[string]oidcStubCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                stub.URL,
+			"authorization_endpoint":                stub.URL + "/authorize",
+			"token_endpoint":                        stub.URL + "/token",
+			"jwks_uri":                              stub.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.JWKSResponse{Keys: []models.JWK{jwk(stub.key)}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, _, ok := r.BasicAuth()
+		if !ok {
+			clientID = r.FormValue("client_id")
+		}
+
+		stub.mu.Lock()
+		code, ok := stub.codes[r.FormValue("code")]
+		delete(stub.codes, r.FormValue("code"))
+		stub.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge || clientID != "market" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   stub.URL,
+			"aud":   "market",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": code.nonce,
+		}
+		for k, v := range code.claims {
+			claims[k] = v
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["kid"] = jwk(stub.key).Kid
+
+		idToken, err := token.SignedString(stub.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+
+	stub.Server = httptest.NewServer(mux)
+	return stub
+}
+
+func (stub *oidcStub) register(code, challenge, nonce string, claims jwt.MapClaims) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	stub.codes[code] = oidcStubCode{challenge: challenge, nonce: nonce, claims: claims}
+}
+
+func TestOIDCService(t *testing.T) {
+	if err := setupTestingEnvironment(db); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := cleanupTestingEnvironment(db); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	stub := newOIDCStub(t)
+	defer stub.Close()
+
+	sso := NewApplication(db, Config{
+		SessionKeys:      [][]byte{[]byte("5e496d654290c30e962c5f1c81bdd20d69bc1e0c4a13c9cb6beb6db81e5e43bd"), nil},
+		BaseURL:          "http://market.test",
+		Mailer:           mailer.NewWriterMailer(&mailbox),
+		BcryptCost:       bcrypt.MinCost + 1,
+		OIDCIssuer:       stub.URL,
+		OIDCClientID:     "market",
+		OIDCClientSecret: "secret",
+		OIDCAdminGroups:  []string{"market-admins"},
+	})
+	e := sso.Router()
+
+	get := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cookieNamed := func(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+
+		return nil
+	}
+
+	// signIn goes through the sign in with the identity provider answering with the claims
+	signIn := func(claims jwt.MapClaims) *httptest.ResponseRecorder {
+		rec := get("/oidc/login")
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+
+		location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+		assert.Nil(t, err)
+		assert.Equal(t, stub.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+
+		query := location.Query()
+		assert.Equal(t, "http://market.test/oidc/callback", query.Get("redirect_uri"))
+		assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+		cookie := cookieNamed(rec, oidcCookieName)
+		assert.NotNil(t, cookie)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+		code := fmt.Sprint(claims["sub"])
+		stub.register(code, query.Get("code_challenge"), query.Get("nonce"), claims)
+
+		return get("/oidc/callback?state="+url.QueryEscape(query.Get("state"))+"&code="+code, cookie)
+	}
+
+	// single sign-on is off without an issuer
+	assert.Equal(t, http.StatusNotFound, func() int {
+		rec := httptest.NewRecorder()
+		app.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+		return rec.Code
+	}())
+
+	rec := get("/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `href="/oidc/login"`)
+
+	// testing the link of an existing user with the same verified email
+	_, err := sso.UserService.SignUp(&models.SignUpRequest{Username: "alice", Email: "alice@market.test", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	err = sso.UsersStore.Update(map[string]interface{}{"email_verified_at": time.Now()}, map[string]interface{}{"username": "alice"})
+	assert.Nil(t, err)
+
+	rec = signIn(jwt.MapClaims{"sub": "1", "email": "Alice@market.test", "email_verified": true})
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/user/home/", rec.Header().Get(echo.HeaderLocation))
+	assert.NotNil(t, cookieNamed(rec, sessionCookieName))
+
+	identity, err := sso.UserIdentityStore.GetOne(map[string]interface{}{"issuer": stub.URL, "subject": "1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", identity.Username)
+
+	// the link is kept when the email changes at the identity provider
+	rec = signIn(jwt.MapClaims{"sub": "1", "email": "alice@elsewhere.test", "email_verified": true})
+	assert.Equal(t, "/user/home/", rec.Header().Get(echo.HeaderLocation))
+
+	// an unverified email on either side links nothing
+	_, err = sso.UserService.SignUp(&models.SignUpRequest{Username: "bob", Email: "bob@market.test", Password: "correct horse 1"})
+	assert.Nil(t, err)
+
+	rec = signIn(jwt.MapClaims{"sub": "2", "email": "bob@market.test", "email_verified": true})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = signIn(jwt.MapClaims{"sub": "2", "email": "bob@market.test", "email_verified": false})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// nor does an email that isn't one
+	rec = signIn(jwt.MapClaims{"sub": "2", "email": "bob", "email_verified": true})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	_, err = sso.UserIdentityStore.GetOne(map[string]interface{}{"issuer": stub.URL, "subject": "2"})
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	// testing the provisioning of new users, the groups make them admins
+	rec = signIn(jwt.MapClaims{"sub": "3", "email": "carol@market.test", "email_verified": true, "preferred_username": "carol", "groups": []string{"market-admins"}})
+	assert.Equal(t, "/user/home/", rec.Header().Get(echo.HeaderLocation))
+
+	carol, err := sso.UsersStore.GetOne(map[string]interface{}{"username": "carol"})
+	assert.Nil(t, err)
+	assert.Equal(t, "carol@market.test", carol.Email)
+	assert.NotNil(t, carol.EmailVerifiedAt)
+
+	isAdmin, err := sso.AdminsStore.IsExists(map[string]interface{}{"username": "carol"})
+	assert.Nil(t, err)
+	assert.True(t, isAdmin)
+
+	// a taken username gets a suffix
+	rec = signIn(jwt.MapClaims{"sub": "4", "email": "dave@market.test", "email_verified": true, "preferred_username": "alice", "groups": "market-admins"})
+	assert.Equal(t, "/user/home/", rec.Header().Get(echo.HeaderLocation))
+
+	identity, err = sso.UserIdentityStore.GetOne(map[string]interface{}{"issuer": stub.URL, "subject": "4"})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(identity.Username, "alice-"))
+
+	// leaving the admin groups revokes the admin, except from the last one
+	rec = signIn(jwt.MapClaims{"sub": "3", "email": "carol@market.test", "email_verified": true, "groups": []string{"staff"}})
+	assert.Equal(t, "/user/home/", rec.Header().Get(echo.HeaderLocation))
+
+	isAdmin, err = sso.AdminsStore.IsExists(map[string]interface{}{"username": "carol"})
+	assert.Nil(t, err)
+	assert.False(t, isAdmin)
+
+	rec = signIn(jwt.MapClaims{"sub": "4", "email": "dave@market.test", "email_verified": true})
+	assert.Equal(t, "/user/home/", rec.Header().Get(echo.HeaderLocation))
+
+	isAdmin, err = sso.AdminsStore.IsExists(map[string]interface{}{"username": identity.Username})
+	assert.Nil(t, err)
+	assert.True(t, isAdmin)
+
+	// the callback needs the state of the sign in started in the browser
+	login := get("/oidc/login")
+	rec = get("/oidc/callback?state=forged&code=1", cookieNamed(login, oidcCookieName))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = get("/oidc/callback?state=forged&code=1")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// the code is only exchanged with the code verifier of the sign in
+	login = get("/oidc/login")
+	location, err := url.Parse(login.Header().Get(echo.HeaderLocation))
+	assert.Nil(t, err)
+
+	stub.register("5", base64.RawURLEncoding.EncodeToString([]byte("another challenge")), location.Query().Get("nonce"), jwt.MapClaims{"sub": "5"})
+	rec = get("/oidc/callback?state="+url.QueryEscape(location.Query().Get("state"))+"&code=5", cookieNamed(login, oidcCookieName))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// the id token must carry the nonce of the sign in
+	login = get("/oidc/login")
+	location, err = url.Parse(login.Header().Get(echo.HeaderLocation))
+	assert.Nil(t, err)
+
+	stub.register("6", location.Query().Get("code_challenge"), "another nonce", jwt.MapClaims{"sub": "6"})
+	rec = get("/oidc/callback?state="+url.QueryEscape(location.Query().Get("state"))+"&code=6", cookieNamed(login, oidcCookieName))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// the users with two-factor authentication still give their code
+	err = sso.UsersStore.Update(map[string]interface{}{"totp_enabled_at": time.Now()}, map[string]interface{}{"username": "alice"})
+	assert.Nil(t, err)
+
+	rec = signIn(jwt.MapClaims{"sub": "1", "email": "alice@market.test", "email_verified": true})
+	assert.Equal(t, "/signin/second-factor", rec.Header().Get(echo.HeaderLocation))
+	assert.NotNil(t, cookieNamed(rec, secondFactorCookieName))
 }
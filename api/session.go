@@ -0,0 +1,260 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	sessionCookieName = "signin"
+
+	defaultSessionIdleTimeout     = 24 * time.Hour
+	defaultSessionAbsoluteTimeout = 30 * 24 * time.Hour
+
+	// last_seen_at is written at most once per sessionTouchInterval instead of at every request
+	sessionTouchInterval = time.Minute
+)
+
+// dbSessionStore is a sessions.Store keeping the sessions in the sessions table. The cookie only holds the signed token
+// of the session, which is stored hashed, so deleting the row signs the browser out whoever holds the cookie.
+type dbSessionStore struct {
+	store.SessionStore
+	codecs          []securecookie.Codec
+	options         *sessions.Options
+	ipExtractor     echo.IPExtractor
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+}
+
+// newDBSessionStore takes the session settings of the config, which NewApplication has filled with the defaults.
+func newDBSessionStore(sessionStore store.SessionStore, ipExtractor echo.IPExtractor, config Config) *dbSessionStore {
+	maxAge := int(config.SessionAbsoluteTimeout.Seconds())
+
+	codecs := securecookie.CodecsFromPairs(config.SessionKeys...)
+	for _, codec := range codecs {
+		// the cookies carry their own timestamp, checked against the absolute timeout as well
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(maxAge)
+		}
+	}
+
+	return &dbSessionStore{
+		SessionStore: sessionStore,
+		codecs:       codecs,
+		options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   maxAge,
+			Secure:   config.SessionCookieSecure,
+			HttpOnly: !config.SessionCookieScriptAccess,
+			SameSite: config.SessionCookieSameSite,
+		},
+		ipExtractor:     ipExtractor,
+		idleTimeout:     config.SessionIdleTimeout,
+		absoluteTimeout: config.SessionAbsoluteTimeout,
+	}
+}
+
+func (ss *dbSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(ss, name)
+}
+
+// New returns the session of the cookie, or an empty one when there is no cookie or its session was revoked, has been
+// idle for too long or has expired.
+func (ss *dbSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(ss, name)
+	options := *ss.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	// a cookie that doesn't decode, like one of the old cookie store, is treated like no cookie
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, ss.codecs...); err != nil {
+		return session, nil
+	}
+
+	row, err := ss.SessionStore.GetOne(map[string]interface{}{"token_hash": hashToken(token)})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return session, nil
+		}
+
+		return session, err
+	}
+
+	now := time.Now()
+	if !now.Before(row.ExpiresAt) || now.Sub(row.LastSeenAt) > ss.idleTimeout {
+		return session, nil
+	}
+
+	if now.Sub(row.LastSeenAt) >= sessionTouchInterval {
+		if err := ss.SessionStore.Update(map[string]interface{}{"last_seen_at": now}, map[string]interface{}{"id": row.ID}); err != nil {
+			return session, err
+		}
+	}
+
+	session.ID = token
+	session.IsNew = false
+	session.Values["username"] = row.Username
+	session.Values["authenticated"] = true
+
+	return session, nil
+}
+
+// Save stores the session signed in during the request and writes its cookie, or deletes the session when its MaxAge
+// is negative. Anonymous sessions are never stored.
+func (ss *dbSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := ss.SessionStore.Delete(map[string]interface{}{"token_hash": hashToken(session.ID)}); err != nil {
+				return err
+			}
+		}
+
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	username, _ := session.Values["username"].(string)
+	if username == "" {
+		return nil
+	}
+
+	if session.ID == "" {
+		token, tokenHash, err := newToken()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := ss.SessionStore.DeleteExpired(username, now, now.Add(-ss.idleTimeout)); err != nil {
+			return err
+		}
+
+		if err := ss.SessionStore.Create(models.SessionDBModel{
+			TokenHash:  tokenHash,
+			Username:   username,
+			UserAgent:  r.UserAgent(),
+			IP:         ss.ipExtractor(r),
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(ss.absoluteTimeout),
+		}); err != nil {
+			return err
+		}
+
+		session.ID = token
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, ss.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+type SessionService interface {
+	List(username, current string) ([]models.SessionResponse, error)
+	Revoke(*models.RevokeSessionRequest) error
+	RevokeAll(*models.RevokeAllSessionsRequest) error
+}
+
+type sessionService struct {
+	store.SessionStore
+	store.RefreshTokenStore
+	store.UsersStore
+	idleTimeout time.Duration
+}
+
+func NewSessionService(sessionStore store.SessionStore, refreshTokenStore store.RefreshTokenStore, usersStore store.UsersStore,
+	idleTimeout time.Duration) SessionService {
+	return &sessionService{
+		SessionStore:      sessionStore,
+		RefreshTokenStore: refreshTokenStore,
+		UsersStore:        usersStore,
+		idleTimeout:       idleTimeout,
+	}
+}
+
+// List returns the live sessions of the user, current is the token of the session the request was made with.
+func (ss *sessionService) List(username, current string) ([]models.SessionResponse, error) {
+	rows, err := ss.SessionStore.GetMany(map[string]interface{}{"username": username})
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	now := time.Now()
+	currentHash := hashToken(current)
+
+	resp := []models.SessionResponse{}
+	for _, row := range rows {
+		if !now.Before(row.ExpiresAt) || now.Sub(row.LastSeenAt) > ss.idleTimeout {
+			continue
+		}
+
+		resp = append(resp, models.SessionResponse{
+			ID:         row.ID,
+			UserAgent:  row.UserAgent,
+			IP:         row.IP,
+			CreatedAt:  row.CreatedAt,
+			LastSeenAt: row.LastSeenAt,
+			ExpiresAt:  row.ExpiresAt,
+			Current:    current != "" && row.TokenHash == currentHash,
+		})
+	}
+
+	return resp, nil
+}
+
+// Revoke signs out one of the sessions of the user.
+func (ss *sessionService) Revoke(req *models.RevokeSessionRequest) error {
+	whereMap := map[string]interface{}{"id": req.ID, "username": req.Username}
+
+	if _, err := ss.SessionStore.GetOne(whereMap); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return models.ErrSessionNotFound
+		}
+
+		return err
+	}
+
+	return ss.SessionStore.Delete(whereMap)
+}
+
+// RevokeAll signs the user out everywhere, the api clients signed in with a password included once their access token
+// expires.
+func (ss *sessionService) RevokeAll(req *models.RevokeAllSessionsRequest) error {
+	exists, err := ss.UsersStore.IsExists(map[string]interface{}{"username": req.Username})
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return models.ErrUserNotFound
+	}
+
+	if err := ss.SessionStore.Delete(map[string]interface{}{"username": req.Username}); err != nil {
+		return err
+	}
+
+	if err := ss.RefreshTokenStore.Delete(map[string]interface{}{"username": req.Username}); err != nil {
+		return err
+	}
+
+	log.Printf("%s signed out everywhere by %s", req.Username, req.RevokedBy)
+	return nil
+}
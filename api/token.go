@@ -0,0 +1,192 @@
+package api
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiTokenPrefix tells the api tokens apart from the other bearer tokens, and makes them easy to spot in leaks
+	apiTokenPrefix = "mkt_"
+
+	maxAPITokenNameLength = 64
+
+	// last_used_at is written at most once per apiTokenTouchInterval instead of at every request
+	apiTokenTouchInterval = time.Minute
+)
+
+type APITokenService interface {
+	Create(*models.CreateAPITokenRequest) (*models.CreateAPITokenResponse, error)
+	List(username string) ([]models.APITokenResponse, error)
+	Revoke(*models.RevokeAPITokenRequest) error
+	Authenticate(token string) (*models.APITokenDBModel, error)
+}
+
+type apiTokenService struct {
+	store.APITokenStore
+	roleService RoleService
+}
+
+func NewAPITokenService(apiTokenStore store.APITokenStore, roleService RoleService) APITokenService {
+	return &apiTokenService{
+		APITokenStore: apiTokenStore,
+		roleService:   roleService,
+	}
+}
+
+// apiTokenScopes returns the permissions the token may use.
+func apiTokenScopes(token *models.APITokenDBModel) []string {
+	return strings.Fields(token.Scopes)
+}
+
+func apiTokenResponse(token *models.APITokenDBModel) models.APITokenResponse {
+	return models.APITokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Scopes:     apiTokenScopes(token),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+// Create makes a token for the user. Its scopes are limited to the user scopes and the permissions the user holds, and
+// they are checked again at every request so a token loses what its owner loses.
+func (ats *apiTokenService) Create(req *models.CreateAPITokenRequest) (*models.CreateAPITokenResponse, error) {
+	req.Name = strings.TrimSpace(req.Name)
+
+	fields := models.ValidationError{}
+	if req.Name == "" {
+		fields["name"] = "is required"
+	} else if utf8.RuneCountInString(req.Name) > maxAPITokenNameLength {
+		fields["name"] = "is too long"
+	}
+
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		fields["expires_at"] = "must be in the future"
+	}
+
+	if len(fields) != 0 {
+		return nil, fields
+	}
+
+	scopes := map[string]bool{}
+	for _, scope := range req.Scopes {
+		if isUserScope(scope) {
+			scopes[scope] = true
+			continue
+		}
+
+		if !isPermission(scope) {
+			return nil, models.ErrInvalidScope
+		}
+
+		granted, err := ats.roleService.HasPermission(req.Username, scope)
+		if err != nil {
+			return nil, err
+		}
+
+		if !granted {
+			return nil, models.ErrScopeNotGranted
+		}
+
+		scopes[scope] = true
+	}
+
+	sorted := []string{}
+	for scope := range scopes {
+		sorted = append(sorted, scope)
+	}
+	sort.Strings(sorted)
+
+	random, _, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	token := apiTokenPrefix + random
+
+	created, err := ats.APITokenStore.Create(models.APITokenDBModel{
+		Username:  req.Username,
+		Name:      req.Name,
+		TokenHash: hashToken(token),
+		Scopes:    strings.Join(sorted, " "),
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CreateAPITokenResponse{
+		APITokenResponse: apiTokenResponse(created),
+		Token:            token,
+	}, nil
+}
+
+func (ats *apiTokenService) List(username string) ([]models.APITokenResponse, error) {
+	tokens, err := ats.APITokenStore.GetMany(map[string]interface{}{"username": username})
+	if err != nil && err != models.ErrMatchingRecordNotFound {
+		return nil, err
+	}
+
+	resp := []models.APITokenResponse{}
+	for i := range tokens {
+		resp = append(resp, apiTokenResponse(&tokens[i]))
+	}
+
+	return resp, nil
+}
+
+// Revoke deletes one of the tokens of the user.
+func (ats *apiTokenService) Revoke(req *models.RevokeAPITokenRequest) error {
+	whereMap := map[string]interface{}{"id": req.ID, "username": req.Username}
+
+	if _, err := ats.APITokenStore.GetOne(whereMap); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return models.ErrAPITokenNotFound
+		}
+
+		return err
+	}
+
+	return ats.APITokenStore.Delete(whereMap)
+}
+
+// Authenticate returns the token unless it doesn't exist or has expired, and records its use.
+func (ats *apiTokenService) Authenticate(token string) (*models.APITokenDBModel, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, models.ErrInvalidAPIToken
+	}
+
+	apiToken, err := ats.APITokenStore.GetOne(map[string]interface{}{"token_hash": hashToken(token)})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrInvalidAPIToken
+		}
+
+		return nil, err
+	}
+
+	now := time.Now()
+	if apiToken.ExpiresAt != nil && !now.Before(*apiToken.ExpiresAt) {
+		return nil, models.ErrInvalidAPIToken
+	}
+
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= apiTokenTouchInterval {
+		if err := ats.APITokenStore.Update(map[string]interface{}{"last_used_at": now}, map[string]interface{}{"id": apiToken.ID}); err != nil {
+			return nil, err
+		}
+
+		apiToken.LastUsedAt = &now
+	}
+
+	return apiToken, nil
+}
@@ -0,0 +1,409 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"image"
+	"strings"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	// totpIssuer names the market in the authenticator apps
+	totpIssuer = "Market"
+
+	// the codes of the previous and the next time step are accepted too, for the clocks running a bit off
+	totpPeriod = 30
+	totpSkew   = 1
+
+	recoveryCodeCount = 10
+
+	// the sign ins wait for their second step in a cookie, it must follow the password within secondFactorTimeout
+	secondFactorCookieName = "signin_second_factor"
+	secondFactorTimeout    = 5 * time.Minute
+)
+
+var (
+	totpOpts = totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+	base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// TwoFactorService manages the totp second factor of the users, which is asked at the sign in once enabled. The
+// roles listed in Config.TOTPRequiredRoles don't grant their permissions until their users enable it.
+type TwoFactorService interface {
+	Status(username string) (*models.TwoFactorStatusResponse, error)
+	Enroll(username string) (*models.TOTPEnrollmentResponse, error)
+	EnrollmentQRCode(username string) (image.Image, error)
+	Enable(*models.VerifyTOTPRequest) (*models.RecoveryCodesResponse, error)
+	Disable(*models.VerifyTOTPRequest) error
+	Verify(*models.VerifyTOTPRequest) error
+	EnrollmentRequired(username string) (bool, error)
+}
+
+type twoFactorService struct {
+	store.UsersStore
+	store.RecoveryCodeStore
+	store.LoginFailureStore
+	roleService   RoleService
+	requiredRoles []string
+}
+
+func NewTwoFactorService(usersStore store.UsersStore, recoveryCodeStore store.RecoveryCodeStore,
+	loginFailureStore store.LoginFailureStore, roleService RoleService, requiredRoles []string) TwoFactorService {
+	return &twoFactorService{
+		UsersStore:        usersStore,
+		RecoveryCodeStore: recoveryCodeStore,
+		LoginFailureStore: loginFailureStore,
+		roleService:       roleService,
+		requiredRoles:     requiredRoles,
+	}
+}
+
+// secondFactorSubjects returns what the wrong codes are counted for, the account apart from its passwords and the
+// address together with its failed sign ins.
+func secondFactorSubjects(req *models.VerifyTOTPRequest) []loginSubject {
+	subjects := []loginSubject{{models.LoginFailureSecondFactor, req.Username, accountFreeAttempts}}
+
+	if req.IP != "" {
+		subjects = append(subjects, loginSubject{models.LoginFailureIP, req.IP, ipFreeAttempts})
+	}
+
+	return subjects
+}
+
+// normalizeCode drops the spaces and dashes the users type or copy along with the codes.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// matchTOTP returns the time step of the code, ok is false when the code is wrong or its step was already used.
+func matchTOTP(user *models.UserDBModel, code string, now time.Time) (int64, bool) {
+	step := now.Unix() / totpPeriod
+
+	for s := step - totpSkew; s <= step+totpSkew; s++ {
+		if s <= user.TOTPLastStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(user.TOTPSecret, time.Unix(s*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+// newRecoveryCodes returns random codes like 4f7kq-2mzxa, 50 bits each.
+func newRecoveryCodes() ([]string, error) {
+	codes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32NoPadding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of the user and returns new ones.
+func replaceRecoveryCodes(recoveryCodeStore store.RecoveryCodeStore, username string) ([]string, error) {
+	if err := recoveryCodeStore.Delete(map[string]interface{}{"username": username}); err != nil {
+		return nil, err
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rows := []models.RecoveryCodeDBModel{}
+	for _, code := range codes {
+		rows = append(rows, models.RecoveryCodeDBModel{
+			Username:  username,
+			CodeHash:  hashToken(normalizeCode(code)),
+			CreatedAt: now,
+		})
+	}
+
+	if err := recoveryCodeStore.Create(rows); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (tfs *twoFactorService) getUser(username string) (*models.UserDBModel, error) {
+	user, err := tfs.UsersStore.GetOne(map[string]interface{}{"username": username})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrUserNotFound
+		}
+
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (tfs *twoFactorService) Status(username string) (*models.TwoFactorStatusResponse, error) {
+	user, err := tfs.getUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	required, err := tfs.required(username)
+	if err != nil {
+		return nil, err
+	}
+
+	left, err := tfs.RecoveryCodeStore.Count(map[string]interface{}{"username": username, "used_at": nil})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorStatusResponse{
+		Enabled:           user.TOTPEnabledAt != nil,
+		Required:          required,
+		RecoveryCodesLeft: left,
+	}, nil
+}
+
+// Enroll makes a new secret for the user, the second factor is enabled once a code of it is given to Enable.
+func (tfs *twoFactorService) Enroll(username string) (*models.TOTPEnrollmentResponse, error) {
+	user, err := tfs.getUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabledAt != nil {
+		return nil, models.ErrTOTPAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: username})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tfs.UsersStore.Update(map[string]interface{}{"totp_secret": key.Secret(), "totp_last_step": 0}, map[string]interface{}{
+		"username":        username,
+		"totp_enabled_at": nil,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &models.TOTPEnrollmentResponse{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+	}, nil
+}
+
+// EnrollmentQRCode returns the qr code of the secret being enrolled, for the authenticator apps to scan.
+func (tfs *twoFactorService) EnrollmentQRCode(username string) (image.Image, error) {
+	user, err := tfs.getUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabledAt != nil {
+		return nil, models.ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := base32NoPadding.DecodeString(user.TOTPSecret)
+	if err != nil || len(secret) == 0 {
+		return nil, models.ErrTOTPNotEnrolled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: username, Secret: secret})
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Image(256, 256)
+}
+
+// Enable turns the second factor on when the code matches the enrolled secret, and returns the recovery codes, which
+// are only ever shown here.
+func (tfs *twoFactorService) Enable(req *models.VerifyTOTPRequest) (*models.RecoveryCodesResponse, error) {
+	var codes []string
+
+	if err := tfs.check(req, func(tx *gorm.DB, user *models.UserDBModel, now time.Time) error {
+		if user.TOTPEnabledAt != nil {
+			return models.ErrTOTPAlreadyEnabled
+		}
+
+		if user.TOTPSecret == "" {
+			return models.ErrTOTPNotEnrolled
+		}
+
+		step, ok := matchTOTP(user, normalizeCode(req.Code), now)
+		if !ok {
+			return models.ErrInvalidTOTPCode
+		}
+
+		if err := store.NewUsersStore(tx).Update(map[string]interface{}{"totp_enabled_at": now, "totp_last_step": step},
+			map[string]interface{}{"username": user.Username}); err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(store.NewRecoveryCodeStore(tx), user.Username)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return &models.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// Disable turns the second factor off, it takes a code like the sign in does.
+func (tfs *twoFactorService) Disable(req *models.VerifyTOTPRequest) error {
+	return tfs.check(req, func(tx *gorm.DB, user *models.UserDBModel, now time.Time) error {
+		if err := tfs.verify(tx, user, req.Code, now); err != nil {
+			return err
+		}
+
+		if err := store.NewUsersStore(tx).Update(map[string]interface{}{"totp_secret": "", "totp_enabled_at": nil, "totp_last_step": 0},
+			map[string]interface{}{"username": user.Username}); err != nil {
+			return err
+		}
+
+		return store.NewRecoveryCodeStore(tx).Delete(map[string]interface{}{"username": user.Username})
+	})
+}
+
+// Verify checks the code of the second step of the sign in, a code of the authenticator app or an unused recovery
+// code. Every code is accepted once, and the wrong ones are refused with models.ErrTooManyAttempts after a while like
+// the wrong passwords.
+func (tfs *twoFactorService) Verify(req *models.VerifyTOTPRequest) error {
+	return tfs.check(req, func(tx *gorm.DB, user *models.UserDBModel, now time.Time) error {
+		return tfs.verify(tx, user, req.Code, now)
+	})
+}
+
+func (tfs *twoFactorService) verify(tx *gorm.DB, user *models.UserDBModel, code string, now time.Time) error {
+	if user.TOTPEnabledAt == nil {
+		return models.ErrTOTPNotEnabled
+	}
+
+	code = normalizeCode(code)
+
+	if step, ok := matchTOTP(user, code, now); ok {
+		return store.NewUsersStore(tx).Update(map[string]interface{}{"totp_last_step": step}, map[string]interface{}{"username": user.Username})
+	}
+
+	recoveryCodeStore := store.NewRecoveryCodeStore(tx)
+	recoveryCode, err := recoveryCodeStore.GetOne(map[string]interface{}{
+		"username":  user.Username,
+		"code_hash": hashToken(code),
+		"used_at":   nil,
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return models.ErrInvalidTOTPCode
+		}
+
+		return err
+	}
+
+	return recoveryCodeStore.Update(map[string]interface{}{"used_at": now}, map[string]interface{}{"id": recoveryCode.ID})
+}
+
+// check runs fn with the user locked, so two requests can't both use the same code, while counting the wrong codes.
+func (tfs *twoFactorService) check(req *models.VerifyTOTPRequest, fn func(tx *gorm.DB, user *models.UserDBModel, now time.Time) error) error {
+	subjects := secondFactorSubjects(req)
+
+	locked, err := lockedOut(tfs.LoginFailureStore, subjects)
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		return models.ErrTooManyAttempts
+	}
+
+	db := tfs.UsersStore.DB()
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		user, err := store.NewUsersStore(tx).GetOneForUpdate(map[string]interface{}{"username": req.Username})
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return models.ErrUserNotFound
+			}
+
+			return err
+		}
+
+		return fn(tx, user, time.Now())
+	})
+	if err == models.ErrInvalidTOTPCode {
+		if err := countFailure(tfs.LoginFailureStore, subjects); err != nil {
+			return err
+		}
+
+		return models.ErrInvalidTOTPCode
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return tfs.LoginFailureStore.Delete(map[string]interface{}{
+		"kind":    models.LoginFailureSecondFactor,
+		"subject": req.Username,
+	})
+}
+
+// required reports whether one of the roles of the user requires the second factor.
+func (tfs *twoFactorService) required(username string) (bool, error) {
+	if len(tfs.requiredRoles) == 0 {
+		return false, nil
+	}
+
+	roles, err := tfs.roleService.GetUserRoles(username)
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		for _, required := range tfs.requiredRoles {
+			if role == required {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// EnrollmentRequired reports whether one of the roles of the user requires the second factor the user hasn't enabled.
+func (tfs *twoFactorService) EnrollmentRequired(username string) (bool, error) {
+	required, err := tfs.required(username)
+	if err != nil || !required {
+		return false, err
+	}
+
+	user, err := tfs.getUser(username)
+	if err != nil {
+		return false, err
+	}
+
+	return user.TOTPEnabledAt == nil, nil
+}
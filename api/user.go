@@ -0,0 +1,176 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"github.com/NikhilSharmaWe/market/store"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+const uniqueViolation = "23505"
+
+type UserService interface {
+	SignUp(*models.SignUpRequest) (*models.UserResponse, error)
+	Authenticate(*models.SignInRequest) (*models.UserDBModel, error)
+	GetLockouts() ([]models.LoginFailureDBModel, error)
+	Unlock(*models.UnlockAccountRequest) error
+}
+
+type userService struct {
+	store.UsersStore
+	store.LoginFailureStore
+	passwords *Passwords
+
+	// decoyHash is verified when the user doesn't exist, see Authenticate
+	decoyOnce sync.Once
+	decoyHash []byte
+}
+
+func NewUserService(usersStore store.UsersStore, loginFailureStore store.LoginFailureStore, passwords *Passwords) UserService {
+	return &userService{
+		UsersStore:        usersStore,
+		LoginFailureStore: loginFailureStore,
+		passwords:         passwords,
+	}
+}
+
+// SignUp validates the request and creates the user. Invalid fields, including a taken username or email, are
+// reported together in a models.ValidationError.
+func (us *userService) SignUp(req *models.SignUpRequest) (*models.UserResponse, error) {
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+
+	fields := models.ValidationError{}
+	if err := validateSignUp(req); err != nil {
+		fields = err.(models.ValidationError)
+	}
+
+	if _, ok := fields["username"]; !ok {
+		exists, err := us.UsersStore.IsExists(map[string]interface{}{"username": req.Username})
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			fields["username"] = "is already taken"
+		}
+	}
+
+	if _, ok := fields["email"]; !ok {
+		exists, err := us.UsersStore.IsExists(map[string]interface{}{"email": req.Email})
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			fields["email"] = "is already registered"
+		}
+	}
+
+	if len(fields) != 0 {
+		return nil, fields
+	}
+
+	hash, err := us.passwords.Hash(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.UsersStore.Create(models.UserDBModel{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: hash,
+	}); err != nil {
+		return nil, signUpConflict(err)
+	}
+
+	return &models.UserResponse{
+		Username: req.Username,
+		Email:    req.Email,
+	}, nil
+}
+
+// signUpConflict turns the unique violation of a concurrent signup that passed the checks above into the same field
+// error the checks would have returned.
+func signUpConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolation {
+		return err
+	}
+
+	if pgErr.ConstraintName == "users_email_idx" {
+		return models.ValidationError{"email": "is already registered"}
+	}
+
+	return models.ValidationError{"username": "is already taken"}
+}
+
+// Authenticate checks the password of the user. Unknown users and wrong passwords both return
+// models.ErrInvalidCredentials after the same amount of work, and count as failures of the username and the ip address
+// of the request, which are refused with models.ErrTooManyAttempts while locked out.
+//
+// A hash made with another algorithm or weaker settings than the configured ones is replaced while the password is at
+// hand, failing to do so doesn't fail the sign in.
+func (us *userService) Authenticate(req *models.SignInRequest) (*models.UserDBModel, error) {
+	locked, err := us.isLockedOut(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if locked {
+		return nil, models.ErrTooManyAttempts
+	}
+
+	user, err := us.UsersStore.GetOne(map[string]interface{}{"username": req.Username})
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+
+		us.passwords.Verify(us.decoy(), req.Password)
+		return nil, us.recordFailure(req)
+	}
+
+	ok, rehash := us.passwords.Verify(user.Password, req.Password)
+	if !ok {
+		return nil, us.recordFailure(req)
+	}
+
+	// only the account is forgiven, a valid sign in doesn't make up for the other usernames tried from the address
+	if err := us.LoginFailureStore.Delete(map[string]interface{}{
+		"kind":    models.LoginFailureAccount,
+		"subject": req.Username,
+	}); err != nil {
+		return nil, err
+	}
+
+	if rehash {
+		if err := us.rehash(user, req.Password); err != nil {
+			log.Printf("rehashing the password of %s: %v", user.Username, err)
+		}
+	}
+
+	return user, nil
+}
+
+func (us *userService) rehash(user *models.UserDBModel, password string) error {
+	hash, err := us.passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	// a password changed meanwhile is left alone
+	if err := us.UsersStore.Update(map[string]interface{}{"password_hash": hash}, map[string]interface{}{
+		"username":      user.Username,
+		"password_hash": user.Password,
+	}); err != nil {
+		return err
+	}
+
+	user.Password = hash
+	return nil
+}
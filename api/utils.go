@@ -1,70 +1,385 @@
 package api
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/NikhilSharmaWe/market/mailer"
 	"github.com/NikhilSharmaWe/market/models"
 	"github.com/NikhilSharmaWe/market/store"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo/v4"
-	uuid "github.com/satori/go.uuid"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+// Config holds the settings of the application, main reads them from the environment.
+type Config struct {
+	// SessionKeys are pairs of a hash key and an encryption key for the session cookies, the encryption key can be nil.
+	// The first pair encodes the new cookies, the next ones still decode the cookies encoded before a key rotation.
+	// Without keys a random pair is made, the sessions are then lost at every restart.
+	SessionKeys [][]byte
+	// SessionIdleTimeout signs out the sessions unused for that long, 24 hours by default. SessionAbsoluteTimeout
+	// signs them out that long after the sign in however used they are, 30 days by default.
+	SessionIdleTimeout     time.Duration
+	SessionAbsoluteTimeout time.Duration
+	// SessionCookieSecure only sends the cookie over https. SessionCookieSameSite defaults to lax.
+	// SessionCookieScriptAccess leaves out HttpOnly so scripts can read the cookie, which they don't need to.
+	SessionCookieSecure       bool
+	SessionCookieSameSite     http.SameSite
+	SessionCookieScriptAccess bool
+	// BaseURL is the address the users reach the market at, for the links sent by email.
+	BaseURL string
+	// Mailer defaults to writing the messages to the log.
+	Mailer mailer.Mailer
+	// PasswordAlgorithm hashes the new passwords, bcrypt by default or argon2id. The hashes of the other algorithm
+	// are still accepted and replaced at the next sign in, like the ones made with weaker settings.
+	PasswordAlgorithm string
+	BcryptCost        int
+	Argon2            Argon2Params
+	// TrustProxy takes the client address from the X-Forwarded-For header, only safe behind a proxy that sets it.
+	// Otherwise it is the address of the connection, so clients can't dodge the sign in lockouts by forging it.
+	TrustProxy bool
+	// JWTSigningKeys are the P-256 keys of the jwt access tokens. The first one signs the new tokens, all of them are
+	// published at /api/v1/auth/jwks and verify the tokens, so a new key is added first and the old one dropped once
+	// its tokens expired. Without keys a random one is made, the access tokens are then lost at every restart.
+	JWTSigningKeys []*ecdsa.PrivateKey
+	// JWTAccessTTL is the lifetime of the access tokens, 15 minutes by default. JWTRefreshTTL is the lifetime of the
+	// refresh tokens, 30 days by default, renewed at every refresh.
+	JWTAccessTTL  time.Duration
+	JWTRefreshTTL time.Duration
+	// TOTPRequiredRoles are the roles that don't grant their permissions until their users enable two-factor
+	// authentication, which stays optional for the others.
+	TOTPRequiredRoles []string
+	// OIDCIssuer is the address of the openid connect identity provider the users can sign in with, single sign-on is
+	// off without it. The client is registered there with BaseURL/oidc/callback as its redirect address.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	// OIDCScopes are asked for at the sign in, openid, profile and email by default.
+	OIDCScopes []string
+	// OIDCAdminGroups makes the members of these groups admins and the others not, the groups are read from the
+	// OIDCGroupsClaim of the id token, groups by default. Without admin groups the admins are managed in the market.
+	OIDCGroupsClaim string
+	OIDCAdminGroups []string
+}
+
+// newIPExtractor returns how the address of the clients is read, see Config.TrustProxy.
+func newIPExtractor(config Config) echo.IPExtractor {
+	if config.TrustProxy {
+		return echo.ExtractIPFromXFFHeader()
+	}
+
+	return echo.ExtractIPDirect()
+}
+
 type Application struct {
-	CookieStore *sessions.CookieStore
+	config   Config
+	Sessions sessions.Store
+	// secondFactorCodecs sign the cookie of the sign ins waiting for their second factor
+	secondFactorCodecs []securecookie.Codec
+	// oidcCodecs sign the cookie of the sign ins with the identity provider
+	oidcCodecs []securecookie.Codec
 	ProductService
 	InventoryService
 	OrderService
+	CartService
+	PricingService
+	AdminService
+	RoleService
+	UserService
+	AccountService
+	SessionService
+	APITokenService
+	AuthTokenService
+	TwoFactorService
+	OIDCService
 	store.UsersStore
 	store.InventoryStore
 	store.OrderStore
 	store.ProductStore
 	store.OrderPerProductStore
 	store.AdminsStore
+	store.CartStore
+	store.OrderCancellationStore
+	store.InventoryMovementStore
+	store.PricingRuleStore
+	store.PriceHistoryStore
+	store.AdminChangeStore
+	store.UserRoleStore
+	store.UserTokenStore
+	store.LoginFailureStore
+	store.SessionStore
+	store.APITokenStore
+	store.RefreshTokenStore
+	store.RecoveryCodeStore
+	store.UserIdentityStore
 }
 
-func NewApplication(db *gorm.DB, sessionSecretKey string) *Application {
+func NewApplication(db *gorm.DB, config Config) *Application {
+	if config.Mailer == nil {
+		config.Mailer = mailer.NewWriterMailer(log.Writer())
+	}
+
+	if len(config.SessionKeys) == 0 {
+		log.Print("no session keys configured, the sessions won't survive a restart")
+		config.SessionKeys = [][]byte{securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32)}
+	}
+
+	if config.SessionIdleTimeout == 0 {
+		config.SessionIdleTimeout = defaultSessionIdleTimeout
+	}
+
+	if config.SessionAbsoluteTimeout == 0 {
+		config.SessionAbsoluteTimeout = defaultSessionAbsoluteTimeout
+	}
+
+	if config.SessionCookieSameSite == 0 {
+		config.SessionCookieSameSite = http.SameSiteLaxMode
+	}
+
+	if len(config.JWTSigningKeys) == 0 {
+		log.Print("no jwt signing keys configured, the access tokens won't survive a restart")
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		config.JWTSigningKeys = []*ecdsa.PrivateKey{key}
+	}
+
+	if config.JWTAccessTTL == 0 {
+		config.JWTAccessTTL = defaultJWTAccessTTL
+	}
+
+	if config.JWTRefreshTTL == 0 {
+		config.JWTRefreshTTL = defaultJWTRefreshTTL
+	}
+
+	for _, role := range config.TOTPRequiredRoles {
+		if _, ok := rolePermissions[role]; !ok {
+			log.Fatalf("unknown role %s requiring two-factor authentication", role)
+		}
+	}
+
+	if len(config.OIDCScopes) == 0 {
+		config.OIDCScopes = defaultOIDCScopes
+	}
+
+	if config.OIDCGroupsClaim == "" {
+		config.OIDCGroupsClaim = defaultOIDCGroupsClaim
+	}
+
 	userStore := store.NewUsersStore(db)
 	inventoryStore := store.NewInventoryStore(db)
 	orderStore := store.NewOrdersStore(db)
 	orderPerProductStore := store.NewOrderPerProductStore(db)
 	productStore := store.NewProductsStore(db)
 	adminStore := store.NewAdminsStore(db)
-
-	productService := NewProductService(productStore, inventoryStore)
-	inventoryService := NewInventoryService(productStore, inventoryStore)
-	orderService := NewOrderService(orderStore, orderPerProductStore, productStore, inventoryStore)
+	cartStore := store.NewCartStore(db)
+	orderCancellationStore := store.NewOrderCancellationStore(db)
+	inventoryMovementStore := store.NewInventoryMovementStore(db)
+	pricingRuleStore := store.NewPricingRuleStore(db)
+	priceHistoryStore := store.NewPriceHistoryStore(db)
+	adminChangeStore := store.NewAdminChangeStore(db)
+	userRoleStore := store.NewUserRoleStore(db)
+	userTokenStore := store.NewUserTokenStore(db)
+	loginFailureStore := store.NewLoginFailureStore(db)
+	sessionStore := store.NewSessionStore(db)
+	apiTokenStore := store.NewAPITokenStore(db)
+	refreshTokenStore := store.NewRefreshTokenStore(db)
+	recoveryCodeStore := store.NewRecoveryCodeStore(db)
+	userIdentityStore := store.NewUserIdentityStore(db)
+
+	productService := NewProductService(productStore, inventoryStore, priceHistoryStore)
+	inventoryService := NewInventoryService(productStore, inventoryStore, inventoryMovementStore)
+	orderService := NewOrderService(orderStore, orderPerProductStore, productStore, inventoryStore, orderCancellationStore)
+	cartService := NewCartService(cartStore, productStore, inventoryStore)
+	pricingService := NewPricingService(pricingRuleStore)
+	adminService := NewAdminService(adminStore, adminChangeStore)
+	roleService := NewRoleService(userRoleStore, adminStore)
+	passwords := NewPasswords(config)
+	userService := NewUserService(userStore, loginFailureStore, passwords)
+	accountService := NewAccountService(userStore, userTokenStore, passwords, config.Mailer, config.BaseURL)
+	apiTokenService := NewAPITokenService(apiTokenStore, roleService)
+	twoFactorService := NewTwoFactorService(userStore, recoveryCodeStore, loginFailureStore, roleService, config.TOTPRequiredRoles)
+	authTokenService := NewAuthTokenService(refreshTokenStore, userService, twoFactorService, config.JWTSigningKeys,
+		config.BaseURL, config.JWTAccessTTL, config.JWTRefreshTTL)
+	sessionService := NewSessionService(sessionStore, refreshTokenStore, userStore, config.SessionIdleTimeout)
+	oidcService := NewOIDCService(userStore, userIdentityStore, adminStore, adminService, passwords, config)
 
 	return &Application{
-		CookieStore:      sessions.NewCookieStore([]byte(sessionSecretKey)),
-		UsersStore:       userStore,
-		InventoryStore:   inventoryStore,
-		OrderStore:       orderStore,
-		ProductStore:     productStore,
-		ProductService:   productService,
-		InventoryService: inventoryService,
-		OrderService:     orderService,
-		AdminsStore:      adminStore,
+		config:                 config,
+		Sessions:               newDBSessionStore(sessionStore, newIPExtractor(config), config),
+		secondFactorCodecs:     cookieCodecs(config.SessionKeys, secondFactorTimeout),
+		oidcCodecs:             cookieCodecs(config.SessionKeys, oidcSignInTimeout),
+		UsersStore:             userStore,
+		InventoryStore:         inventoryStore,
+		OrderStore:             orderStore,
+		ProductStore:           productStore,
+		ProductService:         productService,
+		InventoryService:       inventoryService,
+		OrderService:           orderService,
+		AdminsStore:            adminStore,
+		CartService:            cartService,
+		CartStore:              cartStore,
+		OrderCancellationStore: orderCancellationStore,
+		InventoryMovementStore: inventoryMovementStore,
+		PricingService:         pricingService,
+		PricingRuleStore:       pricingRuleStore,
+		PriceHistoryStore:      priceHistoryStore,
+		AdminService:           adminService,
+		AdminChangeStore:       adminChangeStore,
+		RoleService:            roleService,
+		UserRoleStore:          userRoleStore,
+		UserService:            userService,
+		AccountService:         accountService,
+		UserTokenStore:         userTokenStore,
+		LoginFailureStore:      loginFailureStore,
+		SessionService:         sessionService,
+		SessionStore:           sessionStore,
+		APITokenService:        apiTokenService,
+		APITokenStore:          apiTokenStore,
+		AuthTokenService:       authTokenService,
+		RefreshTokenStore:      refreshTokenStore,
+		TwoFactorService:       twoFactorService,
+		RecoveryCodeStore:      recoveryCodeStore,
+		OIDCService:            oidcService,
+		UserIdentityStore:      userIdentityStore,
 	}
 }
 
-func setSession(c echo.Context) error {
+// cookieCodecs returns the codecs of a signed cookie made with the session keys, which it is valid for maxAge.
+func cookieCodecs(keys [][]byte, maxAge time.Duration) []securecookie.Codec {
+	codecs := securecookie.CodecsFromPairs(keys...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(int(maxAge.Seconds()))
+		}
+	}
+
+	return codecs
+}
+
+func setSession(c echo.Context, username string) error {
 	session := c.Get("session").(*sessions.Session)
-	session.ID = uuid.NewV4().String()
-	session.Values["username"] = c.FormValue("username")
+	// every sign in starts a new session, a token planted in the browser before it is never signed in
+	session.ID = ""
+	session.Values["username"] = username
 	session.Values["authenticated"] = true
 	return session.Save(c.Request(), c.Response())
 }
 
+// startSecondFactor remembers in a short lived signed cookie that the user gave the right password, the session is
+// only made once the second factor is checked too.
+func (app *Application) startSecondFactor(c echo.Context, username string) error {
+	encoded, err := securecookie.EncodeMulti(secondFactorCookieName, username, app.secondFactorCodecs...)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(app.secondFactorCookie(encoded, int(secondFactorTimeout.Seconds())))
+	return nil
+}
+
+// secondFactorUsername returns the user whose sign in waits for the second factor, empty when there is none or it
+// took too long.
+func (app *Application) secondFactorUsername(c echo.Context) string {
+	cookie, err := c.Cookie(secondFactorCookieName)
+	if err != nil {
+		return ""
+	}
+
+	var username string
+	if err := securecookie.DecodeMulti(secondFactorCookieName, cookie.Value, &username, app.secondFactorCodecs...); err != nil {
+		return ""
+	}
+
+	return username
+}
+
+func (app *Application) clearSecondFactor(c echo.Context) {
+	c.SetCookie(app.secondFactorCookie("", -1))
+}
+
+func (app *Application) secondFactorCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     secondFactorCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   app.config.SessionCookieSecure,
+		HttpOnly: true,
+		SameSite: app.config.SessionCookieSameSite,
+	}
+}
+
+// startOIDCSignIn remembers the state, nonce and code verifier of the sign in with the identity provider until it
+// comes back. The cookie is always lax, the identity provider redirects back from another site.
+func (app *Application) startOIDCSignIn(c echo.Context, signIn *oidcSignIn) error {
+	encoded, err := securecookie.EncodeMulti(oidcCookieName, signIn, app.oidcCodecs...)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(app.oidcCookie(encoded, int(oidcSignInTimeout.Seconds())))
+	return nil
+}
+
+// oidcSignInFromCookie returns the sign in started in this browser, nil when there is none or it took too long.
+func (app *Application) oidcSignInFromCookie(c echo.Context) *oidcSignIn {
+	cookie, err := c.Cookie(oidcCookieName)
+	if err != nil {
+		return nil
+	}
+
+	signIn := &oidcSignIn{}
+	if err := securecookie.DecodeMulti(oidcCookieName, cookie.Value, signIn, app.oidcCodecs...); err != nil {
+		return nil
+	}
+
+	return signIn
+}
+
+func (app *Application) clearOIDCSignIn(c echo.Context) {
+	c.SetCookie(app.oidcCookie("", -1))
+}
+
+func (app *Application) oidcCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    value,
+		Path:     "/oidc/",
+		MaxAge:   maxAge,
+		Secure:   app.config.SessionCookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
 func clearSessionHandler(c echo.Context) error {
 	session := c.Get("session").(*sessions.Session)
 	session.Options.MaxAge = -1
 	return session.Save(c.Request(), c.Response())
 }
 
+// sessionToken returns the token of the session of the request, empty for anonymous users.
+func sessionToken(c echo.Context) string {
+	return c.Get("session").(*sessions.Session).ID
+}
+
+func sessionUsername(c echo.Context) string {
+	session := c.Get("session").(*sessions.Session)
+	username, _ := session.Values["username"].(string)
+	return username
+}
+
 func (app *Application) alreadyLoggedIn(c echo.Context) bool {
 	session := c.Get("session").(*sessions.Session)
 
@@ -85,32 +400,82 @@ func (app *Application) alreadyLoggedIn(c echo.Context) bool {
 	return false
 }
 
-func (app *Application) isAdmin(c echo.Context) bool {
-	session := c.Get("session").(*sessions.Session)
+// hasPermission reports whether the user holds the permission, and when authenticated by an api token whether the
+// token has it in its scopes. The roles requiring the second factor grant nothing until the user enables it.
+func (app *Application) hasPermission(c echo.Context, permission string) bool {
+	username := sessionUsername(c)
+	if username == "" {
+		return false
+	}
 
-	username, ok := session.Values["username"].(string)
-	if !ok {
+	if !inTokenScope(c, permission) {
 		return false
 	}
 
-	if exists, err := app.AdminsStore.IsExists(map[string]interface{}{"username": username}); err != nil || !exists {
+	allowed, err := app.RoleService.HasPermission(username, permission)
+	if err != nil {
+		c.Logger().Error(err)
 		return false
 	}
 
-	return true
+	return allowed && !app.secondFactorMissing(c)
 }
 
-func userFromContext(c echo.Context) (*models.UserDBModel, error) {
-	bs, err := bcrypt.GenerateFromPassword([]byte(c.FormValue("password")), bcrypt.MinCost)
+// inTokenScope reports whether the api token the request was authenticated with has the scope, the requests without
+// an api token have all the scopes.
+func inTokenScope(c echo.Context, scope string) bool {
+	scopes, ok := c.Get(tokenScopesKey).([]string)
+	if !ok {
+		return true
+	}
+
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// secondFactorMissing reports whether a role of the user requires the second factor the user hasn't enabled yet.
+func (app *Application) secondFactorMissing(c echo.Context) bool {
+	missing, err := app.TwoFactorService.EnrollmentRequired(sessionUsername(c))
 	if err != nil {
-		return nil, err
+		c.Logger().Error(err)
+		return true
 	}
 
-	return &models.UserDBModel{
+	return missing
+}
+
+func signUpReqFromContext(c echo.Context) *models.SignUpRequest {
+	return &models.SignUpRequest{
 		Username: c.FormValue("username"),
 		Email:    c.FormValue("email"),
-		Password: bs,
-	}, nil
+		Password: c.FormValue("password"),
+	}
+}
+
+// createAPITokenReqFromContext reads the space separated scopes and the optional lifetime in days of the new token.
+func createAPITokenReqFromContext(c echo.Context) (*models.CreateAPITokenRequest, error) {
+	req := &models.CreateAPITokenRequest{
+		Username: sessionUsername(c),
+		Name:     c.FormValue("name"),
+		Scopes:   strings.Fields(c.FormValue("scopes")),
+	}
+
+	if days := c.FormValue("expires_in_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return nil, err
+		}
+
+		expiresAt := time.Now().AddDate(0, 0, n)
+		req.ExpiresAt = &expiresAt
+	}
+
+	return req, nil
 }
 
 func productFromContext(c echo.Context) (*models.ProductDBModel, error) {
@@ -136,18 +501,40 @@ func productFromContext(c echo.Context) (*models.ProductDBModel, error) {
 	}, nil
 }
 
+// createOrderReqFromContext reads the order lines from the repeated product_name and quantity form fields,
+// rows left completely empty on the order page are skipped.
 func createOrderReqFromContext(c echo.Context) (*models.CreateOrderRequest, error) {
-	session := c.Get("session").(*sessions.Session)
-	username := session.Values["username"].(string)
-	productName := c.FormValue("product_name")
-	quantity, err := strconv.Atoi(c.FormValue("quantity"))
+	form, err := c.FormParams()
 	if err != nil {
 		return nil, err
 	}
 
+	productNames := form["product_name"]
+	quantities := form["quantity"]
+	if len(productNames) != len(quantities) {
+		return nil, models.ErrInvalidOrderLine
+	}
+
+	lines := []models.OrderLineRequest{}
+	for i := range productNames {
+		if productNames[i] == "" && quantities[i] == "" {
+			continue
+		}
+
+		quantity, err := strconv.Atoi(quantities[i])
+		if err != nil {
+			return nil, models.ErrInvalidQuantity
+		}
+
+		lines = append(lines, models.OrderLineRequest{
+			ProductName: productNames[i],
+			Quantity:    quantity,
+		})
+	}
+
 	return &models.CreateOrderRequest{
-		Username:            username,
-		ProductsAndQuantity: map[string]int{productName: quantity},
+		Username: sessionUsername(c),
+		Lines:    lines,
 	}, nil
 }
 
@@ -169,6 +556,7 @@ func (app *Application) addProduct(c echo.Context) error {
 		Category:        product.Category,
 		Price:           product.Price,
 		InitialQuantity: initialQuantity,
+		CreatedBy:       sessionUsername(c),
 	}
 
 	if err := app.ProductService.Create(req); err != nil {
@@ -206,7 +594,11 @@ func (app *Application) updateProduct(c echo.Context) error {
 		return err
 	}
 
-	req := models.UpdateProductRequest(*product)
+	req := models.UpdateProductRequest{
+		ProductName: product.ProductName,
+		Category:    product.Category,
+		Price:       product.Price,
+	}
 	if err := app.ProductService.Update(&req); err != nil {
 		if err == models.ErrProductNotFound {
 			return echo.NewHTTPError(http.StatusBadRequest, err)
@@ -218,3 +610,111 @@ func (app *Application) updateProduct(c echo.Context) error {
 
 	return nil
 }
+
+func (app *Application) cancelOrder(c echo.Context, asAdmin bool) error {
+	cancellation, err := app.OrderService.Cancel(&models.CancelOrderRequest{
+		OrderID:     c.FormValue("order_id"),
+		CancelledBy: sessionUsername(c),
+		Reason:      c.FormValue("reason"),
+		AsAdmin:     asAdmin,
+	})
+	if err != nil {
+		if err == models.ErrOrderNotFound || err == models.ErrInvalidTransition {
+			return echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		c.Logger().Error(err)
+		return err
+	}
+
+	return c.JSONPretty(http.StatusOK, cancellation, "    ")
+}
+
+// formInt parses the optional integer form field, returning nil when it was left empty.
+func formInt(c echo.Context, name string) (*int, error) {
+	value := c.FormValue(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, models.ErrInvalidPricingRule
+	}
+
+	return &i, nil
+}
+
+func createPricingRuleReqFromContext(c echo.Context) (*models.CreatePricingRuleRequest, error) {
+	req := &models.CreatePricingRuleRequest{
+		Name:     c.FormValue("name"),
+		Category: c.FormValue("category"),
+		Enabled:  c.FormValue("enabled") != "",
+	}
+
+	for name, field := range map[string]*int{
+		"stock_threshold":   &req.StockThreshold,
+		"surcharge_percent": &req.SurchargePercent,
+		"max_price_percent": &req.MaxPricePercent,
+		"cooldown_seconds":  &req.CooldownSeconds,
+	} {
+		value, err := formInt(c, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if value != nil {
+			*field = *value
+		}
+	}
+
+	return req, nil
+}
+
+// updatePricingRuleReqFromContext only sets the fields filled in the form, the enabled select is left empty to keep
+// the current value.
+func updatePricingRuleReqFromContext(c echo.Context) (*models.UpdatePricingRuleRequest, error) {
+	id, err := strconv.ParseInt(c.FormValue("id"), 10, 64)
+	if err != nil {
+		return nil, models.ErrPricingRuleNotFound
+	}
+
+	req := &models.UpdatePricingRuleRequest{ID: id}
+
+	if name := c.FormValue("name"); name != "" {
+		req.Name = &name
+	}
+
+	// an empty category is meaningful, it makes the rule apply to every category
+	form, err := c.FormParams()
+	if err != nil {
+		return nil, err
+	}
+
+	if category, ok := form["category"]; ok && len(category) != 0 {
+		req.Category = &category[0]
+	}
+
+	if enabled := c.FormValue("enabled"); enabled != "" {
+		value := enabled == "true"
+		req.Enabled = &value
+	}
+
+	if req.StockThreshold, err = formInt(c, "stock_threshold"); err != nil {
+		return nil, err
+	}
+
+	if req.SurchargePercent, err = formInt(c, "surcharge_percent"); err != nil {
+		return nil, err
+	}
+
+	if req.MaxPricePercent, err = formInt(c, "max_price_percent"); err != nil {
+		return nil, err
+	}
+
+	if req.CooldownSeconds, err = formInt(c, "cooldown_seconds"); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
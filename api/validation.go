@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/NikhilSharmaWe/market/models"
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything after the 72th byte
+	maxPasswordBytes = 72
+	maxEmailLength   = 254
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{2,31}$`)
+
+// validateSignUp checks every field of the request and returns a models.ValidationError listing all the invalid
+// ones, or nil.
+func validateSignUp(req *models.SignUpRequest) error {
+	fields := models.ValidationError{}
+
+	if !usernamePattern.MatchString(req.Username) {
+		fields["username"] = "must be 3 to 32 letters, digits, dots, dashes or underscores, starting with a letter or digit"
+	}
+
+	if msg := validateEmail(req.Email); msg != "" {
+		fields["email"] = msg
+	}
+
+	if msg := validatePassword(req.Password, req.Username); msg != "" {
+		fields["password"] = msg
+	}
+
+	if len(fields) != 0 {
+		return fields
+	}
+
+	return nil
+}
+
+func validateEmail(email string) string {
+	if email == "" {
+		return "is required"
+	}
+
+	if len(email) > maxEmailLength {
+		return "is too long"
+	}
+
+	// ParseAddress also accepts display names like "Bob <bob@example.com>", only the bare address is allowed
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "is not a valid email address"
+	}
+
+	if at := strings.LastIndex(email, "@"); !strings.Contains(email[at+1:], ".") {
+		return "is not a valid email address"
+	}
+
+	return ""
+}
+
+func validatePassword(password, username string) string {
+	if len([]rune(password)) < minPasswordLength {
+		return "must be at least 8 characters long"
+	}
+
+	if len(password) > maxPasswordBytes {
+		return "must be at most 72 bytes long"
+	}
+
+	hasLetter, hasDigit := false, false
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+
+	if !hasLetter || !hasDigit {
+		return "must contain at least one letter and one digit"
+	}
+
+	if strings.EqualFold(password, username) {
+		return "must not be the username"
+	}
+
+	return ""
+}
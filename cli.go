@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/NikhilSharmaWe/market/api"
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+// cliActor is recorded as the actor of the inventory movements and admin changes made from the command line.
+const cliActor = "cli"
+
+const usage = `usage: market <command> [arguments]
+
+commands:
+  serve                                   start the web server, the default command
+  migrate [up [version] | down [steps] | status | verify]
+  admin grant <username>                  make the user an admin
+  admin revoke <username>                 remove the user from the admins
+  user create -username <username> -email <email> [-password <password> | -password-stdin]
+  product import [-file <path>]           create the products of a csv file with the columns
+                                          product_name,category,price,quantity, stdin by default
+  inventory set <product_name> <quantity> set the stock of the product`
+
+func run(args []string) error {
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		return runServe(setupDB())
+	case "migrate":
+		return runMigrate(setupDB(), args)
+	case "admin":
+		return runAdmin(setupDB(), args)
+	case "user":
+		return runUser(setupDB(), args)
+	case "product":
+		return runProduct(setupDB(), args)
+	case "inventory":
+		return runInventory(setupDB(), args)
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return errors.New(usage)
+	}
+}
+
+func newApplication(db *gorm.DB) *api.Application {
+	return api.NewApplication(db, loadConfig())
+}
+
+func runServe(db *gorm.DB) error {
+	if err := migrateOnStart(db); err != nil {
+		return err
+	}
+
+	mux := newApplication(db).Router()
+
+	return mux.Start(os.Getenv("ADDR"))
+}
+
+func runAdmin(db *gorm.DB, args []string) error {
+	if len(args) != 2 || (args[0] != "grant" && args[0] != "revoke") {
+		return errors.New("usage: market admin grant|revoke <username>")
+	}
+
+	app := newApplication(db)
+	username := args[1]
+
+	// both are no-ops when there is nothing to change so deploy scripts can run them every time
+	if args[0] == "grant" {
+		err := app.AdminService.Grant(&models.GrantAdminRequest{Username: username, GrantedBy: cliActor})
+		if err == models.ErrAlreadyAdmin {
+			fmt.Printf("%s is already an admin\n", username)
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, username)
+		}
+
+		fmt.Printf("%s is now an admin\n", username)
+		return nil
+	}
+
+	err := app.AdminService.Revoke(&models.RevokeAdminRequest{Username: username, RevokedBy: cliActor})
+	if err == models.ErrNotAdmin {
+		fmt.Printf("%s is not an admin\n", username)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, username)
+	}
+
+	fmt.Printf("%s is no longer an admin\n", username)
+	return nil
+}
+
+func runUser(db *gorm.DB, args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return errors.New("usage: market user create -username <username> -email <email> [-password <password> | -password-stdin]")
+	}
+
+	flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+	username := flags.String("username", "", "username of the new user")
+	email := flags.String("email", "", "email of the new user")
+	password := flags.String("password", "", "password of the new user")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from the first line of stdin")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *passwordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	app := newApplication(db)
+
+	// the same validation as the signup page applies
+	if _, err := app.UserService.SignUp(&models.SignUpRequest{
+		Username: *username,
+		Email:    *email,
+		Password: *password,
+	}); err != nil {
+		var fields models.ValidationError
+		if errors.As(err, &fields) {
+			for field, msg := range fields {
+				fmt.Printf("%s %s\n", field, msg)
+			}
+		}
+
+		return err
+	}
+
+	fmt.Printf("created user %s\n", *username)
+	return nil
+}
+
+// runProduct imports products from a csv file, see ProductService.Import.
+func runProduct(db *gorm.DB, args []string) error {
+	if len(args) == 0 || args[0] != "import" {
+		return errors.New("usage: market product import [-file <path>]")
+	}
+
+	flags := flag.NewFlagSet("product import", flag.ContinueOnError)
+	file := flags.String("file", "-", "csv file to import, - for stdin")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		input = f
+	}
+
+	app := newApplication(db)
+
+	resp, err := app.ProductService.Import(&models.ImportProductsRequest{Input: input, ImportedBy: cliActor})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created %d products, skipped %d existing\n", resp.Created, resp.Skipped)
+	return nil
+}
+
+// runInventory sets the stock of a product to an absolute quantity, see InventoryService.Set.
+func runInventory(db *gorm.DB, args []string) error {
+	if len(args) != 3 || args[0] != "set" {
+		return errors.New("usage: market inventory set <product_name> <quantity>")
+	}
+
+	productName := args[1]
+
+	quantity, err := strconv.Atoi(args[2])
+	if err != nil {
+		return models.ErrInvalidQuantity
+	}
+
+	app := newApplication(db)
+
+	resp, err := app.InventoryService.Set(&models.SetInventoryRequest{
+		ProductName: productName,
+		Quantity:    quantity,
+		UpdatedBy:   cliActor,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !resp.Changed {
+		fmt.Printf("%s stock is already %d\n", productName, quantity)
+		return nil
+	}
+
+	fmt.Printf("%s stock set to %d\n", productName, resp.QuantityInStock)
+	return nil
+}
@@ -0,0 +1,106 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(Message) error
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer sends the messages through the SMTP server at addr (host:port), authenticating with PLAIN auth when a
+// username is given.
+func NewSMTPMailer(addr, username, password, from string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &smtpMailer{
+		addr: addr,
+		from: from,
+		auth: auth,
+	}
+}
+
+func (sm *smtpMailer) Send(msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid header in message to %q", msg.To)
+	}
+
+	body := "From: " + sm.from + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(msg.Body, "\n", "\r\n")
+
+	return smtp.SendMail(sm.addr, sm.auth, sm.from, []string{msg.To}, []byte(body))
+}
+
+type writerMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterMailer writes the messages to w instead of sending them, for local development and tests. Pass log.Writer()
+// to get them in the logs.
+func NewWriterMailer(w io.Writer) Mailer {
+	return &writerMailer{
+		w: w,
+	}
+}
+
+func (wm *writerMailer) Send(msg Message) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	_, err := fmt.Fprintf(wm.w, "To: %s\nSubject: %s\n\n%s\n\n", msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+type fileMailer struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileMailer appends the messages to the file at path, creating it if needed.
+func NewFileMailer(path string) Mailer {
+	return &fileMailer{
+		path: path,
+	}
+}
+
+func (fm *fileMailer) Send(msg Message) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	f, err := os.OpenFile(fm.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return NewWriterMailer(f).Send(msg)
+}
@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := NewFileMailer(path)
+
+	assert.Nil(t, m.Send(Message{To: "alice@market.test", Subject: "First", Body: "one"}))
+	assert.Nil(t, m.Send(Message{To: "bob@market.test", Subject: "Second", Body: "two"}))
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "To: alice@market.test\nSubject: First\n\none\n\nTo: bob@market.test\nSubject: Second\n\ntwo\n\n", string(content))
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	m := NewSMTPMailer("localhost:25", "", "", "market@market.test")
+
+	err := m.Send(Message{To: "alice@market.test\r\nBcc: eve@market.test", Subject: "Hi", Body: "hello"})
+	assert.NotNil(t, err)
+}
@@ -1,11 +1,23 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"log"
+	"math"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/NikhilSharmaWe/market/api"
+	"github.com/NikhilSharmaWe/market/mailer"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -17,13 +29,9 @@ func init() {
 }
 
 func main() {
-	db := setupDB()
-
-	app := api.NewApplication(db, os.Getenv("SESSION_SECRET_KEY"))
-
-	mux := app.Router()
-
-	log.Fatal(mux.Start(os.Getenv("ADDR")))
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func setupDB() *gorm.DB {
@@ -35,3 +43,216 @@ func setupDB() *gorm.DB {
 
 	return db
 }
+
+// loadConfig reads the settings of the application from the environment.
+func loadConfig() api.Config {
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		addr := os.Getenv("ADDR")
+		if strings.HasPrefix(addr, ":") {
+			addr = "localhost" + addr
+		}
+
+		baseURL = "http://" + addr
+	}
+
+	passwordAlgorithm := os.Getenv("PASSWORD_HASH")
+	if passwordAlgorithm != "" && passwordAlgorithm != api.PasswordAlgorithmBcrypt && passwordAlgorithm != api.PasswordAlgorithmArgon2id {
+		log.Fatalf("PASSWORD_HASH must be %s or %s", api.PasswordAlgorithmBcrypt, api.PasswordAlgorithmArgon2id)
+	}
+
+	// 0 keeps the default cost
+	bcryptCost := envInt("BCRYPT_COST")
+	if bcryptCost != 0 && (bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost) {
+		log.Fatalf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	// 0 keeps the default of each argon2 parameter
+	argon2Memory := envInt("ARGON2_MEMORY_KIB")
+	if int64(argon2Memory) > math.MaxUint32 {
+		log.Fatalf("ARGON2_MEMORY_KIB must be at most %d", uint32(math.MaxUint32))
+	}
+
+	argon2Iterations := envInt("ARGON2_ITERATIONS")
+	if int64(argon2Iterations) > math.MaxUint32 {
+		log.Fatalf("ARGON2_ITERATIONS must be at most %d", uint32(math.MaxUint32))
+	}
+
+	argon2Parallelism := envInt("ARGON2_PARALLELISM")
+	if argon2Parallelism > math.MaxUint8 {
+		log.Fatalf("ARGON2_PARALLELISM must be at most %d", math.MaxUint8)
+	}
+
+	sameSite := http.SameSiteLaxMode
+	switch strings.ToLower(os.Getenv("SESSION_COOKIE_SAMESITE")) {
+	case "", "lax":
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	default:
+		log.Fatal("SESSION_COOKIE_SAMESITE must be lax, strict or none")
+	}
+
+	// the cookie is secure by default when the market is served over https
+	secure := strings.HasPrefix(baseURL, "https://")
+	if value := os.Getenv("SESSION_COOKIE_SECURE"); value != "" {
+		secure = value == "true"
+	}
+
+	if sameSite == http.SameSiteNoneMode && !secure {
+		log.Fatal("SESSION_COOKIE_SAMESITE=none requires SESSION_COOKIE_SECURE=true")
+	}
+
+	return api.Config{
+		SessionKeys:               sessionKeys(),
+		SessionIdleTimeout:        envDuration("SESSION_IDLE_TIMEOUT"),
+		SessionAbsoluteTimeout:    envDuration("SESSION_ABSOLUTE_TIMEOUT"),
+		SessionCookieSecure:       secure,
+		SessionCookieSameSite:     sameSite,
+		SessionCookieScriptAccess: os.Getenv("SESSION_COOKIE_HTTP_ONLY") == "false",
+		BaseURL:                   baseURL,
+		Mailer:                    setupMailer(),
+		PasswordAlgorithm:         passwordAlgorithm,
+		BcryptCost:                bcryptCost,
+		Argon2: api.Argon2Params{
+			Memory:      uint32(argon2Memory),
+			Iterations:  uint32(argon2Iterations),
+			Parallelism: uint8(argon2Parallelism),
+		},
+		TrustProxy:     os.Getenv("TRUST_PROXY") == "true",
+		JWTSigningKeys: jwtSigningKeys(),
+		JWTAccessTTL:   envDuration("JWT_ACCESS_TTL"),
+		JWTRefreshTTL:  envDuration("JWT_REFRESH_TTL"),
+		// comma separated, like super_admin,inventory_clerk
+		TOTPRequiredRoles: envList("TOTP_REQUIRED_ROLES"),
+		OIDCIssuer:        os.Getenv("OIDC_ISSUER"),
+		OIDCClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCScopes:        envList("OIDC_SCOPES"),
+		OIDCGroupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+		OIDCAdminGroups:   envList("OIDC_ADMIN_GROUPS"),
+	}
+}
+
+// envList reads the environment variable as a list separated by commas or spaces.
+func envList(name string) []string {
+	return strings.FieldsFunc(os.Getenv(name), func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// envInt reads the integer environment variable, 0 when it is not set.
+func envInt(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		log.Fatalf("%s must be a positive integer", name)
+	}
+
+	return i
+}
+
+// envDuration reads the duration environment variable, like 30m or 720h, 0 when it is not set.
+func envDuration(name string) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("%s must be a positive duration", name)
+	}
+
+	return d
+}
+
+// sessionKeys reads SESSION_KEYS, comma separated pairs of a hash key and an optional encryption key joined by a
+// colon, hex encoded, the current pair first. Without it the single SESSION_SECRET_KEY used before is the hash key,
+// so the sessions survive the upgrade.
+func sessionKeys() [][]byte {
+	value := os.Getenv("SESSION_KEYS")
+	if value == "" {
+		if secret := os.Getenv("SESSION_SECRET_KEY"); secret != "" {
+			return [][]byte{[]byte(secret), nil}
+		}
+
+		return nil
+	}
+
+	keys := [][]byte{}
+	for _, pair := range strings.Split(value, ",") {
+		hashHex, encryptionHex, _ := strings.Cut(strings.TrimSpace(pair), ":")
+
+		hashKey, err := hex.DecodeString(hashHex)
+		if err != nil || len(hashKey) < 32 {
+			log.Fatal("SESSION_KEYS hash keys must be at least 32 hex encoded bytes")
+		}
+
+		var encryptionKey []byte
+		if encryptionHex != "" {
+			encryptionKey, err = hex.DecodeString(encryptionHex)
+			if err != nil || (len(encryptionKey) != 16 && len(encryptionKey) != 24 && len(encryptionKey) != 32) {
+				log.Fatal("SESSION_KEYS encryption keys must be 16, 24 or 32 hex encoded bytes")
+			}
+		}
+
+		keys = append(keys, hashKey, encryptionKey)
+	}
+
+	return keys
+}
+
+// jwtSigningKeys reads JWT_SIGNING_KEYS, comma separated paths of pem encoded P-256 private keys, the signing key
+// first, like the ones made by openssl ecparam -name prime256v1 -genkey -noout.
+func jwtSigningKeys() []*ecdsa.PrivateKey {
+	value := os.Getenv("JWT_SIGNING_KEYS")
+	if value == "" {
+		return nil
+	}
+
+	keys := []*ecdsa.PrivateKey{}
+	for _, path := range strings.Split(value, ",") {
+		data, err := os.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil {
+			log.Fatalf("%s is not a pem encoded key", path)
+		}
+
+		var key *ecdsa.PrivateKey
+		if block.Type == "EC PRIVATE KEY" {
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		} else {
+			var parsed interface{}
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			key, _ = parsed.(*ecdsa.PrivateKey)
+		}
+
+		if err != nil || key == nil || key.Curve != elliptic.P256() {
+			log.Fatalf("%s must be a P-256 private key", path)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// setupMailer picks the mailer named by MAILER, smtp or file, the messages are written to the log otherwise.
+func setupMailer() mailer.Mailer {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		return mailer.NewSMTPMailer(os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+	case "file":
+		return mailer.NewFileMailer(os.Getenv("MAIL_FILE"))
+	default:
+		return mailer.NewWriterMailer(log.Writer())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/NikhilSharmaWe/market/migrations"
+	"gorm.io/gorm"
+)
+
+const migrateUsage = "usage: market migrate [up [version] | down [steps] | status | verify]"
+
+// runMigrate handles the migrate subcommand, up applies every pending migration, or those up to the given version,
+// and down reverts the latest one, or the given number of them.
+func runMigrate(db *gorm.DB, args []string) error {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	number := 0
+	if len(args) > 1 {
+		number, err = strconv.Atoi(args[1])
+		if err != nil || number < 0 || len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+	}
+
+	switch command {
+	case "up":
+		done, err := migrator.Up(number)
+		for _, migration := range done {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+
+		if err == nil && len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+
+		return err
+
+	case "down":
+		if number == 0 {
+			number = 1
+		}
+
+		done, err := migrator.Down(number)
+		for _, migration := range done {
+			fmt.Printf("reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+
+		return err
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			if status.Modified {
+				state += " (modified)"
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+
+		return nil
+
+	case "verify":
+		if err := migrator.Verify(); err != nil {
+			return err
+		}
+
+		fmt.Println("applied migrations match")
+		return nil
+
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// migrateOnStart applies the pending migrations before serving when MIGRATE_ON_START is set to true.
+func migrateOnStart(db *gorm.DB) error {
+	if os.Getenv("MIGRATE_ON_START") != "true" {
+		return nil
+	}
+
+	return runMigrate(db, []string{"up"})
+}
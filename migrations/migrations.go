@@ -0,0 +1,333 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the key of the postgres advisory lock taken by every migration so two instances starting at the same time
+// don't apply the same migration twice.
+const lockID = 727174
+
+// Migration is a pair of sql files. Checksum covers both of them, so a changed down file is caught before it undoes
+// an applied migration the wrong way.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type AppliedMigration struct {
+	Version   int       `gorm:"column:version"`
+	Name      string    `gorm:"column:name"`
+	Checksum  string    `gorm:"column:checksum"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Modified  bool       `json:"modified"`
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func New(db *gorm.DB) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// Load reads the embedded migrations, which are named <version>_<name>.up.sql and <version>_<name>.down.sql, sorted by
+// version.
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("%w: %s", models.ErrInvalidMigration, fileName)
+		}
+
+		versionPart, name, found := strings.Cut(strings.TrimSuffix(fileName, "."+direction+".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("%w: %s", models.ErrInvalidMigration, fileName)
+		}
+
+		version, err := strconv.Atoi(versionPart)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: %s", models.ErrInvalidMigration, fileName)
+		}
+
+		content, err := files.ReadFile(path.Join("sql", fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != name {
+			return nil, fmt.Errorf("%w: version %d has two names", models.ErrInvalidMigration, version)
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("%w: version %d needs both an up and a down file", models.ErrInvalidMigration, migration.Version)
+		}
+
+		migration.Checksum = checksum(migration.Up, migration.Down)
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// checksum hashes the hashes of the two files, so no content of one can be moved to the other unnoticed.
+func checksum(up, down string) string {
+	upSum := sha256.Sum256([]byte(up))
+	downSum := sha256.Sum256([]byte(down))
+	sum := sha256.Sum256(append(upSum[:], downSum[:]...))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Migrator) table() string {
+	return "schema_migrations"
+}
+
+func (m *Migrator) ensureTable() error {
+	return m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations(
+    version INT NOT NULL PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at TIMESTAMP WITH TIME ZONE
+)`).Error
+}
+
+func (m *Migrator) applied(db *gorm.DB) (map[int]AppliedMigration, error) {
+	rows := []AppliedMigration{}
+	if err := db.Table(m.table()).Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[int]AppliedMigration{}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
+
+// Verify checks that every applied migration is still known and that its file didn't change since it was applied.
+func (m *Migrator) Verify() error {
+	if err := m.ensureTable(); err != nil {
+		return err
+	}
+
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return err
+	}
+
+	return m.verify(applied)
+}
+
+func (m *Migrator) verify(applied map[int]AppliedMigration) error {
+	known := map[int]Migration{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, row := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("%w: version %d", models.ErrUnknownMigration, version)
+		}
+
+		if migration.Checksum != row.Checksum {
+			return fmt.Errorf("%w: version %d (%s)", models.ErrMigrationChecksum, version, migration.Name)
+		}
+	}
+
+	return nil
+}
+
+// Up applies the pending migrations up to and including the target version, or all of them when target is 0. Every
+// migration runs in its own transaction along with its schema_migrations row. The applied migrations are returned.
+func (m *Migrator) Up(target int) ([]Migration, error) {
+	done := []Migration{}
+
+	if err := m.Verify(); err != nil {
+		return done, err
+	}
+
+	for _, migration := range m.migrations {
+		if target != 0 && migration.Version > target {
+			break
+		}
+
+		ran := false
+		if err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockID).Error; err != nil {
+				return err
+			}
+
+			// another instance may have applied it while this one waited for the lock
+			applied, err := m.applied(tx)
+			if err != nil {
+				return err
+			}
+
+			if _, ok := applied[migration.Version]; ok {
+				return nil
+			}
+
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+
+			ran = true
+			return tx.Table(m.table()).Create(AppliedMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				Checksum:  migration.Checksum,
+				AppliedAt: time.Now(),
+			}).Error
+		}); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+
+		if ran {
+			done = append(done, migration)
+		}
+	}
+
+	return done, nil
+}
+
+// Down reverts the given number of applied migrations, latest first. The reverted migrations are returned.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	done := []Migration{}
+
+	if err := m.Verify(); err != nil {
+		return done, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+
+		ran := false
+		if err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockID).Error; err != nil {
+				return err
+			}
+
+			applied, err := m.applied(tx)
+			if err != nil {
+				return err
+			}
+
+			if _, ok := applied[migration.Version]; !ok {
+				return nil
+			}
+
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return err
+			}
+
+			ran = true
+			return tx.Table(m.table()).Where("version = ?", migration.Version).Delete(nil).Error
+		}); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+
+		if ran {
+			done = append(done, migration)
+		}
+	}
+
+	return done, nil
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		status := MigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+			status.Modified = row.Checksum != migration.Checksum
+		}
+
+		resp = append(resp, status)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		// versions are consecutive so a missing file shows up here rather than on a production database
+		assert.Equal(t, i+1, migration.Version)
+		assert.NotEmpty(t, strings.TrimSpace(migration.Up))
+		assert.NotEmpty(t, strings.TrimSpace(migration.Down))
+		assert.Len(t, migration.Checksum, 64)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	sum := checksum("CREATE TABLE t(id INT);", "DROP TABLE t;")
+	assert.Equal(t, sum, checksum("CREATE TABLE t(id INT);", "DROP TABLE t;"))
+
+	// an edit of either file is caught
+	assert.NotEqual(t, sum, checksum("CREATE TABLE t(id BIGINT);", "DROP TABLE t;"))
+	assert.NotEqual(t, sum, checksum("CREATE TABLE t(id INT);", "DROP TABLE IF EXISTS t;"))
+	assert.NotEqual(t, sum, checksum("CREATE TABLE t(id INT);DROP TABLE t;", ""))
+}
@@ -0,0 +1,3 @@
+DROP TABLE inventory, order_per_product, orders, admins, users, products;
+
+DROP FUNCTION adjust_product_pricing();
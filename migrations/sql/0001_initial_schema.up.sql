@@ -1,34 +1,35 @@
-CREATE TABLE products(
+-- the schema of the original db.sql, written so it can also run against a database created from that file
+CREATE TABLE IF NOT EXISTS products(
     product_name TEXT NOT NULL PRIMARY KEY,
 	category TEXT NOT NULL,
 	price INT NOT NULL
 );
 
-CREATE TABLE users(
+CREATE TABLE IF NOT EXISTS users(
     username TEXT NOT NULL PRIMARY KEY,
 	password_hash TEXT NOT NULL,
 	email TEXT NOT NULL
 );
 
-CREATE TABLE admins(
+CREATE TABLE IF NOT EXISTS admins(
     username TEXT NOT NULL PRIMARY KEY REFERENCES users(username) ON DELETE CASCADE
 );
 
-CREATE TABLE orders(
+CREATE TABLE IF NOT EXISTS orders(
     order_id TEXT NOT NULL PRIMARY KEY,
     username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
 	total_cost INT NOT NULL,
 	created_at TIMESTAMP WITH TIME ZONE
 );
 
-CREATE TABLE order_per_product(
+CREATE TABLE IF NOT EXISTS order_per_product(
 	order_id TEXT NOT NULL REFERENCES orders(order_id) ON DELETE CASCADE,
 	product_name TEXT NOT NULL,
 	quantity INT NOT NULL,
 	PRIMARY KEY (order_id, product_name)
 );
 
-CREATE TABLE inventory(
+CREATE TABLE IF NOT EXISTS inventory(
     product_name TEXT NOT NULL PRIMARY KEY REFERENCES products(product_name) ON DELETE CASCADE,
 	quantity_in_stock INT NOT NULL
 );
@@ -48,8 +49,8 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
+DROP TRIGGER IF EXISTS adjust_product_pricing_trigger ON inventory;
 
 CREATE TRIGGER adjust_product_pricing_trigger
 AFTER INSERT OR UPDATE ON inventory
 FOR EACH ROW EXECUTE FUNCTION adjust_product_pricing();
-
@@ -0,0 +1,7 @@
+CREATE TABLE cart_items(
+    username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+	product_name TEXT NOT NULL REFERENCES products(product_name) ON DELETE CASCADE,
+	quantity INT NOT NULL,
+	added_at TIMESTAMP WITH TIME ZONE,
+	PRIMARY KEY (username, product_name)
+);
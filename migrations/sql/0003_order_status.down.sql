@@ -0,0 +1,3 @@
+ALTER TABLE orders
+    DROP COLUMN status,
+	DROP COLUMN updated_at;
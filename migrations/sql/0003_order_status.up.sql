@@ -0,0 +1,5 @@
+ALTER TABLE orders
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'pending',
+	ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE orders SET updated_at = created_at;
@@ -0,0 +1,6 @@
+CREATE TABLE order_cancellations(
+    order_id TEXT NOT NULL PRIMARY KEY REFERENCES orders(order_id) ON DELETE CASCADE,
+	cancelled_by TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	cancelled_at TIMESTAMP WITH TIME ZONE
+);
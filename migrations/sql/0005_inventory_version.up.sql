@@ -0,0 +1 @@
+ALTER TABLE inventory ADD COLUMN version INT NOT NULL DEFAULT 0;
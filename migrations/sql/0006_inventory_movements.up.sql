@@ -0,0 +1,11 @@
+CREATE TABLE inventory_movements(
+    id BIGSERIAL PRIMARY KEY,
+	product_name TEXT NOT NULL,
+	delta INT NOT NULL,
+	reason TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	reference TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX inventory_movements_product_name_idx ON inventory_movements(product_name);
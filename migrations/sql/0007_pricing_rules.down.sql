@@ -0,0 +1,24 @@
+DROP TABLE pricing_rules;
+
+ALTER TABLE products
+    DROP COLUMN base_price,
+	DROP COLUMN price_changed_at;
+
+CREATE OR REPLACE FUNCTION adjust_product_pricing()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE products
+    SET price = CEIL(price * 1.1) 
+    WHERE product_name IN (
+        SELECT product_name 
+        FROM inventory 
+        WHERE quantity_in_stock < 10
+    );
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER adjust_product_pricing_trigger
+AFTER INSERT OR UPDATE ON inventory
+FOR EACH ROW EXECUTE FUNCTION adjust_product_pricing();
@@ -0,0 +1,22 @@
+-- prices are computed by the pricing rules of the application from now on
+DROP TRIGGER IF EXISTS adjust_product_pricing_trigger ON inventory;
+
+DROP FUNCTION IF EXISTS adjust_product_pricing();
+
+ALTER TABLE products
+    ADD COLUMN base_price INT NOT NULL DEFAULT 0,
+	ADD COLUMN price_changed_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE products SET base_price = price;
+
+CREATE TABLE pricing_rules(
+    id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	category TEXT NOT NULL DEFAULT '',
+	stock_threshold INT NOT NULL,
+	surcharge_percent INT NOT NULL,
+	max_price_percent INT NOT NULL DEFAULT 0,
+	cooldown_seconds INT NOT NULL DEFAULT 0,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,3 @@
+DROP TABLE product_price_history;
+
+ALTER TABLE order_per_product DROP COLUMN unit_price;
@@ -0,0 +1,15 @@
+ALTER TABLE order_per_product ADD COLUMN unit_price INT NOT NULL DEFAULT 0;
+
+CREATE TABLE product_price_history(
+    id BIGSERIAL PRIMARY KEY,
+	product_name TEXT NOT NULL,
+	old_price INT NOT NULL,
+	new_price INT NOT NULL,
+	changed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX product_price_history_product_name_idx ON product_price_history(product_name);
+
+-- the current prices are the starting point of the history
+INSERT INTO product_price_history(product_name, old_price, new_price, changed_at)
+SELECT product_name, 0, price, NOW() FROM products;
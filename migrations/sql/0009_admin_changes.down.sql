@@ -0,0 +1 @@
+DROP TABLE admin_changes;
@@ -0,0 +1,7 @@
+CREATE TABLE admin_changes(
+    id BIGSERIAL PRIMARY KEY,
+	username TEXT NOT NULL,
+	action TEXT NOT NULL,
+	changed_by TEXT NOT NULL,
+	changed_at TIMESTAMP WITH TIME ZONE
+);
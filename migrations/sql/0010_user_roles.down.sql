@@ -0,0 +1,3 @@
+ALTER TABLE admin_changes DROP COLUMN role;
+
+DROP TABLE user_roles;
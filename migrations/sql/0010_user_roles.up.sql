@@ -0,0 +1,10 @@
+CREATE TABLE user_roles(
+    username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+	role TEXT NOT NULL,
+	granted_by TEXT NOT NULL,
+	granted_at TIMESTAMP WITH TIME ZONE,
+	PRIMARY KEY (username, role)
+);
+
+-- the admins table holds the super admins, the changes made before roles existed were all about them
+ALTER TABLE admin_changes ADD COLUMN role TEXT NOT NULL DEFAULT 'super_admin';
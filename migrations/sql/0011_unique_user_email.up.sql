@@ -0,0 +1,2 @@
+-- emails are compared case insensitively, duplicates have to be resolved by hand before this runs
+CREATE UNIQUE INDEX users_email_idx ON users(LOWER(email));
@@ -0,0 +1,8 @@
+CREATE TABLE login_failures(
+	kind TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_failed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	locked_until TIMESTAMP WITH TIME ZONE,
+	PRIMARY KEY (kind, subject)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE api_tokens(
+	id BIGSERIAL PRIMARY KEY,
+	username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMP WITH TIME ZONE,
+	last_used_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX api_tokens_username_idx ON api_tokens(username);
@@ -0,0 +1,12 @@
+CREATE TABLE refresh_tokens(
+	id BIGSERIAL PRIMARY KEY,
+	username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+	family TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	used_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens(family);
+CREATE INDEX refresh_tokens_username_idx ON refresh_tokens(username);
@@ -0,0 +1,9 @@
+CREATE TABLE user_identities(
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_username_idx ON user_identities(username);
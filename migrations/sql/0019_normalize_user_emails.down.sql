@@ -0,0 +1,6 @@
+-- this migration is irreversible: the original case and spaces of the emails are lost. Reverting it keeps the
+-- normalised emails, which the schema before it accepts as they are.
+DO $$
+BEGIN
+	RAISE NOTICE 'migration 19 (normalize_user_emails) is irreversible, the emails stay normalised';
+END $$;
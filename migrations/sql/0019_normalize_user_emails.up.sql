@@ -0,0 +1,16 @@
+-- the emails are looked up lowercased, the ones stored before signup normalised them are never found otherwise.
+-- Trimming can make two emails equal for users_email_idx, such duplicates have to be resolved by hand before this runs.
+DO $$
+DECLARE
+	duplicates TEXT;
+BEGIN
+	SELECT string_agg(email, ', ') INTO duplicates FROM (
+		SELECT LOWER(TRIM(email)) AS email FROM users GROUP BY LOWER(TRIM(email)) HAVING COUNT(*) > 1
+	) AS d;
+
+	IF duplicates IS NOT NULL THEN
+		RAISE EXCEPTION 'users share an email once normalised, resolve them by hand: %', duplicates;
+	END IF;
+END $$;
+
+UPDATE users SET email = LOWER(TRIM(email)) WHERE email <> LOWER(TRIM(email));
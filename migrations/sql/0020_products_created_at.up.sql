@@ -0,0 +1,5 @@
+-- the ledger and the price history of a product start at its creation, the rows of a deleted product stay for the
+-- audit without being counted for a product made again with the same name. The products made before start at the
+-- epoch.
+ALTER TABLE products ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT 'epoch';
+ALTER TABLE products ALTER COLUMN created_at SET DEFAULT NOW();
@@ -3,35 +3,179 @@ package models
 import "time"
 
 type ProductDBModel struct {
-	ProductName string `gorm:"column:product_name;primaryKey"`
-	Category    string `gorm:"column:category"`
-	Price       int    `gorm:"column:price"`
+	ProductName    string    `gorm:"column:product_name;primaryKey"`
+	Category       string    `gorm:"column:category"`
+	Price          int       `gorm:"column:price"`
+	BasePrice      int       `gorm:"column:base_price"`
+	PriceChangedAt time.Time `gorm:"column:price_changed_at"`
+	CreatedAt      time.Time `gorm:"column:created_at"`
 }
 
 type UserDBModel struct {
-	Username string `gorm:"column:username;primaryKey"`
-	Email    string `gorm:"column:email"`
-	Password []byte `gorm:"column:password_hash"`
+	Username        string     `gorm:"column:username;primaryKey"`
+	Email           string     `gorm:"column:email"`
+	Password        []byte     `gorm:"column:password_hash"`
+	EmailVerifiedAt *time.Time `gorm:"column:email_verified_at"`
+	// TOTPSecret is set at the enrollment and the second factor is asked at the sign in once TOTPEnabledAt is set.
+	// TOTPLastStep is the time step of the last code accepted, so a code can't be used twice.
+	TOTPSecret    string     `gorm:"column:totp_secret"`
+	TOTPEnabledAt *time.Time `gorm:"column:totp_enabled_at"`
+	TOTPLastStep  int64      `gorm:"column:totp_last_step"`
+}
+
+// UserIdentityDBModel links the account of an oidc identity provider, the Subject of its Issuer, to the user.
+type UserIdentityDBModel struct {
+	Issuer    string    `gorm:"column:issuer;primaryKey"`
+	Subject   string    `gorm:"column:subject;primaryKey"`
+	Username  string    `gorm:"column:username"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+// RecoveryCodeDBModel is a single use code signing the user in without the authenticator app.
+type RecoveryCodeDBModel struct {
+	ID        int64      `gorm:"column:id;primaryKey;autoIncrement"`
+	Username  string     `gorm:"column:username"`
+	CodeHash  string     `gorm:"column:code_hash"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+}
+
+type UserTokenDBModel struct {
+	ID        int64      `gorm:"column:id;primaryKey;autoIncrement"`
+	Username  string     `gorm:"column:username"`
+	Purpose   string     `gorm:"column:purpose"`
+	TokenHash string     `gorm:"column:token_hash"`
+	ExpiresAt time.Time  `gorm:"column:expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+}
+
+type SessionDBModel struct {
+	ID         int64     `gorm:"column:id;primaryKey;autoIncrement"`
+	TokenHash  string    `gorm:"column:token_hash"`
+	Username   string    `gorm:"column:username"`
+	UserAgent  string    `gorm:"column:user_agent"`
+	IP         string    `gorm:"column:ip"`
+	CreatedAt  time.Time `gorm:"column:created_at"`
+	LastSeenAt time.Time `gorm:"column:last_seen_at"`
+	ExpiresAt  time.Time `gorm:"column:expires_at"`
+}
+
+// APITokenDBModel is a token of a machine client, Scopes are the permissions and user scopes it may use separated by
+// spaces.
+type APITokenDBModel struct {
+	ID         int64      `gorm:"column:id;primaryKey;autoIncrement"`
+	Username   string     `gorm:"column:username"`
+	Name       string     `gorm:"column:name"`
+	TokenHash  string     `gorm:"column:token_hash"`
+	Scopes     string     `gorm:"column:scopes"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at"`
+	CreatedAt  time.Time  `gorm:"column:created_at"`
+}
+
+// RefreshTokenDBModel is a refresh token of the jwt access tokens. Every refresh replaces the token with a new one of
+// the same Family, so the use of a replaced token gives away a stolen one.
+type RefreshTokenDBModel struct {
+	ID        int64      `gorm:"column:id;primaryKey;autoIncrement"`
+	Username  string     `gorm:"column:username"`
+	Family    string     `gorm:"column:family"`
+	TokenHash string     `gorm:"column:token_hash"`
+	ExpiresAt time.Time  `gorm:"column:expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+}
+
+type LoginFailureDBModel struct {
+	Kind         string     `gorm:"column:kind;primaryKey" json:"kind"`
+	Subject      string     `gorm:"column:subject;primaryKey" json:"subject"`
+	Failures     int        `gorm:"column:failures" json:"failures"`
+	LastFailedAt time.Time  `gorm:"column:last_failed_at" json:"last_failed_at"`
+	LockedUntil  *time.Time `gorm:"column:locked_until" json:"locked_until"`
 }
 
 type AdminDBModel struct {
-	Username string `gorm:"column:username;primaryKey"`
+	Username string `gorm:"column:username;primaryKey" json:"username"`
+}
+
+type AdminChangeDBModel struct {
+	ID        int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Username  string    `gorm:"column:username" json:"username"`
+	Action    string    `gorm:"column:action" json:"action"`
+	Role      string    `gorm:"column:role" json:"role"`
+	ChangedBy string    `gorm:"column:changed_by" json:"changed_by"`
+	ChangedAt time.Time `gorm:"column:changed_at" json:"changed_at"`
+}
+
+type UserRoleDBModel struct {
+	Username  string    `gorm:"column:username;primaryKey" json:"username"`
+	Role      string    `gorm:"column:role;primaryKey" json:"role"`
+	GrantedBy string    `gorm:"column:granted_by" json:"granted_by"`
+	GrantedAt time.Time `gorm:"column:granted_at" json:"granted_at"`
 }
 
 type OrderDBModel struct {
-	OrderID   string    `gorm:"column:order_id;primaryKey"`
-	Username  string    `gorm:"column:username"`
-	TotalCost int       `gorm:"column:total_cost"`
-	CreatedAt time.Time `gorm:"column:created_at"`
+	OrderID   string    `gorm:"column:order_id;primaryKey" json:"order_id"`
+	Username  string    `gorm:"column:username" json:"username"`
+	TotalCost int       `gorm:"column:total_cost" json:"total_cost"`
+	Status    string    `gorm:"column:status" json:"status"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
 }
 
 type OrderPerProductDBModel struct {
-	OrderID     string `gorm:"column:order_id" json:"created_at"`
+	OrderID     string `gorm:"column:order_id" json:"order_id"`
 	ProductName string `gorm:"column:product_name" json:"product_name"`
 	Quantity    int    `gorm:"column:quantity" json:"quantity"`
+	UnitPrice   int    `gorm:"column:unit_price" json:"unit_price"`
+}
+
+type PriceHistoryDBModel struct {
+	ID          int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	ProductName string    `gorm:"column:product_name" json:"product_name"`
+	OldPrice    int       `gorm:"column:old_price" json:"old_price"`
+	NewPrice    int       `gorm:"column:new_price" json:"new_price"`
+	ChangedAt   time.Time `gorm:"column:changed_at" json:"changed_at"`
+}
+
+type OrderCancellationDBModel struct {
+	OrderID     string    `gorm:"column:order_id;primaryKey" json:"order_id"`
+	CancelledBy string    `gorm:"column:cancelled_by" json:"cancelled_by"`
+	Reason      string    `gorm:"column:reason" json:"reason"`
+	CancelledAt time.Time `gorm:"column:cancelled_at" json:"cancelled_at"`
 }
 
 type InventoryDBModel struct {
 	ProductName     string `gorm:"column:product_name;primaryKey"`
 	QuantityInStock int    `gorm:"column:quantity_in_stock"`
+	Version         int    `gorm:"column:version"`
+}
+
+type InventoryMovementDBModel struct {
+	ID          int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	ProductName string    `gorm:"column:product_name" json:"product_name"`
+	Delta       int       `gorm:"column:delta" json:"delta"`
+	Reason      string    `gorm:"column:reason" json:"reason"`
+	Actor       string    `gorm:"column:actor" json:"actor"`
+	Reference   string    `gorm:"column:reference" json:"reference"`
+	CreatedAt   time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+type CartItemDBModel struct {
+	Username    string    `gorm:"column:username;primaryKey"`
+	ProductName string    `gorm:"column:product_name;primaryKey"`
+	Quantity    int       `gorm:"column:quantity"`
+	AddedAt     time.Time `gorm:"column:added_at"`
+}
+
+type PricingRuleDBModel struct {
+	ID               int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Name             string    `gorm:"column:name" json:"name"`
+	Category         string    `gorm:"column:category" json:"category"`
+	StockThreshold   int       `gorm:"column:stock_threshold" json:"stock_threshold"`
+	SurchargePercent int       `gorm:"column:surcharge_percent" json:"surcharge_percent"`
+	MaxPricePercent  int       `gorm:"column:max_price_percent" json:"max_price_percent"`
+	CooldownSeconds  int       `gorm:"column:cooldown_seconds" json:"cooldown_seconds"`
+	Enabled          bool      `gorm:"column:enabled" json:"enabled"`
+	CreatedAt        time.Time `gorm:"column:created_at" json:"created_at"`
 }
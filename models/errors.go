@@ -1,11 +1,74 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"sort"
+	"strings"
+)
 
 var (
 	ErrProductNotFound        = errors.New("product not found")
 	ErrProductAlreadyExists   = errors.New("product already exists")
 	ErrInvalidOperaton        = errors.New("invalid operation")
 	ErrInvalidQuantity        = errors.New("invalid quantity value")
+	ErrInvalidPrice           = errors.New("invalid price value")
 	ErrMatchingRecordNotFound = errors.New("matching record not found")
+	ErrEmptyOrder             = errors.New("order has no lines")
+	ErrDuplicateOrderLine     = errors.New("duplicate order line")
+	ErrInvalidOrderLine       = errors.New("invalid order line")
+	ErrCartItemNotFound       = errors.New("cart item not found")
+	ErrCartEmpty              = errors.New("cart is empty")
+	ErrOrderNotFound          = errors.New("order not found")
+	ErrInvalidOrderStatus     = errors.New("invalid order status")
+	ErrInvalidTransition      = errors.New("order status transition not allowed")
+	ErrVersionConflict        = errors.New("inventory was changed since it was read")
+	ErrPricingRuleNotFound    = errors.New("pricing rule not found")
+	ErrInvalidPricingRule     = errors.New("invalid pricing rule")
+	ErrUserNotFound           = errors.New("user not found")
+	ErrInvalidCredentials     = errors.New("invalid username or password")
+	ErrTooManyAttempts        = errors.New("too many failed sign in attempts, try again later")
+	ErrAccountNotLocked       = errors.New("account is not locked")
+	ErrSessionNotFound        = errors.New("session not found")
+	ErrAPITokenNotFound       = errors.New("api token not found")
+	ErrInvalidAPIToken        = errors.New("invalid or expired api token")
+	ErrInvalidScope           = errors.New("invalid scope")
+	ErrScopeNotGranted        = errors.New("scope is not granted to the user")
+	ErrUnsupportedGrantType   = errors.New("unsupported grant type")
+	ErrInvalidAccessToken     = errors.New("invalid or expired access token")
+	ErrInvalidRefreshToken    = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused     = errors.New("refresh token was already used, its sessions are revoked")
+	ErrTOTPAlreadyEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled         = errors.New("two-factor authentication is not enabled")
+	ErrTOTPNotEnrolled        = errors.New("two-factor authentication enrollment was not started")
+	ErrInvalidTOTPCode        = errors.New("invalid two-factor authentication code")
+	ErrTOTPCodeRequired       = errors.New("two-factor authentication code required")
+	ErrOIDCNotConfigured      = errors.New("single sign-on is not configured")
+	ErrOIDCSignInFailed       = errors.New("single sign-on failed, try again")
+	ErrOIDCEmailMissing       = errors.New("the identity provider didn't share a valid, verified email address")
+	ErrOIDCAccountConflict    = errors.New("an account with the email address exists, sign in with its password to use it")
+	ErrAlreadyAdmin           = errors.New("user is already an admin")
+	ErrNotAdmin               = errors.New("user is not an admin")
+	ErrLastAdmin              = errors.New("the last admin can't be removed")
+	ErrInvalidRole            = errors.New("invalid role")
+	ErrRoleAlreadyAssigned    = errors.New("role is already assigned to the user")
+	ErrRoleNotAssigned        = errors.New("role is not assigned to the user")
+	ErrInvalidToken           = errors.New("invalid or expired token")
+	ErrEmailAlreadyVerified   = errors.New("email is already verified")
+	ErrInvalidMigration       = errors.New("invalid migration file")
+	ErrUnknownMigration       = errors.New("database has a migration this build doesn't know")
+	ErrMigrationChecksum      = errors.New("applied migration was modified")
+	ErrInvalidImportHeader    = errors.New("the header must be product_name,category,price,quantity")
 )
+
+// ValidationError maps every invalid field of a request to what is wrong with it.
+type ValidationError map[string]string
+
+func (ve ValidationError) Error() string {
+	fields := []string{}
+	for field := range ve {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return "invalid fields: " + strings.Join(fields, ", ")
+}
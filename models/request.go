@@ -1,10 +1,16 @@
 package models
 
+import (
+	"io"
+	"time"
+)
+
 type CreateProductRequest struct {
 	ProductName     string `json:"product_name"`
 	Category        string `json:"category"`
 	Price           int    `json:"price"`
 	InitialQuantity int    `json:"initial_quantity"`
+	CreatedBy       string `json:"-"`
 }
 
 type DeleteProductRequest struct {
@@ -18,22 +24,224 @@ type UpdateProductRequest struct {
 }
 
 type UpdateInventoryRequest struct {
+	ProductName     string `json:"product_name"`
+	Operation       string `json:"operation"`
+	Quantity        int    `json:"quantity"`
+	ExpectedVersion *int   `json:"expected_version"`
+	UpdatedBy       string `json:"-"`
+}
+
+// SetInventoryRequest sets the stock of the product to an absolute quantity.
+type SetInventoryRequest struct {
+	ProductName string `json:"product_name"`
+	Quantity    int    `json:"quantity"`
+	UpdatedBy   string `json:"-"`
+}
+
+// ImportProductsRequest reads a csv with the header product_name,category,price,quantity from Input.
+type ImportProductsRequest struct {
+	Input      io.Reader `json:"-"`
+	ImportedBy string    `json:"-"`
+}
+
+type OrderLineRequest struct {
 	ProductName string `json:"product_name"`
-	Operation   string `json:"operation"`
 	Quantity    int    `json:"quantity"`
 }
 
 type CreateOrderRequest struct {
-	Username            string         `json:"username"`
-	ProductsAndQuantity map[string]int `json:"product_and_quantity"`
+	Username            string             `json:"username"`
+	ProductsAndQuantity map[string]int     `json:"product_and_quantity"`
+	Lines               []OrderLineRequest `json:"lines"`
 }
 
 type GetOrdersForAdminRequest struct {
 	Username    string `json:"username"`
 	ProductName string `json:"product_name"`
+	Status      string `json:"status"`
 	SortBy      string `json:"sort_by"`
 }
 
+type UpdateOrderStatusRequest struct {
+	OrderID string `json:"order_id"`
+	Status  string `json:"status"`
+}
+
+type CancelOrderRequest struct {
+	OrderID     string `json:"order_id"`
+	CancelledBy string `json:"cancelled_by"`
+	Reason      string `json:"reason"`
+	AsAdmin     bool   `json:"-"`
+}
+
 type GetProductsRequest struct {
-	Category string `json:"category"`
+	ProductName string `json:"product_name"`
+	Category    string `json:"category"`
+}
+
+type GetPriceHistoryRequest struct {
+	ProductName string `json:"product_name"`
+}
+
+type GetInventoryRequest struct {
+	ProductName string `json:"product_name"`
+}
+
+type GetInventoryLedgerRequest struct {
+	ProductName string `json:"product_name"`
+}
+
+type AddCartItemRequest struct {
+	Username    string `json:"username"`
+	ProductName string `json:"product_name"`
+	Quantity    int    `json:"quantity"`
+}
+
+type UpdateCartItemRequest struct {
+	Username    string `json:"username"`
+	ProductName string `json:"product_name"`
+	Quantity    int    `json:"quantity"`
+}
+
+type RemoveCartItemRequest struct {
+	Username    string `json:"username"`
+	ProductName string `json:"product_name"`
+}
+
+type CreatePricingRuleRequest struct {
+	Name             string `json:"name"`
+	Category         string `json:"category"`
+	StockThreshold   int    `json:"stock_threshold"`
+	SurchargePercent int    `json:"surcharge_percent"`
+	MaxPricePercent  int    `json:"max_price_percent"`
+	CooldownSeconds  int    `json:"cooldown_seconds"`
+	Enabled          bool   `json:"enabled"`
+}
+
+type UpdatePricingRuleRequest struct {
+	ID               int64   `json:"id"`
+	Name             *string `json:"name"`
+	Category         *string `json:"category"`
+	StockThreshold   *int    `json:"stock_threshold"`
+	SurchargePercent *int    `json:"surcharge_percent"`
+	MaxPricePercent  *int    `json:"max_price_percent"`
+	CooldownSeconds  *int    `json:"cooldown_seconds"`
+	Enabled          *bool   `json:"enabled"`
+}
+
+type DeletePricingRuleRequest struct {
+	ID int64 `json:"id"`
+}
+
+type RepriceRequest struct {
+	ProductName string `json:"product_name"`
+	DryRun      bool   `json:"dry_run"`
+}
+
+type GrantAdminRequest struct {
+	Username  string `json:"username"`
+	GrantedBy string `json:"-"`
+}
+
+type RevokeAdminRequest struct {
+	Username  string `json:"username"`
+	RevokedBy string `json:"-"`
+}
+
+type AssignRoleRequest struct {
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	AssignedBy string `json:"-"`
+}
+
+type UnassignRoleRequest struct {
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	UnassignedBy string `json:"-"`
+}
+
+type SignUpRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type ConfirmPasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type SignInRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	IP       string `json:"-"`
+}
+
+type RevokeSessionRequest struct {
+	ID       int64  `json:"id"`
+	Username string `json:"-"`
+}
+
+type RevokeAllSessionsRequest struct {
+	Username  string `json:"username"`
+	RevokedBy string `json:"-"`
+}
+
+// CreateAPITokenRequest asks for a token of the user, Scopes are user scopes or permissions the user holds. The token
+// never expires without ExpiresAt.
+type CreateAPITokenRequest struct {
+	Username  string     `json:"-"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type RevokeAPITokenRequest struct {
+	ID       int64  `json:"id"`
+	Username string `json:"-"`
+}
+
+// AuthTokenRequest follows the oauth token requests, GrantType is password with Username and Password, or
+// refresh_token with RefreshToken.
+type AuthTokenRequest struct {
+	GrantType    string `json:"grant_type" form:"grant_type"`
+	Username     string `json:"username" form:"username"`
+	Password     string `json:"password" form:"password"`
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	// TOTPCode is the code of the authenticator app or a recovery code, required by the password grant when the user
+	// enabled two-factor authentication
+	TOTPCode string `json:"totp_code" form:"totp_code"`
+	IP       string `json:"-" form:"-"`
+}
+
+type RevokeRefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+}
+
+// OIDCSignInRequest completes the sign in with the identity provider, CodeVerifier and Nonce are the ones the sign in
+// was started with.
+type OIDCSignInRequest struct {
+	Code         string
+	CodeVerifier string
+	Nonce        string
+}
+
+// VerifyTOTPRequest checks the Code, either a code of the authenticator app or a recovery code.
+type VerifyTOTPRequest struct {
+	Username string `json:"-"`
+	Code     string `json:"code"`
+	IP       string `json:"-"`
+}
+
+type UnlockAccountRequest struct {
+	Username   string `json:"username"`
+	UnlockedBy string `json:"-"`
 }
@@ -7,10 +7,165 @@ type OrdersResponse struct {
 	TotalCost  int       `json:"total_cost"`
 	Products   []string  `json:"products"`
 	Quantities []int     `json:"quantities"`
+	UnitPrices []int     `json:"unit_prices"`
+	Status     string    `json:"status"`
 	CreatedAt  time.Time `json:"created_at"`
 }
 
+type OrderLineResponse struct {
+	ProductName string `json:"product_name"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int    `json:"unit_price"`
+	LineTotal   int    `json:"line_total"`
+}
+
+type CreateOrderResponse struct {
+	OrderID   string              `json:"order_id"`
+	Username  string              `json:"username"`
+	TotalCost int                 `json:"total_cost"`
+	Lines     []OrderLineResponse `json:"lines"`
+	Status    string              `json:"status"`
+	CreatedAt time.Time           `json:"created_at"`
+}
+
 type ProductResponse struct {
 	Category string `json:"category"`
 	Price    int    `json:"price"`
 }
+
+type InventoryResponse struct {
+	ProductName     string `json:"product_name"`
+	QuantityInStock int    `json:"quantity_in_stock"`
+	Version         int    `json:"version"`
+}
+
+// SetInventoryResponse is the stock after the set, Changed is false when the stock already was the quantity.
+type SetInventoryResponse struct {
+	InventoryResponse
+	Changed bool `json:"changed"`
+}
+
+// ImportProductsResponse counts the products created and the existing ones skipped.
+type ImportProductsResponse struct {
+	Created int `json:"created"`
+	Skipped int `json:"skipped"`
+}
+
+type CartItemResponse struct {
+	ProductName     string `json:"product_name"`
+	Quantity        int    `json:"quantity"`
+	UnitPrice       int    `json:"unit_price"`
+	LineTotal       int    `json:"line_total"`
+	QuantityInStock int    `json:"quantity_in_stock"`
+	Available       bool   `json:"available"`
+}
+
+type CartResponse struct {
+	Items     []CartItemResponse `json:"items"`
+	TotalCost int                `json:"total_cost"`
+}
+
+type InventoryLedgerResponse struct {
+	ProductName     string                     `json:"product_name"`
+	Movements       []InventoryMovementDBModel `json:"movements"`
+	LedgerTotal     int                        `json:"ledger_total"`
+	QuantityInStock int                        `json:"quantity_in_stock"`
+	Discrepancy     int                        `json:"discrepancy"`
+	Reconciled      bool                       `json:"reconciled"`
+}
+
+type PriceChangeResponse struct {
+	ProductName     string   `json:"product_name"`
+	Category        string   `json:"category"`
+	BasePrice       int      `json:"base_price"`
+	CurrentPrice    int      `json:"current_price"`
+	NewPrice        int      `json:"new_price"`
+	QuantityInStock int      `json:"quantity_in_stock"`
+	Rules           []string `json:"rules"`
+	Applied         bool     `json:"applied"`
+}
+
+// SessionResponse describes a session of the user, Current is the one the request was made with.
+type SessionResponse struct {
+	ID         int64     `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type APITokenResponse struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateAPITokenResponse is the only time the token is shown, only its hash is stored.
+type CreateAPITokenResponse struct {
+	APITokenResponse
+	Token string `json:"token"`
+}
+
+type AuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// JWK is a public key of the jwt access tokens, in the json web key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+type JWKSResponse struct {
+	Keys []JWK `json:"keys"`
+}
+
+type TwoFactorStatusResponse struct {
+	Enabled bool `json:"enabled"`
+	// Required is true when a role of the user requires two-factor authentication
+	Required          bool  `json:"required"`
+	RecoveryCodesLeft int64 `json:"recovery_codes_left"`
+}
+
+// TOTPEnrollmentResponse is the secret to add to the authenticator app, typed in or scanned from the qr code of URI.
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type CSRFTokenResponse struct {
+	Token string `json:"csrf_token"`
+}
+
+type RoleResponse struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Assignable  bool     `json:"assignable"`
+}
+
+type UserResponse struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type ValidationErrorResponse struct {
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields"`
+}
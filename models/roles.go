@@ -0,0 +1,28 @@
+package models
+
+const (
+	PermissionDashboard      = "dashboard:view"
+	PermissionProductsWrite  = "products:write"
+	PermissionInventoryRead  = "inventory:read"
+	PermissionInventoryWrite = "inventory:write"
+	PermissionOrdersRead     = "orders:read"
+	PermissionOrdersWrite    = "orders:write"
+	PermissionPricingManage  = "pricing:manage"
+	PermissionAdminsManage   = "admins:manage"
+	PermissionUsersManage    = "users:manage"
+)
+
+// The scopes of what every user may do for themselves. No role grants them, but an api token needs them like it needs
+// the permissions.
+const (
+	ScopeOrdersPlace = "orders:place"
+	ScopeCartWrite   = "cart:write"
+)
+
+// RoleSuperAdmin is held by the users of the admins table, the other roles are assigned in user_roles.
+const (
+	RoleSuperAdmin     = "super_admin"
+	RoleCatalogManager = "catalog_manager"
+	RoleInventoryClerk = "inventory_clerk"
+	RoleOrderViewer    = "order_viewer"
+)
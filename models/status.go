@@ -0,0 +1,41 @@
+package models
+
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRefunded  = "refunded"
+)
+
+const (
+	MovementInitialStock        = "initial_stock"
+	MovementOrderSale           = "order_sale"
+	MovementAdminAdd            = "admin_add"
+	MovementAdminRemove         = "admin_remove"
+	MovementCancellationRestock = "cancellation_restock"
+)
+
+const (
+	AdminChangeGrant  = "grant"
+	AdminChangeRevoke = "revoke"
+)
+
+const (
+	GrantTypePassword     = "password"
+	GrantTypeRefreshToken = "refresh_token"
+)
+
+// the failed sign ins are counted for the username tried and for the ip address they came from
+const (
+	LoginFailureAccount = "account"
+	LoginFailureIP      = "ip"
+	// the wrong second factor codes are counted for the account apart from its passwords
+	LoginFailureSecondFactor = "second_factor"
+)
+
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+)
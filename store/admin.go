@@ -3,13 +3,15 @@ package store
 import (
 	"github.com/NikhilSharmaWe/market/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AdminsStore interface {
-	CreateTable() error
 	Create(fr models.AdminDBModel) error
 	Update(updateMap, whereMap map[string]interface{}) error
 	Delete(whereMap map[string]interface{}) error
+	GetMany(whereMap map[string]interface{}) ([]models.AdminDBModel, error)
+	GetManyForUpdate(whereMap map[string]interface{}) ([]models.AdminDBModel, error)
 	IsExists(whereMap map[string]interface{}) (bool, error)
 	DB() *gorm.DB
 }
@@ -32,10 +34,6 @@ func (as *adminStore) DB() *gorm.DB {
 	return as.db
 }
 
-func (as *adminStore) CreateTable() error {
-	return as.db.Table(as.table()).AutoMigrate(models.AdminDBModel{})
-}
-
 func (as *adminStore) Create(fr models.AdminDBModel) error {
 	return as.db.Table(as.table()).Create(fr).Error
 }
@@ -48,6 +46,33 @@ func (as *adminStore) Delete(whereMap map[string]interface{}) error {
 	return as.db.Table(as.table()).Where(whereMap).Delete(nil).Error
 }
 
+func (as *adminStore) GetMany(whereMap map[string]interface{}) ([]models.AdminDBModel, error) {
+	resp := []models.AdminDBModel{}
+	if err := as.db.Table(as.table()).Order("username").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
+
+// GetManyForUpdate is GetMany with the rows locked until the end of the surrounding transaction.
+func (as *adminStore) GetManyForUpdate(whereMap map[string]interface{}) ([]models.AdminDBModel, error) {
+	resp := []models.AdminDBModel{}
+	if err := as.db.Table(as.table()).Clauses(clause.Locking{Strength: "UPDATE"}).Order("username").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
+
 func (as *adminStore) IsExists(whereMap map[string]interface{}) (bool, error) {
 	var count int64
 	err := as.db.Table(as.table()).Where(whereMap).Count(&count).Error
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type AdminChangeStore interface {
+	Create(fr models.AdminChangeDBModel) error
+	GetMany(whereMap map[string]interface{}) ([]models.AdminChangeDBModel, error)
+	DB() *gorm.DB
+}
+
+type adminChangeStore struct {
+	db *gorm.DB
+}
+
+func NewAdminChangeStore(db *gorm.DB) AdminChangeStore {
+	return &adminChangeStore{
+		db: db,
+	}
+}
+
+func (acs *adminChangeStore) table() string {
+	return "admin_changes"
+}
+
+func (acs *adminChangeStore) DB() *gorm.DB {
+	return acs.db
+}
+
+func (acs *adminChangeStore) Create(fr models.AdminChangeDBModel) error {
+	return acs.db.Table(acs.table()).Create(&fr).Error
+}
+
+func (acs *adminChangeStore) GetMany(whereMap map[string]interface{}) ([]models.AdminChangeDBModel, error) {
+	resp := []models.AdminChangeDBModel{}
+	if err := acs.db.Table(acs.table()).Order("id").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
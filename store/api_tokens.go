@@ -0,0 +1,72 @@
+package store
+
+import (
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type APITokenStore interface {
+	Create(fr models.APITokenDBModel) (*models.APITokenDBModel, error)
+	Update(updateMap, whereMap map[string]interface{}) error
+	Delete(whereMap map[string]interface{}) error
+	GetOne(whereMap map[string]interface{}) (*models.APITokenDBModel, error)
+	GetMany(whereMap map[string]interface{}) ([]models.APITokenDBModel, error)
+	DB() *gorm.DB
+}
+
+type apiTokenStore struct {
+	db *gorm.DB
+}
+
+func NewAPITokenStore(db *gorm.DB) APITokenStore {
+	return &apiTokenStore{
+		db: db,
+	}
+}
+
+func (ats *apiTokenStore) table() string {
+	return "api_tokens"
+}
+
+func (ats *apiTokenStore) DB() *gorm.DB {
+	return ats.db
+}
+
+// Create returns the created token so the caller gets the id assigned by the database.
+func (ats *apiTokenStore) Create(fr models.APITokenDBModel) (*models.APITokenDBModel, error) {
+	if err := ats.db.Table(ats.table()).Create(&fr).Error; err != nil {
+		return nil, err
+	}
+
+	return &fr, nil
+}
+
+func (ats *apiTokenStore) Update(updateMap, whereMap map[string]interface{}) error {
+	return ats.db.Table(ats.table()).Where(whereMap).Updates(updateMap).Error
+}
+
+func (ats *apiTokenStore) Delete(whereMap map[string]interface{}) error {
+	return ats.db.Table(ats.table()).Where(whereMap).Delete(nil).Error
+}
+
+func (ats *apiTokenStore) GetOne(whereMap map[string]interface{}) (*models.APITokenDBModel, error) {
+	var token models.APITokenDBModel
+	if err := ats.db.Table(ats.table()).Where(whereMap).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (ats *apiTokenStore) GetMany(whereMap map[string]interface{}) ([]models.APITokenDBModel, error) {
+	resp := []models.APITokenDBModel{}
+	if err := ats.db.Table(ats.table()).Order("id").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CartStore interface {
+	Create(fr models.CartItemDBModel) error
+	Add(fr models.CartItemDBModel) error
+	Update(updateMap, whereMap map[string]interface{}) error
+	Delete(whereMap map[string]interface{}) error
+	GetOne(whereMap map[string]interface{}) (*models.CartItemDBModel, error)
+	GetMany(whereMap map[string]interface{}) ([]models.CartItemDBModel, error)
+	IsExists(whereMap map[string]interface{}) (bool, error)
+	DB() *gorm.DB
+}
+
+type cartStore struct {
+	db *gorm.DB
+}
+
+func NewCartStore(db *gorm.DB) CartStore {
+	return &cartStore{
+		db: db,
+	}
+}
+
+func (cs *cartStore) table() string {
+	return "cart_items"
+}
+
+func (cs *cartStore) DB() *gorm.DB {
+	return cs.db
+}
+
+func (cs *cartStore) Create(fr models.CartItemDBModel) error {
+	return cs.db.Table(cs.table()).Create(fr).Error
+}
+
+// Add creates the item, or adds its quantity to the one in the cart with a single statement so concurrent adds of the
+// same product all count.
+func (cs *cartStore) Add(fr models.CartItemDBModel) error {
+	return cs.db.Table(cs.table()).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "username"}, {Name: "product_name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"quantity": gorm.Expr("cart_items.quantity + EXCLUDED.quantity")}),
+	}).Create(&fr).Error
+}
+
+func (cs *cartStore) Update(updateMap, whereMap map[string]interface{}) error {
+	return cs.db.Table(cs.table()).Where(whereMap).Updates(updateMap).Error
+}
+
+func (cs *cartStore) Delete(whereMap map[string]interface{}) error {
+	return cs.db.Table(cs.table()).Where(whereMap).Delete(nil).Error
+}
+
+func (cs *cartStore) GetOne(whereMap map[string]interface{}) (*models.CartItemDBModel, error) {
+	var item models.CartItemDBModel
+	if err := cs.db.Table(cs.table()).Where(whereMap).First(&item).Error; err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (cs *cartStore) GetMany(whereMap map[string]interface{}) ([]models.CartItemDBModel, error) {
+	resp := []models.CartItemDBModel{}
+	if err := cs.db.Table(cs.table()).Order("product_name").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
+
+func (cs *cartStore) IsExists(whereMap map[string]interface{}) (bool, error) {
+	var count int64
+	err := cs.db.Table(cs.table()).Where(whereMap).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	if count == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
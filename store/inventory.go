@@ -6,11 +6,11 @@ import (
 )
 
 type InventoryStore interface {
-	CreateTable() error
 	Create(fr models.InventoryDBModel) error
 	Update(updateMap, whereMap map[string]interface{}) error
 	Delete(whereMap map[string]interface{}) error
 	GetOne(whereMap map[string]interface{}) (*models.InventoryDBModel, error)
+	Adjust(productName string, delta int, expectedVersion *int) (bool, error)
 	DB() *gorm.DB
 }
 
@@ -32,11 +32,6 @@ func (is *inventoryStore) DB() *gorm.DB {
 	return is.db
 }
 
-func (is *inventoryStore) CreateTable() error {
-	return is.db.Table(is.table()).AutoMigrate(models.InventoryDBModel{})
-
-}
-
 func (is *inventoryStore) Create(fr models.InventoryDBModel) error {
 	return is.db.Table(is.table()).Create(fr).Error
 }
@@ -57,3 +52,24 @@ func (is *inventoryStore) GetOne(whereMap map[string]interface{}) (*models.Inven
 
 	return &inventory, nil
 }
+
+// Adjust adds delta, which may be negative, to the stock of the product and bumps its version in a single
+// conditional update, so the stock can never go below zero however many writers run concurrently. When
+// expectedVersion is set the update only applies to that version of the row. It reports false when nothing
+// was updated.
+func (is *inventoryStore) Adjust(productName string, delta int, expectedVersion *int) (bool, error) {
+	query := is.db.Table(is.table()).Where("product_name = ? AND quantity_in_stock + ? >= 0", productName, delta)
+	if expectedVersion != nil {
+		query = query.Where("version = ?", *expectedVersion)
+	}
+
+	result := query.Updates(map[string]interface{}{
+		"quantity_in_stock": gorm.Expr("quantity_in_stock + ?", delta),
+		"version":           gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type InventoryMovementStore interface {
+	Create(fr models.InventoryMovementDBModel) error
+	GetMany(whereMap map[string]interface{}) ([]models.InventoryMovementDBModel, error)
+	GetSince(productName string, since time.Time) ([]models.InventoryMovementDBModel, error)
+	DB() *gorm.DB
+}
+
+type inventoryMovementStore struct {
+	db *gorm.DB
+}
+
+func NewInventoryMovementStore(db *gorm.DB) InventoryMovementStore {
+	return &inventoryMovementStore{
+		db: db,
+	}
+}
+
+func (ims *inventoryMovementStore) table() string {
+	return "inventory_movements"
+}
+
+func (ims *inventoryMovementStore) DB() *gorm.DB {
+	return ims.db
+}
+
+func (ims *inventoryMovementStore) Create(fr models.InventoryMovementDBModel) error {
+	return ims.db.Table(ims.table()).Create(&fr).Error
+}
+
+func (ims *inventoryMovementStore) GetMany(whereMap map[string]interface{}) ([]models.InventoryMovementDBModel, error) {
+	resp := []models.InventoryMovementDBModel{}
+	if err := ims.db.Table(ims.table()).Order("id").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
+
+// GetSince returns the movements of the product recorded at or after the time.
+func (ims *inventoryMovementStore) GetSince(productName string, since time.Time) ([]models.InventoryMovementDBModel, error) {
+	resp := []models.InventoryMovementDBModel{}
+	if err := ims.db.Table(ims.table()).Where("product_name = ? AND created_at >= ?", productName, since).Order("id").Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LoginFailureStore interface {
+	CreateIfNotExists(fr models.LoginFailureDBModel) error
+	Update(updateMap, whereMap map[string]interface{}) error
+	Delete(whereMap map[string]interface{}) error
+	GetOne(whereMap map[string]interface{}) (*models.LoginFailureDBModel, error)
+	GetOneForUpdate(whereMap map[string]interface{}) (*models.LoginFailureDBModel, error)
+	GetLocked(at time.Time) ([]models.LoginFailureDBModel, error)
+	DB() *gorm.DB
+}
+
+type loginFailureStore struct {
+	db *gorm.DB
+}
+
+func NewLoginFailureStore(db *gorm.DB) LoginFailureStore {
+	return &loginFailureStore{
+		db: db,
+	}
+}
+
+func (lfs *loginFailureStore) table() string {
+	return "login_failures"
+}
+
+func (lfs *loginFailureStore) DB() *gorm.DB {
+	return lfs.db
+}
+
+// CreateIfNotExists creates the row unless one exists for the kind and subject, so concurrent failures can all lock
+// the same row afterwards.
+func (lfs *loginFailureStore) CreateIfNotExists(fr models.LoginFailureDBModel) error {
+	return lfs.db.Table(lfs.table()).Clauses(clause.OnConflict{DoNothing: true}).Create(&fr).Error
+}
+
+func (lfs *loginFailureStore) Update(updateMap, whereMap map[string]interface{}) error {
+	return lfs.db.Table(lfs.table()).Where(whereMap).Updates(updateMap).Error
+}
+
+func (lfs *loginFailureStore) Delete(whereMap map[string]interface{}) error {
+	return lfs.db.Table(lfs.table()).Where(whereMap).Delete(nil).Error
+}
+
+func (lfs *loginFailureStore) GetOne(whereMap map[string]interface{}) (*models.LoginFailureDBModel, error) {
+	var failure models.LoginFailureDBModel
+	if err := lfs.db.Table(lfs.table()).Where(whereMap).First(&failure).Error; err != nil {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+func (lfs *loginFailureStore) GetOneForUpdate(whereMap map[string]interface{}) (*models.LoginFailureDBModel, error) {
+	var failure models.LoginFailureDBModel
+	if err := lfs.db.Table(lfs.table()).Clauses(clause.Locking{Strength: "UPDATE"}).Where(whereMap).First(&failure).Error; err != nil {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+// GetLocked returns the accounts and addresses still locked at the time.
+func (lfs *loginFailureStore) GetLocked(at time.Time) ([]models.LoginFailureDBModel, error) {
+	resp := []models.LoginFailureDBModel{}
+	if err := lfs.db.Table(lfs.table()).Where("locked_until > ?", at).Order("kind, subject").Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
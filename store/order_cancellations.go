@@ -0,0 +1,63 @@
+package store
+
+import (
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type OrderCancellationStore interface {
+	Create(fr models.OrderCancellationDBModel) error
+	Delete(whereMap map[string]interface{}) error
+	GetOne(whereMap map[string]interface{}) (*models.OrderCancellationDBModel, error)
+	IsExists(whereMap map[string]interface{}) (bool, error)
+	DB() *gorm.DB
+}
+
+type orderCancellationStore struct {
+	db *gorm.DB
+}
+
+func NewOrderCancellationStore(db *gorm.DB) OrderCancellationStore {
+	return &orderCancellationStore{
+		db: db,
+	}
+}
+
+func (ocs *orderCancellationStore) table() string {
+	return "order_cancellations"
+}
+
+func (ocs *orderCancellationStore) DB() *gorm.DB {
+	return ocs.db
+}
+
+func (ocs *orderCancellationStore) Create(fr models.OrderCancellationDBModel) error {
+	return ocs.db.Table(ocs.table()).Create(fr).Error
+}
+
+func (ocs *orderCancellationStore) Delete(whereMap map[string]interface{}) error {
+	return ocs.db.Table(ocs.table()).Where(whereMap).Delete(nil).Error
+}
+
+func (ocs *orderCancellationStore) GetOne(whereMap map[string]interface{}) (*models.OrderCancellationDBModel, error) {
+	var cancellation models.OrderCancellationDBModel
+	if err := ocs.db.Table(ocs.table()).Where(whereMap).First(&cancellation).Error; err != nil {
+		return nil, err
+	}
+
+	return &cancellation, nil
+}
+
+func (ocs *orderCancellationStore) IsExists(whereMap map[string]interface{}) (bool, error) {
+	var count int64
+	err := ocs.db.Table(ocs.table()).Where(whereMap).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	if count == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
@@ -6,7 +6,6 @@ import (
 )
 
 type OrderPerProductStore interface {
-	CreateTable() error
 	Create(fr models.OrderPerProductDBModel) error
 	Update(updateMap, whereMap map[string]interface{}) error
 	Delete(whereMap map[string]interface{}) error
@@ -33,11 +32,6 @@ func (os *orderPerProductStore) DB() *gorm.DB {
 	return os.db
 }
 
-func (os *orderPerProductStore) CreateTable() error {
-	return os.db.Table(os.table()).AutoMigrate(models.OrderPerProductDBModel{})
-
-}
-
 func (os *orderPerProductStore) Create(fr models.OrderPerProductDBModel) error {
 	return os.db.Table(os.table()).Create(fr).Error
 }
@@ -3,14 +3,15 @@ package store
 import (
 	"github.com/NikhilSharmaWe/market/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type OrderStore interface {
-	CreateTable() error
 	Create(fr models.OrderDBModel) error
 	Update(updateMap, whereMap map[string]interface{}) error
 	Delete(whereMap map[string]interface{}) error
 	GetOne(whereMap map[string]interface{}) (*models.OrderDBModel, error)
+	GetOneForUpdate(whereMap map[string]interface{}) (*models.OrderDBModel, error)
 	GetMany(whereMap map[string]interface{}, orderBy string) ([]models.OrderDBModel, error)
 	DB() *gorm.DB
 }
@@ -33,10 +34,6 @@ func (os *orderStore) DB() *gorm.DB {
 	return os.db
 }
 
-func (os *orderStore) CreateTable() error {
-	return os.db.Table(os.table()).AutoMigrate(models.OrderDBModel{})
-}
-
 func (os *orderStore) Create(fr models.OrderDBModel) error {
 	return os.db.Table(os.table()).Create(fr).Error
 }
@@ -58,6 +55,16 @@ func (os *orderStore) GetOne(whereMap map[string]interface{}) (*models.OrderDBMo
 	return &order, nil
 }
 
+// GetOneForUpdate is GetOne with the row locked until the end of the surrounding transaction.
+func (os *orderStore) GetOneForUpdate(whereMap map[string]interface{}) (*models.OrderDBModel, error) {
+	var order models.OrderDBModel
+	if err := os.db.Table(os.table()).Clauses(clause.Locking{Strength: "UPDATE"}).Where(whereMap).First(&order).Error; err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
 func (os *orderStore) GetMany(whereMap map[string]interface{}, orderBy string) ([]models.OrderDBModel, error) {
 	resp := []models.OrderDBModel{}
 	if err := os.db.Table(os.table()).Order(orderBy).Where(whereMap).Find(&resp).Error; err != nil {
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type PriceHistoryStore interface {
+	Create(fr models.PriceHistoryDBModel) error
+	GetMany(whereMap map[string]interface{}) ([]models.PriceHistoryDBModel, error)
+	GetSince(productName string, since time.Time) ([]models.PriceHistoryDBModel, error)
+	DB() *gorm.DB
+}
+
+type priceHistoryStore struct {
+	db *gorm.DB
+}
+
+func NewPriceHistoryStore(db *gorm.DB) PriceHistoryStore {
+	return &priceHistoryStore{
+		db: db,
+	}
+}
+
+func (phs *priceHistoryStore) table() string {
+	return "product_price_history"
+}
+
+func (phs *priceHistoryStore) DB() *gorm.DB {
+	return phs.db
+}
+
+func (phs *priceHistoryStore) Create(fr models.PriceHistoryDBModel) error {
+	return phs.db.Table(phs.table()).Create(&fr).Error
+}
+
+func (phs *priceHistoryStore) GetMany(whereMap map[string]interface{}) ([]models.PriceHistoryDBModel, error) {
+	resp := []models.PriceHistoryDBModel{}
+	if err := phs.db.Table(phs.table()).Order("id").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
+
+// GetSince returns the price changes of the product made at or after the time.
+func (phs *priceHistoryStore) GetSince(productName string, since time.Time) ([]models.PriceHistoryDBModel, error) {
+	resp := []models.PriceHistoryDBModel{}
+	if err := phs.db.Table(phs.table()).Where("product_name = ? AND changed_at >= ?", productName, since).Order("id").Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type PricingRuleStore interface {
+	Create(fr models.PricingRuleDBModel) (*models.PricingRuleDBModel, error)
+	Update(updateMap, whereMap map[string]interface{}) error
+	Delete(whereMap map[string]interface{}) error
+	GetOne(whereMap map[string]interface{}) (*models.PricingRuleDBModel, error)
+	GetMany(whereMap map[string]interface{}) ([]models.PricingRuleDBModel, error)
+	IsExists(whereMap map[string]interface{}) (bool, error)
+	DB() *gorm.DB
+}
+
+type pricingRuleStore struct {
+	db *gorm.DB
+}
+
+func NewPricingRuleStore(db *gorm.DB) PricingRuleStore {
+	return &pricingRuleStore{
+		db: db,
+	}
+}
+
+func (prs *pricingRuleStore) table() string {
+	return "pricing_rules"
+}
+
+func (prs *pricingRuleStore) DB() *gorm.DB {
+	return prs.db
+}
+
+// Create returns the created rule so the caller gets the id assigned by the database.
+func (prs *pricingRuleStore) Create(fr models.PricingRuleDBModel) (*models.PricingRuleDBModel, error) {
+	if err := prs.db.Table(prs.table()).Create(&fr).Error; err != nil {
+		return nil, err
+	}
+
+	return &fr, nil
+}
+
+func (prs *pricingRuleStore) Update(updateMap, whereMap map[string]interface{}) error {
+	return prs.db.Table(prs.table()).Where(whereMap).Updates(updateMap).Error
+}
+
+func (prs *pricingRuleStore) Delete(whereMap map[string]interface{}) error {
+	return prs.db.Table(prs.table()).Where(whereMap).Delete(nil).Error
+}
+
+func (prs *pricingRuleStore) GetOne(whereMap map[string]interface{}) (*models.PricingRuleDBModel, error) {
+	var rule models.PricingRuleDBModel
+	if err := prs.db.Table(prs.table()).Where(whereMap).First(&rule).Error; err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+func (prs *pricingRuleStore) GetMany(whereMap map[string]interface{}) ([]models.PricingRuleDBModel, error) {
+	resp := []models.PricingRuleDBModel{}
+	if err := prs.db.Table(prs.table()).Order("id").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
+
+func (prs *pricingRuleStore) IsExists(whereMap map[string]interface{}) (bool, error) {
+	var count int64
+	err := prs.db.Table(prs.table()).Where(whereMap).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	if count == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
@@ -1,12 +1,14 @@
 package store
 
 import (
+	"time"
+
 	"github.com/NikhilSharmaWe/market/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProductStore interface {
-	CreateTable() error
 	Create(fr models.ProductDBModel) error
 	Update(updateMap, whereMap map[string]interface{}) error
 	Delete(whereMap map[string]interface{}) error
@@ -34,16 +36,59 @@ func (ps *productStore) DB() *gorm.DB {
 	return ps.db
 }
 
-func (ps *productStore) CreateTable() error {
-	return ps.db.Table(ps.table()).AutoMigrate(models.ProductDBModel{})
-}
-
+// Create records the initial price of the product in the price history.
 func (ps *productStore) Create(fr models.ProductDBModel) error {
-	return ps.db.Table(ps.table()).Create(fr).Error
+	return ps.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(ps.table()).Create(fr).Error; err != nil {
+			return err
+		}
+
+		return NewPriceHistoryStore(tx).Create(models.PriceHistoryDBModel{
+			ProductName: fr.ProductName,
+			OldPrice:    0,
+			NewPrice:    fr.Price,
+			ChangedAt:   time.Now(),
+		})
+	})
 }
 
+// Update records a price history entry for every matching product whose price is changed by the update.
 func (ps *productStore) Update(updateMap, whereMap map[string]interface{}) error {
-	return ps.db.Table(ps.table()).Where(whereMap).Updates(updateMap).Error
+	newPrice, ok := updateMap["price"].(int)
+	if !ok {
+		return ps.db.Table(ps.table()).Where(whereMap).Updates(updateMap).Error
+	}
+
+	return ps.db.Transaction(func(tx *gorm.DB) error {
+		products := []models.ProductDBModel{}
+		if err := tx.Table(ps.table()).Clauses(clause.Locking{Strength: "UPDATE"}).Where(whereMap).Find(&products).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Table(ps.table()).Where(whereMap).Updates(updateMap).Error; err != nil {
+			return err
+		}
+
+		priceHistoryStore := NewPriceHistoryStore(tx)
+		now := time.Now()
+
+		for _, product := range products {
+			if product.Price == newPrice {
+				continue
+			}
+
+			if err := priceHistoryStore.Create(models.PriceHistoryDBModel{
+				ProductName: product.ProductName,
+				OldPrice:    product.Price,
+				NewPrice:    newPrice,
+				ChangedAt:   now,
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
 func (ps *productStore) Delete(whereMap map[string]interface{}) error {
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type RecoveryCodeStore interface {
+	Create(codes []models.RecoveryCodeDBModel) error
+	Update(updateMap, whereMap map[string]interface{}) error
+	Delete(whereMap map[string]interface{}) error
+	GetOne(whereMap map[string]interface{}) (*models.RecoveryCodeDBModel, error)
+	Count(whereMap map[string]interface{}) (int64, error)
+	DB() *gorm.DB
+}
+
+type recoveryCodeStore struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeStore(db *gorm.DB) RecoveryCodeStore {
+	return &recoveryCodeStore{
+		db: db,
+	}
+}
+
+func (rcs *recoveryCodeStore) table() string {
+	return "recovery_codes"
+}
+
+func (rcs *recoveryCodeStore) DB() *gorm.DB {
+	return rcs.db
+}
+
+func (rcs *recoveryCodeStore) Create(codes []models.RecoveryCodeDBModel) error {
+	return rcs.db.Table(rcs.table()).Create(&codes).Error
+}
+
+func (rcs *recoveryCodeStore) Update(updateMap, whereMap map[string]interface{}) error {
+	return rcs.db.Table(rcs.table()).Where(whereMap).Updates(updateMap).Error
+}
+
+func (rcs *recoveryCodeStore) Delete(whereMap map[string]interface{}) error {
+	return rcs.db.Table(rcs.table()).Where(whereMap).Delete(nil).Error
+}
+
+func (rcs *recoveryCodeStore) GetOne(whereMap map[string]interface{}) (*models.RecoveryCodeDBModel, error) {
+	var code models.RecoveryCodeDBModel
+	if err := rcs.db.Table(rcs.table()).Where(whereMap).First(&code).Error; err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+func (rcs *recoveryCodeStore) Count(whereMap map[string]interface{}) (int64, error) {
+	var count int64
+	if err := rcs.db.Table(rcs.table()).Where(whereMap).Count(&count).Error; err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RefreshTokenStore interface {
+	Create(fr models.RefreshTokenDBModel) error
+	Update(updateMap, whereMap map[string]interface{}) error
+	Delete(whereMap map[string]interface{}) error
+	DeleteExpired(username string, at time.Time) error
+	GetOne(whereMap map[string]interface{}) (*models.RefreshTokenDBModel, error)
+	GetOneForUpdate(whereMap map[string]interface{}) (*models.RefreshTokenDBModel, error)
+	DB() *gorm.DB
+}
+
+type refreshTokenStore struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenStore(db *gorm.DB) RefreshTokenStore {
+	return &refreshTokenStore{
+		db: db,
+	}
+}
+
+func (rts *refreshTokenStore) table() string {
+	return "refresh_tokens"
+}
+
+func (rts *refreshTokenStore) DB() *gorm.DB {
+	return rts.db
+}
+
+func (rts *refreshTokenStore) Create(fr models.RefreshTokenDBModel) error {
+	return rts.db.Table(rts.table()).Create(&fr).Error
+}
+
+func (rts *refreshTokenStore) Update(updateMap, whereMap map[string]interface{}) error {
+	return rts.db.Table(rts.table()).Where(whereMap).Updates(updateMap).Error
+}
+
+func (rts *refreshTokenStore) Delete(whereMap map[string]interface{}) error {
+	return rts.db.Table(rts.table()).Where(whereMap).Delete(nil).Error
+}
+
+// DeleteExpired deletes the tokens of the user expired at the time.
+func (rts *refreshTokenStore) DeleteExpired(username string, at time.Time) error {
+	return rts.db.Table(rts.table()).Where("username = ? AND expires_at <= ?", username, at).Delete(nil).Error
+}
+
+func (rts *refreshTokenStore) GetOne(whereMap map[string]interface{}) (*models.RefreshTokenDBModel, error) {
+	var token models.RefreshTokenDBModel
+	if err := rts.db.Table(rts.table()).Where(whereMap).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// GetOneForUpdate locks the token until the end of the surrounding transaction so two refreshes can't both use it.
+func (rts *refreshTokenStore) GetOneForUpdate(whereMap map[string]interface{}) (*models.RefreshTokenDBModel, error) {
+	var token models.RefreshTokenDBModel
+	if err := rts.db.Table(rts.table()).Clauses(clause.Locking{Strength: "UPDATE"}).Where(whereMap).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
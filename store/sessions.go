@@ -0,0 +1,77 @@
+package store
+
+import (
+	"time"
+
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type SessionStore interface {
+	Create(fr models.SessionDBModel) error
+	Update(updateMap, whereMap map[string]interface{}) error
+	Delete(whereMap map[string]interface{}) error
+	DeleteExpired(username string, at, idleSince time.Time) error
+	GetOne(whereMap map[string]interface{}) (*models.SessionDBModel, error)
+	GetMany(whereMap map[string]interface{}) ([]models.SessionDBModel, error)
+	DB() *gorm.DB
+}
+
+type sessionStore struct {
+	db *gorm.DB
+}
+
+func NewSessionStore(db *gorm.DB) SessionStore {
+	return &sessionStore{
+		db: db,
+	}
+}
+
+func (ss *sessionStore) table() string {
+	return "sessions"
+}
+
+func (ss *sessionStore) DB() *gorm.DB {
+	return ss.db
+}
+
+func (ss *sessionStore) Create(fr models.SessionDBModel) error {
+	return ss.db.Table(ss.table()).Create(&fr).Error
+}
+
+func (ss *sessionStore) Update(updateMap, whereMap map[string]interface{}) error {
+	return ss.db.Table(ss.table()).Where(whereMap).Updates(updateMap).Error
+}
+
+func (ss *sessionStore) Delete(whereMap map[string]interface{}) error {
+	return ss.db.Table(ss.table()).Where(whereMap).Delete(nil).Error
+}
+
+// DeleteExpired deletes the sessions of the user expired at the time or unused since idleSince.
+func (ss *sessionStore) DeleteExpired(username string, at, idleSince time.Time) error {
+	return ss.db.Table(ss.table()).Where("username = ? AND (expires_at <= ? OR last_seen_at < ?)", username, at, idleSince).
+		Delete(nil).Error
+}
+
+func (ss *sessionStore) GetOne(whereMap map[string]interface{}) (*models.SessionDBModel, error) {
+	var session models.SessionDBModel
+	if err := ss.db.Table(ss.table()).Where(whereMap).First(&session).Error; err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetMany returns the sessions most recently used first.
+func (ss *sessionStore) GetMany(whereMap map[string]interface{}) ([]models.SessionDBModel, error) {
+	resp := []models.SessionDBModel{}
+	if err := ss.db.Table(ss.table()).Order("last_seen_at DESC").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type UserIdentityStore interface {
+	Create(fr models.UserIdentityDBModel) error
+	Delete(whereMap map[string]interface{}) error
+	GetOne(whereMap map[string]interface{}) (*models.UserIdentityDBModel, error)
+	DB() *gorm.DB
+}
+
+type userIdentityStore struct {
+	db *gorm.DB
+}
+
+func NewUserIdentityStore(db *gorm.DB) UserIdentityStore {
+	return &userIdentityStore{
+		db: db,
+	}
+}
+
+func (uis *userIdentityStore) table() string {
+	return "user_identities"
+}
+
+func (uis *userIdentityStore) DB() *gorm.DB {
+	return uis.db
+}
+
+func (uis *userIdentityStore) Create(fr models.UserIdentityDBModel) error {
+	return uis.db.Table(uis.table()).Create(&fr).Error
+}
+
+func (uis *userIdentityStore) Delete(whereMap map[string]interface{}) error {
+	return uis.db.Table(uis.table()).Where(whereMap).Delete(nil).Error
+}
+
+func (uis *userIdentityStore) GetOne(whereMap map[string]interface{}) (*models.UserIdentityDBModel, error) {
+	var identity models.UserIdentityDBModel
+	if err := uis.db.Table(uis.table()).Where(whereMap).First(&identity).Error; err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
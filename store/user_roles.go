@@ -0,0 +1,67 @@
+package store
+
+import (
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+)
+
+type UserRoleStore interface {
+	Create(fr models.UserRoleDBModel) error
+	Delete(whereMap map[string]interface{}) error
+	GetMany(whereMap map[string]interface{}) ([]models.UserRoleDBModel, error)
+	IsExists(whereMap map[string]interface{}) (bool, error)
+	DB() *gorm.DB
+}
+
+type userRoleStore struct {
+	db *gorm.DB
+}
+
+func NewUserRoleStore(db *gorm.DB) UserRoleStore {
+	return &userRoleStore{
+		db: db,
+	}
+}
+
+func (urs *userRoleStore) table() string {
+	return "user_roles"
+}
+
+func (urs *userRoleStore) DB() *gorm.DB {
+	return urs.db
+}
+
+func (urs *userRoleStore) Create(fr models.UserRoleDBModel) error {
+	return urs.db.Table(urs.table()).Create(&fr).Error
+}
+
+func (urs *userRoleStore) Delete(whereMap map[string]interface{}) error {
+	return urs.db.Table(urs.table()).Where(whereMap).Delete(nil).Error
+}
+
+func (urs *userRoleStore) GetMany(whereMap map[string]interface{}) ([]models.UserRoleDBModel, error) {
+	resp := []models.UserRoleDBModel{}
+	if err := urs.db.Table(urs.table()).Order("username, role").Where(whereMap).Find(&resp).Error; err != nil {
+		return resp, err
+	}
+
+	if len(resp) == 0 {
+		return resp, models.ErrMatchingRecordNotFound
+	}
+
+	return resp, nil
+}
+
+func (urs *userRoleStore) IsExists(whereMap map[string]interface{}) (bool, error) {
+	var count int64
+	err := urs.db.Table(urs.table()).Where(whereMap).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	if count == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"github.com/NikhilSharmaWe/market/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UserTokenStore interface {
+	Create(fr models.UserTokenDBModel) error
+	Update(updateMap, whereMap map[string]interface{}) error
+	Delete(whereMap map[string]interface{}) error
+	GetOneForUpdate(whereMap map[string]interface{}) (*models.UserTokenDBModel, error)
+	DB() *gorm.DB
+}
+
+type userTokenStore struct {
+	db *gorm.DB
+}
+
+func NewUserTokenStore(db *gorm.DB) UserTokenStore {
+	return &userTokenStore{
+		db: db,
+	}
+}
+
+func (uts *userTokenStore) table() string {
+	return "user_tokens"
+}
+
+func (uts *userTokenStore) DB() *gorm.DB {
+	return uts.db
+}
+
+func (uts *userTokenStore) Create(fr models.UserTokenDBModel) error {
+	return uts.db.Table(uts.table()).Create(&fr).Error
+}
+
+func (uts *userTokenStore) Update(updateMap, whereMap map[string]interface{}) error {
+	return uts.db.Table(uts.table()).Where(whereMap).Updates(updateMap).Error
+}
+
+func (uts *userTokenStore) Delete(whereMap map[string]interface{}) error {
+	return uts.db.Table(uts.table()).Where(whereMap).Delete(nil).Error
+}
+
+// GetOneForUpdate locks the token until the end of the surrounding transaction so it can only be used once.
+func (uts *userTokenStore) GetOneForUpdate(whereMap map[string]interface{}) (*models.UserTokenDBModel, error) {
+	var token models.UserTokenDBModel
+	if err := uts.db.Table(uts.table()).Clauses(clause.Locking{Strength: "UPDATE"}).Where(whereMap).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
@@ -3,14 +3,15 @@ package store
 import (
 	"github.com/NikhilSharmaWe/market/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UsersStore interface {
-	CreateTable() error
 	Create(fr models.UserDBModel) error
 	Update(updateMap, whereMap map[string]interface{}) error
 	Delete(whereMap map[string]interface{}) error
 	GetOne(whereMap map[string]interface{}) (*models.UserDBModel, error)
+	GetOneForUpdate(whereMap map[string]interface{}) (*models.UserDBModel, error)
 	IsExists(whereMap map[string]interface{}) (bool, error)
 	DB() *gorm.DB
 }
@@ -33,10 +34,6 @@ func (us *userStore) DB() *gorm.DB {
 	return us.db
 }
 
-func (us *userStore) CreateTable() error {
-	return us.db.Table(us.table()).AutoMigrate(models.UserDBModel{})
-}
-
 func (us *userStore) Create(fr models.UserDBModel) error {
 	return us.db.Table(us.table()).Create(fr).Error
 }
@@ -58,6 +55,16 @@ func (us *userStore) GetOne(whereMap map[string]interface{}) (*models.UserDBMode
 	return &user, nil
 }
 
+// GetOneForUpdate locks the user until the end of the surrounding transaction.
+func (us *userStore) GetOneForUpdate(whereMap map[string]interface{}) (*models.UserDBModel, error) {
+	var user models.UserDBModel
+	if err := us.db.Table(us.table()).Clauses(clause.Locking{Strength: "UPDATE"}).Where(whereMap).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 func (us *userStore) IsExists(whereMap map[string]interface{}) (bool, error) {
 	var count int64
 	err := us.db.Table(us.table()).Where(whereMap).Count(&count).Error